@@ -0,0 +1,174 @@
+package cartridges
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/cbott/GoEmulate/snapshot"
+)
+
+const (
+	// Value written to 0000-1FFF to switch A000-BFFF over to the IR port
+	HuC1IRSelect = 0x0E
+)
+
+// Hudson HuC1 Cartridge
+// Up to 1MiB ROM (64 banks) / 32KiB RAM (4 banks), infrared LED and sensor
+//
+// Banking works like MBC1 without the banking mode. There is no RAM enable, instead writing 0x0E to 0000-1FFF
+// replaces RAM at A000-BFFF with the IR port and any other value brings RAM back. Reading the IR port returns 0xC1
+// if the sensor sees light and 0xC0 if not, and writing it turns the LED on (bit 0 set) or off.
+type HuC1Cartridge struct {
+	CartridgeCore
+	// Whether A000-BFFF is the IR port rather than RAM
+	irMode bool
+	ledOn  bool
+	// Other side of the IR connection, nil if nothing is in front of the cartridge
+	infrared InfraredLink
+}
+
+func NewHuC1Cartridge(filename string, data []uint8) *HuC1Cartridge {
+	c := HuC1Cartridge{}
+	c.rom = data
+	c.filename = filename
+	c.numRomBanks = 1 << (data[ROMSizeAddress] + 1)
+	c.romBank = 1
+
+	ramSizeKey := data[RAMSizeAddress]
+	ramSize := ramSizeMap[ramSizeKey]
+	c.numRamBanks = uint8(ramSize / 8) // 8KiB per bank
+	// Initialize RAM banks
+	c.ram = make([][RAMBankSize]uint8, c.numRamBanks)
+
+	c.LoadRAM()
+
+	return &c
+}
+
+// SetInfrared connects the cartridge's IR LED and sensor to another device, or to nothing if link is nil
+func (c *HuC1Cartridge) SetInfrared(link InfraredLink) {
+	c.infrared = link
+	if link != nil {
+		link.SetLED(c.ledOn)
+	}
+}
+
+// Read a value from HuC1 ROM, RAM or the IR port
+func (c *HuC1Cartridge) ReadFrom(address uint16) uint8 {
+	// Read from ROM Bank 0 (fixed)
+	if address < ROMBankSize {
+		return c.rom[address]
+	}
+
+	// Read from ROM Bank 1 (switched)
+	if address < ROMEndAddress {
+		// The bank number can wrap around to bank 0, which is then mapped here as well
+		return c.rom[uint32(c.ROMBank())*ROMBankSize+uint32(address-ROMBankSize)]
+	}
+
+	// Read from RAM or IR
+	if address >= ExternalRAMStartAddress && address < ExternalRAMEndAddress {
+		if c.irMode {
+			if c.infrared != nil && c.infrared.Light() {
+				return IRLight
+			}
+			return IRNoLight
+		}
+		if c.numRamBanks == 0 {
+			return 0xFF
+		}
+		return c.ram[c.ramBank%c.numRamBanks][address-ExternalRAMStartAddress]
+	}
+
+	panic(fmt.Sprintf("Attempted to read from undefined Cartridge address 0x%X", address))
+}
+
+// Return the ROM bank currently mapped to 4000-7FFF
+func (c *HuC1Cartridge) ROMBank() uint16 {
+	bank := c.romBank
+	// ROM bank 0 cannot be selected, hardware will use bank 1 instead
+	if bank == 0 {
+		bank = 1
+	}
+	return bank & (c.numRomBanks - 1)
+}
+
+// Write a value to HuC1 control registers, RAM or the IR port
+func (c *HuC1Cartridge) WriteTo(address uint16, value uint8) {
+	switch address >> 12 {
+	case 0, 1:
+		// RAM/IR Select (0000-1FFF)
+		c.irMode = value == HuC1IRSelect
+	case 2, 3:
+		// ROM Bank Select (2000-3FFF)
+		c.romBank = uint16(value & 0x3F)
+	case 4, 5:
+		// RAM Bank Select (4000-5FFF)
+		c.ramBank = value & 0b11
+	case 0xA, 0xB:
+		// Write to RAM or IR (A000-BFFF)
+		if c.irMode {
+			c.ledOn = value&1 != 0
+			if c.infrared != nil {
+				c.infrared.SetLED(c.ledOn)
+			}
+			return
+		}
+		if c.numRamBanks == 0 {
+			return
+		}
+		c.ram[c.ramBank%c.numRamBanks][address-ExternalRAMStartAddress] = value
+	default:
+		// Our cartridge will ignore writes to invalid addresses
+		return
+	}
+}
+
+// Snapshot writes the cartridge state, including the IR port
+func (c *HuC1Cartridge) Snapshot(w io.Writer) error {
+	if err := c.CartridgeCore.Snapshot(w); err != nil {
+		return err
+	}
+	return snapshot.Write(w, c.irMode, c.ledOn)
+}
+
+// Restore reads state written by Snapshot
+func (c *HuC1Cartridge) Restore(r io.Reader) error {
+	if err := c.CartridgeCore.Restore(r); err != nil {
+		return err
+	}
+	if err := snapshot.Read(r, &c.irMode, &c.ledOn); err != nil {
+		return err
+	}
+	if c.infrared != nil {
+		c.infrared.SetLED(c.ledOn)
+	}
+	return nil
+}
+
+// Save cartridge RAM contents to a file
+func (c *HuC1Cartridge) SaveRAM() {
+	if c.numRamBanks == 0 {
+		log.Printf("Cartridge does not have any RAM banks to save\n")
+		return
+	}
+	err := WriteRAMToFile(c.filename, c.ram)
+	if err != nil {
+		log.Printf("Unable save RAM: %v\n", err)
+	}
+}
+
+// Load cartridge RAM from a file
+func (c *HuC1Cartridge) LoadRAM() {
+	// If cartridge does not have RAM we will skip any sort of loading
+	if c.numRamBanks == 0 {
+		return
+	}
+
+	err := ReadRAMFromFile(c.filename, c.ram)
+	if err != nil {
+		// We will be permissive here continue running after logging the issue
+		log.Printf("Unable to load RAM from file: %v\n", err)
+	}
+}
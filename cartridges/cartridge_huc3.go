@@ -0,0 +1,350 @@
+package cartridges
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/cbott/GoEmulate/snapshot"
+)
+
+// HuC3 register select values written to 0000-1FFF, choosing what A000-BFFF accesses
+const (
+	HuC3SelectRAMReadOnly = 0x0
+	HuC3SelectRAM         = 0xA
+	HuC3SelectCommand     = 0xB
+	HuC3SelectResponse    = 0xC
+	HuC3SelectSemaphore   = 0xD
+	HuC3SelectIR          = 0xE
+)
+
+// HuC3 RTC commands, in bits 4-6 of a byte written in command mode with the argument in bits 0-3
+const (
+	HuC3CommandRead        = 0x1 // Read the nibble at the memory address, then move to the next address
+	HuC3CommandWrite       = 0x3 // Write the argument to the memory address, then move to the next address
+	HuC3CommandAddressLow  = 0x4 // Set bits 0-3 of the memory address
+	HuC3CommandAddressHigh = 0x5 // Set bits 4-7 of the memory address
+	HuC3CommandExtended    = 0x6 // Run the extended command given by the argument
+
+	// Extended commands
+	HuC3ExtendedLatch  = 0x0 // Copy the clock to memory 00-05
+	HuC3ExtendedSet    = 0x1 // Set the clock from memory 00-05
+	HuC3ExtendedStatus = 0x2 // Respond with 1, the clock is running
+	HuC3ExtendedTone   = 0xE // Play the tone selected by the nibble at the memory address
+
+	MinutesPerDay = 24 * 60
+	// The minute and day counters are 3 nibbles each
+	huc3CounterMask = 0xFFF
+	huc3MemorySize  = 256
+
+	// Machine cycles (4MHz) in each minute counted by the clock, and the minutes before both counters wrap around
+	huc3CyclesPerSecond = 4194304
+	huc3CyclesPerMinute = 60 * huc3CyclesPerSecond
+	huc3ClockMinutes    = (huc3CounterMask + 1) * MinutesPerDay
+)
+
+// Hudson HuC3 Cartridge
+// Up to 2MiB ROM (128 banks) / 32KiB RAM (4 banks), real time clock, infrared LED and sensor, speaker
+//
+// The clock is reached through a small processor on the cartridge with 256 nibbles of memory, which the game talks
+// to one command at a time. Writing 0xB to 0000-1FFF selects command mode, where a byte written to A000-BFFF is a
+// command, and 0xC selects response mode, where reading A000-BFFF returns the last command in bits 4-6 along with
+// its result in bits 0-3. Commands take effect immediately, so the semaphore (0xD) always reads as ready.
+//
+// The clock counts minutes since midnight and days, held in memory 00-02 and 03-05 least significant nibble first
+// when latched. It runs from the console's cycles, so save states, rewind and movies always see the same readings,
+// and is saved to filename.rtc next to the RAM save file. Loading that file catches the clock up with the time the
+// emulator was closed, by the host's clock.
+//
+// 0xE selects the IR port, which works as on HuC1. 0xA selects RAM and 0x0 selects RAM for reading only.
+type HuC3Cartridge struct {
+	CartridgeCore
+	// What A000-BFFF currently accesses
+	mode uint8
+	// Memory of the clock processor, one nibble per byte, and the address commands use
+	memory  [huc3MemorySize]uint8
+	address uint8
+	// Last command and its result, returned in response mode
+	response uint8
+	// Clock reading in minutes since day 0, and machine cycles run towards the next minute
+	clockMinutes int
+	clockCycles  int
+	// Source of the host's time for the clock save file, replaced in tests
+	now func() time.Time
+
+	ledOn    bool
+	infrared InfraredLink
+	// Called when the game plays a tone on the cartridge's speaker, if set
+	onTone func(tone uint8)
+}
+
+func NewHuC3Cartridge(filename string, data []uint8) *HuC3Cartridge {
+	c := HuC3Cartridge{}
+	c.rom = data
+	c.filename = filename
+	c.numRomBanks = 1 << (data[ROMSizeAddress] + 1)
+	c.romBank = 1
+	c.now = time.Now
+
+	ramSizeKey := data[RAMSizeAddress]
+	ramSize := ramSizeMap[ramSizeKey]
+	c.numRamBanks = uint8(ramSize / 8) // 8KiB per bank
+	// Initialize RAM banks
+	c.ram = make([][RAMBankSize]uint8, c.numRamBanks)
+
+	c.LoadRAM()
+
+	return &c
+}
+
+// SetInfrared connects the cartridge's IR LED and sensor to another device, or to nothing if link is nil
+func (c *HuC3Cartridge) SetInfrared(link InfraredLink) {
+	c.infrared = link
+	if link != nil {
+		link.SetLED(c.ledOn)
+	}
+}
+
+// SetToneCallback sets a function to be called with the tone number each time the game plays a tone
+func (c *HuC3Cartridge) SetToneCallback(callback func(tone uint8)) {
+	c.onTone = callback
+}
+
+// Return the clock reading as minutes since midnight and days
+func (c *HuC3Cartridge) clock() (minutes int, days int) {
+	return c.clockMinutes % MinutesPerDay, c.clockMinutes / MinutesPerDay
+}
+
+// Set the clock to read the given minutes and days, starting a new minute
+func (c *HuC3Cartridge) setClock(minutes int, days int) {
+	c.clockMinutes = ((days&huc3CounterMask)*MinutesPerDay + minutes) % huc3ClockMinutes
+	c.clockCycles = 0
+}
+
+// RunCycles advances the clock by the specified number of machine cycles (4MHz)
+func (c *HuC3Cartridge) RunCycles(cycles int) {
+	c.clockCycles += cycles
+	for c.clockCycles >= huc3CyclesPerMinute {
+		c.clockCycles -= huc3CyclesPerMinute
+		c.clockMinutes = (c.clockMinutes + 1) % huc3ClockMinutes
+	}
+}
+
+// Read a value from HuC3 ROM, RAM or the clock and IR registers
+func (c *HuC3Cartridge) ReadFrom(address uint16) uint8 {
+	// Read from ROM Bank 0 (fixed)
+	if address < ROMBankSize {
+		return c.rom[address]
+	}
+
+	// Read from ROM Bank 1 (switched)
+	if address < ROMEndAddress {
+		// The bank number can wrap around to bank 0, which is then mapped here as well
+		return c.rom[uint32(c.ROMBank())*ROMBankSize+uint32(address-ROMBankSize)]
+	}
+
+	if address >= ExternalRAMStartAddress && address < ExternalRAMEndAddress {
+		switch c.mode {
+		case HuC3SelectRAM, HuC3SelectRAMReadOnly:
+			if c.numRamBanks == 0 {
+				return 0xFF
+			}
+			return c.ram[c.ramBank%c.numRamBanks][address-ExternalRAMStartAddress]
+		case HuC3SelectResponse:
+			return c.response
+		case HuC3SelectSemaphore:
+			return 0x01
+		case HuC3SelectIR:
+			if c.infrared != nil && c.infrared.Light() {
+				return IRLight
+			}
+			return IRNoLight
+		default:
+			return 0xFF
+		}
+	}
+
+	panic(fmt.Sprintf("Attempted to read from undefined Cartridge address 0x%X", address))
+}
+
+// Return the ROM bank currently mapped to 4000-7FFF
+func (c *HuC3Cartridge) ROMBank() uint16 {
+	bank := c.romBank
+	// ROM bank 0 cannot be selected, hardware will use bank 1 instead
+	if bank == 0 {
+		bank = 1
+	}
+	return bank & (c.numRomBanks - 1)
+}
+
+// Write a value to HuC3 control registers, RAM or the clock and IR registers
+func (c *HuC3Cartridge) WriteTo(address uint16, value uint8) {
+	switch address >> 12 {
+	case 0, 1:
+		// Register Select (0000-1FFF)
+		c.mode = value & 0xF
+	case 2, 3:
+		// ROM Bank Select (2000-3FFF)
+		c.romBank = uint16(value & 0x7F)
+	case 4, 5:
+		// RAM Bank Select (4000-5FFF)
+		c.ramBank = value & 0b11
+	case 0xA, 0xB:
+		switch c.mode {
+		case HuC3SelectRAM:
+			if c.numRamBanks == 0 {
+				return
+			}
+			c.ram[c.ramBank%c.numRamBanks][address-ExternalRAMStartAddress] = value
+		case HuC3SelectCommand:
+			c.runCommand((value>>4)&0b111, value&0xF)
+		case HuC3SelectIR:
+			c.ledOn = value&1 != 0
+			if c.infrared != nil {
+				c.infrared.SetLED(c.ledOn)
+			}
+		}
+	default:
+		// Our cartridge will ignore writes to invalid addresses
+		return
+	}
+}
+
+// Carry out a clock processor command
+func (c *HuC3Cartridge) runCommand(command uint8, argument uint8) {
+	var result uint8
+	switch command {
+	case HuC3CommandRead:
+		result = c.memory[c.address]
+		c.address++
+	case HuC3CommandWrite:
+		c.memory[c.address] = argument
+		c.address++
+	case HuC3CommandAddressLow:
+		c.address = c.address&0xF0 | argument
+	case HuC3CommandAddressHigh:
+		c.address = c.address&0x0F | argument<<4
+	case HuC3CommandExtended:
+		switch argument {
+		case HuC3ExtendedLatch:
+			minutes, days := c.clock()
+			for i := 0; i < 3; i++ {
+				c.memory[i] = uint8(minutes>>(4*i)) & 0xF
+				c.memory[3+i] = uint8(days>>(4*i)) & 0xF
+			}
+		case HuC3ExtendedSet:
+			var minutes, days int
+			for i := 0; i < 3; i++ {
+				minutes |= int(c.memory[i]) << (4 * i)
+				days |= int(c.memory[3+i]) << (4 * i)
+			}
+			c.setClock(minutes%MinutesPerDay, days)
+		case HuC3ExtendedStatus:
+			result = 1
+		case HuC3ExtendedTone:
+			if c.onTone != nil {
+				c.onTone(c.memory[c.address])
+			}
+		}
+	}
+	c.response = command<<4 | result&0xF
+}
+
+// Snapshot writes the cartridge state, including the clock processor and the clock
+func (c *HuC3Cartridge) Snapshot(w io.Writer) error {
+	if err := c.CartridgeCore.Snapshot(w); err != nil {
+		return err
+	}
+	return snapshot.Write(w, c.mode, &c.memory, c.address, c.response, c.clockMinutes, c.clockCycles, c.ledOn)
+}
+
+// Restore reads state written by Snapshot
+func (c *HuC3Cartridge) Restore(r io.Reader) error {
+	if err := c.CartridgeCore.Restore(r); err != nil {
+		return err
+	}
+	var minutes, cycles int
+	if err := snapshot.Read(r, &c.mode, &c.memory, &c.address, &c.response, &minutes, &cycles, &c.ledOn); err != nil {
+		return err
+	}
+	if minutes < 0 || minutes >= huc3ClockMinutes || cycles < 0 || cycles >= huc3CyclesPerMinute {
+		return fmt.Errorf("invalid HuC3 clock reading %d minutes %d cycles", minutes, cycles)
+	}
+	c.clockMinutes = minutes
+	c.clockCycles = cycles
+	if c.infrared != nil {
+		c.infrared.SetLED(c.ledOn)
+	}
+	return nil
+}
+
+// Generate a name for the clock save file based on the original ROM file name (filename.rtc)
+func getClockFileName(name string) string {
+	return name + ".rtc"
+}
+
+// Write the clock reading and the time it was taken to the clock save file
+func (c *HuC3Cartridge) writeClockFile() error {
+	f, err := os.Create(getClockFileName(c.filename))
+	if err != nil {
+		return err
+	}
+	minutes, days := c.clock()
+	err = snapshot.Write(f, uint16(minutes), uint16(days), c.now().Unix())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Read the clock save file, advancing the clock by the time since it was written
+func (c *HuC3Cartridge) readClockFile() error {
+	f, err := os.Open(getClockFileName(c.filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var minutes, days uint16
+	var saved int64
+	if err := snapshot.Read(f, &minutes, &days, &saved); err != nil {
+		return err
+	}
+	c.setClock(int(minutes)%MinutesPerDay, int(days))
+
+	// Catch up with the time since the file was written, this is the only time the host's clock is used
+	if elapsed := c.now().Sub(time.Unix(saved, 0)); elapsed > 0 {
+		c.clockMinutes = (c.clockMinutes + int(elapsed/time.Minute)%huc3ClockMinutes) % huc3ClockMinutes
+		c.RunCycles(int((elapsed%time.Minute)/time.Second) * huc3CyclesPerSecond)
+	}
+	return nil
+}
+
+// Save cartridge RAM contents and the clock to files
+func (c *HuC3Cartridge) SaveRAM() {
+	if c.numRamBanks > 0 {
+		err := WriteRAMToFile(c.filename, c.ram)
+		if err != nil {
+			log.Printf("Unable save RAM: %v\n", err)
+		}
+	}
+	if err := c.writeClockFile(); err != nil {
+		log.Printf("Unable to save clock: %v\n", err)
+	}
+}
+
+// Load cartridge RAM contents and the clock from files
+func (c *HuC3Cartridge) LoadRAM() {
+	if c.numRamBanks > 0 {
+		err := ReadRAMFromFile(c.filename, c.ram)
+		if err != nil {
+			// We will be permissive here continue running after logging the issue
+			log.Printf("Unable to load RAM from file: %v\n", err)
+		}
+	}
+	if err := c.readClockFile(); err != nil {
+		log.Printf("Unable to load clock from file: %v\n", err)
+	}
+}
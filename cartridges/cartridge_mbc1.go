@@ -1,14 +1,37 @@
 package cartridges
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"log"
+
+	"github.com/cbott/GoEmulate/snapshot"
+)
+
+const (
+	// Header location of the logo checked by the boot ROM
+	LogoAddress = 0x0104
+	// Multicarts hold 4 games of 256KiB (16 banks) each
+	MBC1MGameBanks = 16
+	mbc1MGameSize  = MBC1MGameBanks * ROMBankSize
 )
 
+// NintendoLogo must be in the header of every cartridge for the boot ROM to start it
+var NintendoLogo = []uint8{
+	0xCE, 0xED, 0x66, 0x66, 0xCC, 0x0D, 0x00, 0x0B, 0x03, 0x73, 0x00, 0x83, 0x00, 0x0C, 0x00, 0x0D,
+	0x00, 0x08, 0x11, 0x1F, 0x88, 0x89, 0x00, 0x0E, 0xDC, 0xCC, 0x6E, 0xE6, 0xDD, 0xDD, 0xD9, 0x99,
+	0xBB, 0xBB, 0x67, 0x63, 0x6E, 0x0E, 0xEC, 0xCC, 0xDD, 0xDC, 0x99, 0x9F, 0xBB, 0xB9, 0x33, 0x3E,
+}
+
 // Memory Bank Controller 1 Cartridge
 // Up to 2MiB ROM (128 banks) / 8KiB RAM (1 bank)
 // OR
 // Up to 512KiB ROM (32 banks) / 32KiB RAM (4 banks)
+//
+// Multicarts (MBC1M) wire bit 4 of the ROM bank register to nothing and the 2 bit register to ROM bank bits 4-5,
+// so each 256KiB game on the cartridge sees 16 banks. The menu selects a game with the 2 bit register in RAM
+// banking mode, which also maps the first bank of that game to 0000-3FFF
 type MemoryBankController1Cartridge struct {
 	CartridgeCore
 	// ramBank is used as most significant 2 bits of ROM bank if ramMode is false
@@ -16,8 +39,27 @@ type MemoryBankController1Cartridge struct {
 	// ramMode
 	// false -> ROM Banking Mode, up to 8KiB RAM and 2MiB ROM
 	// true  -> RAM Banking Mode, up to 32KiB RAM and 512KiB ROM
-	// TODO: account for this in save states
 	ramMode bool
+
+	// Whether this is a multicart with the bank registers rewired
+	multicart bool
+}
+
+// Guess whether a 1MiB MBC1 ROM is a multicart, which can't be told from its header.
+// Each game on a multicart has its own header, so the logo is found at the start of more than one 256KiB game
+func isMBC1Multicart(data []uint8) bool {
+	if len(data) != 4*mbc1MGameSize {
+		return false
+	}
+	games := 0
+	for start := 0; start < len(data); start += mbc1MGameSize {
+		logo := data[start+LogoAddress : start+LogoAddress+len(NintendoLogo)]
+		if bytes.Equal(logo, NintendoLogo) {
+			games++
+		}
+	}
+	// The menu's header and at least one game's
+	return games >= 2
 }
 
 func NewMBC1Cartridge(filename string, data []uint8) *MemoryBankController1Cartridge {
@@ -25,6 +67,7 @@ func NewMBC1Cartridge(filename string, data []uint8) *MemoryBankController1Cartr
 	c.rom = data
 	c.filename = filename
 	c.numRomBanks = 1 << (data[ROMSizeAddress] + 1)
+	c.multicart = isMBC1Multicart(data)
 
 	ramSizeKey := data[RAMSizeAddress]
 	ramSize := ramSizeMap[ramSizeKey]
@@ -38,32 +81,15 @@ func NewMBC1Cartridge(filename string, data []uint8) *MemoryBankController1Cartr
 }
 
 func (c *MemoryBankController1Cartridge) ReadFrom(address uint16) uint8 {
-	// Read from ROM Bank 0 (fixed)
-	// TODO: support multi-cartridges which can switch this bank in some cases
+	// Read from ROM Bank 0 (fixed, except on multicarts in RAM banking mode)
 	if address < ROMBankSize {
-		return c.rom[address]
+		return c.rom[uint32(c.LowROMBank())*ROMBankSize+uint32(address)]
 	}
 
 	// Bank 1 is switched
 	if address < ROMEndAddress {
-		var bank uint16 = c.romBank
-
-		// ROM bank 0 cannot be selected, hardware will use bank 1 instead
-		// Note: we intentionally do this before adding bits 4/5 below to match hardware behavior
-		if bank == 0 {
-			bank = 1
-		}
-
-		if !c.ramMode {
-			// We are in ROM Banking Mode, use ramBank as bits 4 and 5 of bank number
-			bank |= uint16(c.ramBank << 5)
-		}
-
-		// Mask bank to the number of banks available
-		bank &= c.numRomBanks - 1
-
-		offset := uint32(bank-1) * ROMBankSize
-		return c.rom[uint32(address)+offset]
+		// The bank number can wrap around to bank 0, which is then mapped here as well
+		return c.rom[uint32(c.ROMBank())*ROMBankSize+uint32(address-ROMBankSize)]
 	}
 
 	// RAM
@@ -90,6 +116,42 @@ func (c *MemoryBankController1Cartridge) ReadFrom(address uint16) uint8 {
 	panic(fmt.Sprintf("Attempted to read from undefined Cartridge address 0x%X", address))
 }
 
+// Return the ROM bank currently mapped to 0000-3FFF
+func (c *MemoryBankController1Cartridge) LowROMBank() uint16 {
+	if !c.multicart || !c.ramMode {
+		return 0
+	}
+	// First bank of the game selected by the 2 bit register
+	return uint16(c.ramBank<<4) & (c.numRomBanks - 1)
+}
+
+// Return the ROM bank currently mapped to 4000-7FFF
+func (c *MemoryBankController1Cartridge) ROMBank() uint16 {
+	var bank uint16 = c.romBank
+
+	// ROM bank 0 cannot be selected, hardware will use bank 1 instead
+	// Note: we intentionally do this before adding bits 4/5 below to match hardware behavior
+	if bank == 0 {
+		bank = 1
+	}
+
+	if c.multicart {
+		// The check for bank 0 sees all 5 bits, but bit 4 is not connected, so bank 0x10 maps the game's bank 0.
+		// The 2 bit register selects the game in both modes
+		bank &= 0xF
+		bank |= uint16(c.ramBank << 4)
+		return bank & (c.numRomBanks - 1)
+	}
+
+	if !c.ramMode {
+		// We are in ROM Banking Mode, use ramBank as bits 4 and 5 of bank number
+		bank |= uint16(c.ramBank << 5)
+	}
+
+	// Mask bank to the number of banks available
+	return bank & (c.numRomBanks - 1)
+}
+
 func (c *MemoryBankController1Cartridge) WriteTo(address uint16, value uint8) {
 	switch address >> 12 {
 	case 0, 1:
@@ -128,6 +190,22 @@ func (c *MemoryBankController1Cartridge) WriteTo(address uint16, value uint8) {
 	}
 }
 
+// Snapshot writes the cartridge state, including the banking mode
+func (c *MemoryBankController1Cartridge) Snapshot(w io.Writer) error {
+	if err := c.CartridgeCore.Snapshot(w); err != nil {
+		return err
+	}
+	return snapshot.Write(w, c.ramMode)
+}
+
+// Restore reads state written by Snapshot
+func (c *MemoryBankController1Cartridge) Restore(r io.Reader) error {
+	if err := c.CartridgeCore.Restore(r); err != nil {
+		return err
+	}
+	return snapshot.Read(r, &c.ramMode)
+}
+
 // Save cartridge RAM contents to a file
 func (c *MemoryBankController1Cartridge) SaveRAM() {
 	if c.numRamBanks == 0 {
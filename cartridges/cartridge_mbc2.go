@@ -0,0 +1,130 @@
+package cartridges
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/cbott/GoEmulate/snapshot"
+)
+
+const (
+	// MBC2 has 512 half-bytes of RAM built into the controller, repeated across A000-BFFF
+	MBC2RAMSize = 512
+	mbc2RAMMask = MBC2RAMSize - 1
+)
+
+// Memory Bank Controller 2 Cartridge
+// Up to 256KiB ROM (16 banks) / 512x4 bits of RAM inside the MBC
+type MemoryBankController2Cartridge struct {
+	CartridgeCore
+	// Built in RAM, one half-byte in the lower bits of each entry as saved by other emulators
+	mbcRAM [MBC2RAMSize]uint8
+}
+
+func NewMBC2Cartridge(filename string, data []uint8) *MemoryBankController2Cartridge {
+	c := MemoryBankController2Cartridge{}
+	c.rom = data
+	c.filename = filename
+	c.numRomBanks = 1 << (data[ROMSizeAddress] + 1)
+	c.romBank = 1
+
+	// The header lists no RAM as it is part of the MBC, so there are no RAM banks and mbcRAM is used instead
+	c.LoadRAM()
+
+	return &c
+}
+
+// Read a value from MBC2 ROM or RAM
+func (c *MemoryBankController2Cartridge) ReadFrom(address uint16) uint8 {
+	// Read from ROM Bank 0 (fixed)
+	if address < ROMBankSize {
+		return c.rom[address]
+	}
+
+	// Read from ROM Bank 1 (switched)
+	if address < ROMEndAddress {
+		// The bank number can wrap around to bank 0, which is then mapped here as well
+		return c.rom[uint32(c.ROMBank())*ROMBankSize+uint32(address-ROMBankSize)]
+	}
+
+	// Read from RAM
+	if address >= ExternalRAMStartAddress && address < ExternalRAMEndAddress {
+		// Reading from RAM when not enabled is undefined
+		if !c.ramEnabled {
+			return 0xFF
+		}
+		// Only address bits 0-8 are decoded, and the upper 4 bits are not driven so read as 1s
+		return 0xF0 | c.mbcRAM[address&mbc2RAMMask]
+	}
+
+	panic(fmt.Sprintf("Attempted to read from undefined Cartridge address 0x%X", address))
+}
+
+// Return the ROM bank currently mapped to 4000-7FFF
+func (c *MemoryBankController2Cartridge) ROMBank() uint16 {
+	return c.romBank & (c.numRomBanks - 1)
+}
+
+// Write a value to MBC2 control registers or RAM
+func (c *MemoryBankController2Cartridge) WriteTo(address uint16, value uint8) {
+	switch address >> 12 {
+	case 0, 1, 2, 3:
+		// Both registers are anywhere in 0000-3FFF, address bit 8 selects between them
+		if address&0x100 == 0 {
+			// RAM Enable
+			c.ramEnabled = (value & 0xF) == 0xA
+		} else {
+			// ROM Bank Select, bank 0 cannot be selected and hardware will use bank 1 instead
+			c.romBank = uint16(value & 0xF)
+			if c.romBank == 0 {
+				c.romBank = 1
+			}
+		}
+	case 0xA, 0xB:
+		// Write to RAM (A000-BFFF)
+		// Writing to RAM when not enabled does nothing
+		if !c.ramEnabled {
+			return
+		}
+		// Only the lower 4 bits are stored
+		c.mbcRAM[address&mbc2RAMMask] = value & 0xF
+	default:
+		// Our cartridge will ignore writes to invalid addresses
+		return
+	}
+}
+
+// Snapshot writes the cartridge state, including the built in RAM
+func (c *MemoryBankController2Cartridge) Snapshot(w io.Writer) error {
+	if err := c.CartridgeCore.Snapshot(w); err != nil {
+		return err
+	}
+	return snapshot.Write(w, &c.mbcRAM)
+}
+
+// Restore reads state written by Snapshot
+func (c *MemoryBankController2Cartridge) Restore(r io.Reader) error {
+	if err := c.CartridgeCore.Restore(r); err != nil {
+		return err
+	}
+	return snapshot.Read(r, &c.mbcRAM)
+}
+
+// Save cartridge RAM contents to a file of 512 bytes
+func (c *MemoryBankController2Cartridge) SaveRAM() {
+	// Note: saving is enabled here even if the physical cartridge wouldn't have had the battery to support it
+	err := writeSaveFile(c.filename, c.mbcRAM[:])
+	if err != nil {
+		log.Printf("Unable save RAM: %v\n", err)
+	}
+}
+
+// Load cartridge RAM from a file
+func (c *MemoryBankController2Cartridge) LoadRAM() {
+	err := readSaveFile(c.filename, c.mbcRAM[:])
+	if err != nil {
+		// We will be permissive here continue running after logging the issue
+		log.Printf("Unable to load RAM from file: %v\n", err)
+	}
+}
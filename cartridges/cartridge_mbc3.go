@@ -2,7 +2,10 @@ package cartridges
 
 import (
 	"fmt"
+	"io"
 	"log"
+
+	"github.com/cbott/GoEmulate/snapshot"
 )
 
 // Real Time Clock registers
@@ -65,14 +68,7 @@ func (c *MemoryBankController3Cartridge) ReadFrom(address uint16) uint8 {
 
 	// Read from ROM Bank 1 (switched)
 	if address < ROMEndAddress {
-		var bank uint16 = c.romBank
-
-		// ROM bank 0 cannot be selected, hardware will use bank 1 instead
-		if bank == 0 {
-			bank = 1
-		}
-
-		offset := uint32(bank-1) * ROMBankSize
+		offset := uint32(c.ROMBank()-1) * ROMBankSize
 		return c.rom[uint32(address)+offset]
 	}
 
@@ -101,6 +97,15 @@ func (c *MemoryBankController3Cartridge) ReadFrom(address uint16) uint8 {
 	panic(fmt.Sprintf("Attempted to read from undefined Cartridge address 0x%X", address))
 }
 
+// Return the ROM bank currently mapped to 4000-7FFF
+func (c *MemoryBankController3Cartridge) ROMBank() uint16 {
+	// ROM bank 0 cannot be selected, hardware will use bank 1 instead
+	if c.romBank == 0 {
+		return 1
+	}
+	return c.romBank
+}
+
 // Write a value to MBC3 control registers or RAM
 func (c *MemoryBankController3Cartridge) WriteTo(address uint16, value uint8) {
 	switch address >> 12 {
@@ -146,6 +151,22 @@ func (c *MemoryBankController3Cartridge) WriteTo(address uint16, value uint8) {
 	}
 }
 
+// Snapshot writes the cartridge state, including the real time clock registers
+func (c *MemoryBankController3Cartridge) Snapshot(w io.Writer) error {
+	if err := c.CartridgeCore.Snapshot(w); err != nil {
+		return err
+	}
+	return snapshot.Write(w, &c.rtc, &c.latchedrtc)
+}
+
+// Restore reads state written by Snapshot
+func (c *MemoryBankController3Cartridge) Restore(r io.Reader) error {
+	if err := c.CartridgeCore.Restore(r); err != nil {
+		return err
+	}
+	return snapshot.Read(r, &c.rtc, &c.latchedrtc)
+}
+
 // Save cartridge RAM contents to a file
 func (c *MemoryBankController3Cartridge) SaveRAM() {
 	if c.numRamBanks == 0 {
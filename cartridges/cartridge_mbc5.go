@@ -72,6 +72,12 @@ func (c *MemoryBankController5Cartridge) ReadFrom(address uint16) uint8 {
 	panic(fmt.Sprintf("Attempted to read from undefined Cartridge address 0x%X", address))
 }
 
+// Return the ROM bank currently mapped to 4000-7FFF
+func (c *MemoryBankController5Cartridge) ROMBank() uint16 {
+	// Unlike other types, bank 0 can be selected here
+	return c.romBank
+}
+
 // Write a value to MBC3 control registers or RAM
 func (c *MemoryBankController5Cartridge) WriteTo(address uint16, value uint8) {
 	switch address >> 12 {
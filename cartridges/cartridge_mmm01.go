@@ -0,0 +1,245 @@
+package cartridges
+
+import (
+	"fmt"
+	"io"
+	"log"
+
+	"github.com/cbott/GoEmulate/snapshot"
+)
+
+// MMM01 Cartridge
+// Up to 8MiB ROM (512 banks) / 128KiB RAM (16 banks), holding several games and a menu to choose between them
+//
+// At power on the MMM01 is unmapped and shows the last 32KiB of the ROM at 0000-7FFF, which is where the menu and
+// the MMM01's own header are. The menu sets up which part of the ROM and RAM the chosen game can see, then sets the
+// map enable bit. From then on the MMM01 acts as an MBC1 confined to that game, and the registers marked
+// (unmapped only) below can no longer be changed.
+//
+// 0000-1FFF	Bits 0-3 RAM enable (0xA), bits 4-5 RAM bank mask (unmapped only), bit 6 map enable (unmapped only)
+// 2000-3FFF	Bits 0-4 ROM bank bits 0-4, bits 5-6 ROM bank bits 5-6 (unmapped only)
+// 4000-5FFF	Bits 0-1 RAM bank bits 0-1, unmapped only: bits 2-3 RAM bits 2-3, 4-5 ROM bits 7-8, 6 mode protect
+// 6000-7FFF	Bit 0 mode (MBC1 style RAM banking mode), bits 2-5 ROM bank mask (unmapped only)
+//
+// Each set bit of the ROM bank mask fixes one of ROM bank bits 1-4 at the value the menu left it, and likewise the
+// RAM bank mask fixes RAM bank bits 0-1, so the game can only bank within its own part of the cartridge.
+// 0000-3FFF shows the game's first bank, made of the fixed bits alone.
+//
+// Dumps with the menu moved to the start of the file are not supported.
+type MMM01Cartridge struct {
+	CartridgeCore
+
+	// Whether the map enable bit has been set, locking the game's mapping
+	mapped bool
+	// ROM bank bits 0-4, 5-6 and 7-8
+	romLow, romMid, romHigh uint8
+	// RAM bank bits 0-1 and 2-3
+	ramLow, ramHigh uint8
+	// Masks fixing ROM bank bits 1-4 and RAM bank bits 0-1, in place
+	romMask, ramMask uint8
+	// MBC1 style banking mode, and whether the game is prevented from changing it
+	ramMode       bool
+	modeProtected bool
+}
+
+// Return where the MMM01's header is, in front of the menu in the last 32KiB of the ROM
+func mmm01HeaderOffset(data []uint8) int {
+	return len(data) - 2*ROMBankSize
+}
+
+// Return whether ROM data is for an MMM01 cartridge. The header at the start of the ROM belongs to the first game,
+// so the cartridge type is read from the menu's header
+func isMMM01(data []uint8) bool {
+	offset := mmm01HeaderOffset(data)
+	if offset < 0 {
+		return false
+	}
+	switch data[offset+CartridgeTypeAddress] {
+	case 0x0B, 0x0C, 0x0D:
+		return true
+	}
+	return false
+}
+
+func NewMMM01Cartridge(filename string, data []uint8) *MMM01Cartridge {
+	c := MMM01Cartridge{}
+	c.rom = data
+	c.filename = filename
+	header := data[mmm01HeaderOffset(data):]
+	c.numRomBanks = 1 << (header[ROMSizeAddress] + 1)
+
+	ramSizeKey := header[RAMSizeAddress]
+	ramSize := ramSizeMap[ramSizeKey]
+	c.numRamBanks = uint8(ramSize / 8) // 8KiB per bank
+	// Initialize RAM banks
+	c.ram = make([][RAMBankSize]uint8, c.numRamBanks)
+
+	c.LoadRAM()
+
+	return &c
+}
+
+// Return the game title from the MMM01's header, which is the menu's
+func (c *MMM01Cartridge) Title() string {
+	return parseTitle(c.rom[mmm01HeaderOffset(c.rom):])
+}
+
+// Return the global checksum from the MMM01's header
+func (c *MMM01Cartridge) GlobalChecksum() uint16 {
+	header := c.rom[mmm01HeaderOffset(c.rom):]
+	return uint16(header[GlobalChecksumAddress])<<8 | uint16(header[GlobalChecksumAddress+1])
+}
+
+// Read a value from MMM01 ROM or RAM
+func (c *MMM01Cartridge) ReadFrom(address uint16) uint8 {
+	// Read from ROM Bank 0 (the game's first bank once mapped)
+	if address < ROMBankSize {
+		return c.rom[uint32(c.LowROMBank())*ROMBankSize+uint32(address)]
+	}
+
+	// Read from ROM Bank 1 (switched)
+	if address < ROMEndAddress {
+		return c.rom[uint32(c.ROMBank())*ROMBankSize+uint32(address-ROMBankSize)]
+	}
+
+	// Read from RAM
+	if address >= ExternalRAMStartAddress && address < ExternalRAMEndAddress {
+		// Reading from RAM when not enabled is undefined
+		if !c.ramEnabled || c.numRamBanks == 0 {
+			return 0xFF
+		}
+		return c.ram[c.selectedRAMBank()][address-ExternalRAMStartAddress]
+	}
+
+	panic(fmt.Sprintf("Attempted to read from undefined Cartridge address 0x%X", address))
+}
+
+// Return the ROM bank bits which are not set by the game's ROM bank writes
+func (c *MMM01Cartridge) outerROMBank() uint16 {
+	return uint16(c.romHigh)<<7 | uint16(c.romMid)<<5 | uint16(c.romLow&(c.romMask<<1))
+}
+
+// Return the ROM bank currently mapped to 0000-3FFF
+func (c *MMM01Cartridge) LowROMBank() uint16 {
+	if !c.mapped {
+		// The menu's first bank
+		return c.numRomBanks - 2
+	}
+	return c.outerROMBank() & (c.numRomBanks - 1)
+}
+
+// Return the ROM bank currently mapped to 4000-7FFF
+func (c *MMM01Cartridge) ROMBank() uint16 {
+	if !c.mapped {
+		return c.numRomBanks - 1
+	}
+	bank := uint16(c.romHigh)<<7 | uint16(c.romMid)<<5 | uint16(c.romLow)
+	// As on MBC1 bank 0 of the game cannot be selected, only the bits the game can change are checked
+	if c.romLow&^(c.romMask<<1) == 0 {
+		bank |= 1
+	}
+	return bank & (c.numRomBanks - 1)
+}
+
+// Return the RAM bank currently mapped to A000-BFFF
+func (c *MMM01Cartridge) selectedRAMBank() uint8 {
+	low := c.ramLow
+	if !c.ramMode {
+		// In ROM banking mode only the fixed bits are used, as on MBC1 where bank 0 is always mapped
+		low &= c.ramMask
+	}
+	return (c.ramHigh<<2 | low) % c.numRamBanks
+}
+
+// Write a value to MMM01 control registers or RAM
+func (c *MMM01Cartridge) WriteTo(address uint16, value uint8) {
+	switch address >> 12 {
+	case 0, 1:
+		// RAM Enable (0000-1FFF)
+		c.ramEnabled = (value & 0xF) == 0xA
+		if !c.mapped {
+			c.ramMask = (value >> 4) & 0b11
+			c.mapped = value&0x40 != 0
+		}
+	case 2, 3:
+		// ROM Bank Select (2000-3FFF), bits fixed by the mask keep their value
+		fixed := c.romMask << 1
+		c.romLow = (c.romLow & fixed) | (value & 0x1F &^ fixed)
+		if !c.mapped {
+			c.romLow = value & 0x1F
+			c.romMid = (value >> 5) & 0b11
+		}
+	case 4, 5:
+		// RAM Bank Select (4000-5FFF), bits fixed by the mask keep their value
+		c.ramLow = (c.ramLow & c.ramMask) | (value & 0b11 &^ c.ramMask)
+		if !c.mapped {
+			c.ramLow = value & 0b11
+			c.ramHigh = (value >> 2) & 0b11
+			c.romHigh = (value >> 4) & 0b11
+			c.modeProtected = value&0x40 != 0
+		}
+	case 6, 7:
+		// Mode Select (6000-7FFF)
+		if !c.modeProtected {
+			c.ramMode = (value & 1) == 1
+		}
+		if !c.mapped {
+			c.romMask = (value >> 2) & 0xF
+		}
+	case 0xA, 0xB:
+		// Write to RAM (A000-BFFF)
+		// Writing to RAM when not enabled does nothing
+		if !c.ramEnabled || c.numRamBanks == 0 {
+			return
+		}
+		c.ram[c.selectedRAMBank()][address-ExternalRAMStartAddress] = value
+	default:
+		// Our cartridge will ignore writes to invalid addresses
+		return
+	}
+}
+
+// Snapshot writes the cartridge state, including the mapping set up by the menu
+func (c *MMM01Cartridge) Snapshot(w io.Writer) error {
+	if err := c.CartridgeCore.Snapshot(w); err != nil {
+		return err
+	}
+	return snapshot.Write(w, c.mapped, c.romLow, c.romMid, c.romHigh, c.ramLow, c.ramHigh,
+		c.romMask, c.ramMask, c.ramMode, c.modeProtected)
+}
+
+// Restore reads state written by Snapshot
+func (c *MMM01Cartridge) Restore(r io.Reader) error {
+	if err := c.CartridgeCore.Restore(r); err != nil {
+		return err
+	}
+	return snapshot.Read(r, &c.mapped, &c.romLow, &c.romMid, &c.romHigh, &c.ramLow, &c.ramHigh,
+		&c.romMask, &c.ramMask, &c.ramMode, &c.modeProtected)
+}
+
+// Save cartridge RAM contents to a file
+func (c *MMM01Cartridge) SaveRAM() {
+	if c.numRamBanks == 0 {
+		log.Printf("Cartridge does not have any RAM banks to save\n")
+		return
+	}
+	// Note: saving is enabled here even if the physical cartridge wouldn't have had the battery to support it
+	err := WriteRAMToFile(c.filename, c.ram)
+	if err != nil {
+		log.Printf("Unable save RAM: %v\n", err)
+	}
+}
+
+// Load cartridge RAM from a file
+func (c *MMM01Cartridge) LoadRAM() {
+	// If cartridge does not have RAM we will skip any sort of loading
+	if c.numRamBanks == 0 {
+		return
+	}
+
+	err := ReadRAMFromFile(c.filename, c.ram)
+	if err != nil {
+		// We will be permissive here continue running after logging the issue
+		log.Printf("Unable to load RAM from file: %v\n", err)
+	}
+}
@@ -12,9 +12,18 @@ func NewROMOnlyCartridge(data []uint8) *ROMOnlyCartridge {
 }
 
 func (c *ROMOnlyCartridge) ReadFrom(address uint16) uint8 {
+	if int(address) >= len(c.rom) {
+		// No RAM at A000-BFFF, reads see an undriven bus
+		return 0xFF
+	}
 	return c.rom[address]
 }
 
+// Return the ROM bank mapped to 4000-7FFF, which is always the second half of the ROM
+func (c *ROMOnlyCartridge) ROMBank() uint16 {
+	return 1
+}
+
 func (c *ROMOnlyCartridge) WriteTo(address uint16, value uint8) {
 	// Writes to ROM cartridge are no-ops
 }
@@ -2,10 +2,13 @@ package cartridges
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
+
+	"github.com/cbott/GoEmulate/snapshot"
 )
 
 const (
@@ -13,13 +16,15 @@ const (
 	ExternalRAMStartAddress = 0xA000
 	ExternalRAMEndAddress   = 0xC000
 
-	CartridgeTypeAddress = 0x0147
-	ROMSizeAddress       = 0x0148
-	RAMSizeAddress       = 0x0149
-	TitleAddress         = 0x0134
-	TitleLength          = 16
-	ROMBankSize          = 0x4000 // 16 KiB
-	RAMBankSize          = 0x2000 // 8 KiB
+	CartridgeTypeAddress  = 0x0147
+	ROMSizeAddress        = 0x0148
+	RAMSizeAddress        = 0x0149
+	TitleAddress          = 0x0134
+	TitleLength           = 16
+	GlobalChecksumAddress = 0x014E
+	headerEndAddress      = 0x0150
+	ROMBankSize           = 0x4000 // 16 KiB
+	RAMBankSize           = 0x2000 // 8 KiB
 )
 
 //    Available ROM Sizes
@@ -79,8 +84,21 @@ type Cartridge interface {
 	WriteTo(address uint16, value uint8)
 	LoadRAM()
 	SaveRAM()
-	GetState() ([][RAMBankSize]uint8, uint8, bool, uint16)
-	SetState([][RAMBankSize]uint8, uint8, bool, uint16)
+	snapshot.Snapshotter
+	Title() string
+	GlobalChecksum() uint16
+	// ROM bank currently mapped to 0000-3FFF
+	LowROMBank() uint16
+	// ROM bank currently mapped to 4000-7FFF
+	ROMBank() uint16
+}
+
+// ClockedCartridge is a Cartridge with hardware run from the console's clock, such as a real time clock, which keeps
+// it in step with save states, rewind and movies
+type ClockedCartridge interface {
+	Cartridge
+	// RunCycles advances the cartridge by the specified number of machine cycles (4MHz)
+	RunCycles(cycles int)
 }
 
 // Common base for all cartridge types defining ROM and RAM banks
@@ -103,43 +121,98 @@ type CartridgeCore struct {
 	ramEnabled bool
 }
 
-// Return the current state of the cartridge
-func (c CartridgeCore) GetState() ([][RAMBankSize]uint8, uint8, bool, uint16) {
-	return c.ram, c.ramBank, c.ramEnabled, c.romBank
+// Snapshot writes the banking registers and RAM contents shared by all cartridge types
+func (c *CartridgeCore) Snapshot(w io.Writer) error {
+	return snapshot.Write(w, c.romBank, c.ramBank, c.ramEnabled, uint8(len(c.ram)), c.ram)
 }
 
-// Set the state of the cartridge
-func (c *CartridgeCore) SetState(ram [][RAMBankSize]uint8, ramBank uint8, ramEnabled bool, romBank uint16) {
-	c.ram = ram
+// Restore reads state written by CartridgeCore.Snapshot
+func (c *CartridgeCore) Restore(r io.Reader) error {
+	var romBank uint16
+	var ramBank, numRamBanks uint8
+	var ramEnabled bool
+	if err := snapshot.Read(r, &romBank, &ramBank, &ramEnabled, &numRamBanks); err != nil {
+		return err
+	}
+	if int(numRamBanks) != len(c.ram) {
+		return fmt.Errorf("state has %d cartridge RAM banks, but the cartridge has %d", numRamBanks, len(c.ram))
+	}
+	if err := snapshot.Read(r, c.ram); err != nil {
+		return err
+	}
+
+	c.romBank = romBank
 	c.ramBank = ramBank
 	c.ramEnabled = ramEnabled
-	c.romBank = romBank
+	return nil
+}
+
+// Return the ROM bank currently mapped to 0000-3FFF, which is always bank 0 unless the cartridge type can switch it
+func (c CartridgeCore) LowROMBank() uint16 {
+	return 0
+}
+
+// Return the game title from the cartridge header
+func (c CartridgeCore) Title() string {
+	return parseTitle(c.rom)
 }
 
-// Read a cartridge binary file and return the correct cartridge type containing the file contents
+// Return the global checksum from the cartridge header, a 16 bit sum of every byte in the ROM (except itself)
+func (c CartridgeCore) GlobalChecksum() uint16 {
+	return uint16(c.rom[GlobalChecksumAddress])<<8 | uint16(c.rom[GlobalChecksumAddress+1])
+}
+
+// Read the title out of the header of a cartridge's ROM data
+func parseTitle(data []uint8) string {
+	// Title length can vary by cartridge type so we will just stop at the first null character
+	return strings.Split(string(data[TitleAddress:TitleAddress+TitleLength]), "\x00")[0]
+}
+
+// Read a cartridge binary file and return the correct cartridge type containing the file contents,
+// panicking if the file can't be used. See Load for a version which returns the error instead
 func Make(filename string) Cartridge {
+	c, err := Load(filename)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Load reads a cartridge binary file and returns the correct cartridge type containing the file contents, or an
+// error if the file can't be read or isn't a ROM for a supported cartridge type
+func Load(filename string) (Cartridge, error) {
 	// Load cartridge binary data
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
-		panic(fmt.Sprintf("Unable to load ROM file %s", filename))
+		return nil, fmt.Errorf("unable to load ROM file %s: %w", filename, err)
+	}
+	if len(data) < headerEndAddress {
+		return nil, fmt.Errorf("ROM file %s is too short to hold a cartridge header (%d B)", filename, len(data))
 	}
 
 	// Parse out cartridge header attributes
-	cartridgeType := data[CartridgeTypeAddress]
+	// MMM01 multi-game cartridges start with the first game, their own header is with the menu at the end
+	header := data
+	if isMMM01(data) {
+		header = data[mmm01HeaderOffset(data):]
+	}
+	cartridgeType := header[CartridgeTypeAddress]
 	cartridgeTypeString, ok := cartridgeTypeMap[cartridgeType]
 	if !ok {
-		panic(fmt.Sprintf("Unknown cartridge type %d", cartridgeType))
+		return nil, fmt.Errorf("unknown cartridge type %d", cartridgeType)
 	}
 
-	ramSizeKey := data[RAMSizeAddress]
+	ramSizeKey := header[RAMSizeAddress]
 	ramSize, ok := ramSizeMap[ramSizeKey]
 	if !ok {
-		panic(fmt.Sprintf("Unknown RAM Size code %d", ramSizeKey))
+		return nil, fmt.Errorf("unknown RAM Size code %d", ramSizeKey)
 	}
 
-	var romSize int = 32 * (1 << data[ROMSizeAddress])
-	// Title length can vary by cartridge type so we will just stop at the first null character
-	var title string = strings.Split(string(data[TitleAddress:TitleAddress+TitleLength]), "\x00")[0]
+	var romSize int = 32 * (1 << header[ROMSizeAddress])
+	var title string = parseTitle(header)
+	if (cartridgeType == 0x01 || cartridgeType == 0x02 || cartridgeType == 0x03) && isMBC1Multicart(data) {
+		cartridgeTypeString += " multicart (MBC1M)"
+	}
 
 	fmt.Printf("Cartridge file: %s\n", filename)
 	fmt.Printf("Title: %s\n", title)
@@ -149,22 +222,30 @@ func Make(filename string) Cartridge {
 
 	// Validate ROM Size listed in the cartridge header
 	if romSize*1024 != len(data) {
-		panic(fmt.Sprintf("ROM size in cartridge header does not match file size\nHeader:\t%d B\nFile:\t%d B",
-			romSize*1024, len(data)))
+		return nil, fmt.Errorf("ROM size in cartridge header does not match file size\nHeader:\t%d B\nFile:\t%d B",
+			romSize*1024, len(data))
 	}
 
 	// Return correct cartridge type for this file
 	switch cartridgeType {
 	case 0x00:
-		return NewROMOnlyCartridge(data)
+		return NewROMOnlyCartridge(data), nil
 	case 0x01, 0x02, 0x03:
-		return NewMBC1Cartridge(filename, data)
+		return NewMBC1Cartridge(filename, data), nil
+	case 0x05, 0x06:
+		return NewMBC2Cartridge(filename, data), nil
+	case 0x0B, 0x0C, 0x0D:
+		return NewMMM01Cartridge(filename, data), nil
 	case 0x0F, 0x10, 0x11, 0x12, 0x13:
-		return NewMBC3Cartridge(filename, data)
+		return NewMBC3Cartridge(filename, data), nil
 	case 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 0x1E:
-		return NewMBC5Cartridge(filename, data)
+		return NewMBC5Cartridge(filename, data), nil
+	case 0xFE:
+		return NewHuC3Cartridge(filename, data), nil
+	case 0xFF:
+		return NewHuC1Cartridge(filename, data), nil
 	default:
-		panic(fmt.Sprintf("Cartridge type %d not implemented", cartridgeType))
+		return nil, fmt.Errorf("cartridge type %d not implemented", cartridgeType)
 	}
 }
 
@@ -175,6 +256,27 @@ func getSaveFileName(name string) string {
 
 // Write the contents of all RAM banks to a RAM save file (filename.ram)
 func WriteRAMToFile(filename string, ramBanks [][RAMBankSize]uint8) error {
+	data := make([]uint8, 0, len(ramBanks)*RAMBankSize)
+	for i := 0; i < len(ramBanks); i++ {
+		data = append(data, ramBanks[i][:]...)
+	}
+	return writeSaveFile(filename, data)
+}
+
+// Read from a RAM save file to fill RAM banks
+func ReadRAMFromFile(filename string, ramBanks [][RAMBankSize]uint8) error {
+	data := make([]uint8, len(ramBanks)*RAMBankSize)
+	if err := readSaveFile(filename, data); err != nil {
+		return err
+	}
+	for i := range data {
+		ramBanks[i/RAMBankSize][i%RAMBankSize] = data[i]
+	}
+	return nil
+}
+
+// Write RAM contents to a RAM save file (filename.ram)
+func writeSaveFile(filename string, data []uint8) error {
 	filename = getSaveFileName(filename)
 	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE, 0664)
 	if err != nil {
@@ -182,37 +284,29 @@ func WriteRAMToFile(filename string, ramBanks [][RAMBankSize]uint8) error {
 	}
 	defer f.Close()
 
-	// Write each RAM bank to the file
-	for i := 0; i < len(ramBanks); i++ {
-		_, err = f.Write(ramBanks[i][:])
-		if err != nil {
-			return err
-		}
+	_, err = f.Write(data)
+	if err != nil {
+		return err
 	}
 
 	log.Printf("Saved RAM to file %v\n", filename)
 	return nil
 }
 
-// Read from a RAM save file to fill RAM banks
-func ReadRAMFromFile(filename string, ramBanks [][RAMBankSize]uint8) error {
+// Read a RAM save file, which must be exactly the size of data, into data
+func readSaveFile(filename string, data []uint8) error {
 	filename = getSaveFileName(filename)
 	// Load RAM binary file
-	data, err := ioutil.ReadFile(filename)
+	saved, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
 
 	// Check RAM size
-	banks := len(ramBanks)
-	expectedBytes := banks * RAMBankSize
-	if len(data) != expectedBytes {
-		return fmt.Errorf("RAM file %s size (%vB) does not match cartrige expectation (%vB)", filename, len(data), expectedBytes)
-	}
-
-	for i := 0; i < expectedBytes; i++ {
-		ramBanks[i/RAMBankSize][i%RAMBankSize] = data[i]
+	if len(saved) != len(data) {
+		return fmt.Errorf("RAM file %s size (%vB) does not match cartrige expectation (%vB)", filename, len(saved), len(data))
 	}
+	copy(data, saved)
 
 	log.Printf("Loaded RAM from file %v\n", filename)
 	return nil
@@ -0,0 +1,531 @@
+package cartridges
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Build ROM data for a cartridge of the given type with no program
+func makeTestROM(cartridgeType uint8, romSizeKey uint8, ramSizeKey uint8) []uint8 {
+	rom := make([]uint8, ROMBankSize*(2<<romSizeKey))
+	rom[CartridgeTypeAddress] = cartridgeType
+	rom[ROMSizeAddress] = romSizeKey
+	rom[RAMSizeAddress] = ramSizeKey
+	return rom
+}
+
+// Snapshot one cartridge and restore it into another
+func copyState(t *testing.T, from Cartridge, to Cartridge) {
+	var state bytes.Buffer
+	if err := from.Snapshot(&state); err != nil {
+		t.Fatalf("Unable to snapshot cartridge: %v", err)
+	}
+	if err := to.Restore(&state); err != nil {
+		t.Fatalf("Unable to restore cartridge: %v", err)
+	}
+}
+
+func TestMBC1SnapshotBankingMode(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mbc1.gb")
+	rom := makeTestROM(0x03, 0x01, 0x03)
+	original := NewMBC1Cartridge(filename, rom)
+	original.WriteTo(0x0000, 0x0A) // Enable RAM
+	original.WriteTo(0x6000, 0x01) // RAM banking mode
+	original.WriteTo(0x4000, 0x02) // RAM bank 2
+	original.WriteTo(0xA123, 0x42)
+
+	restored := NewMBC1Cartridge(filename, rom)
+	copyState(t, original, restored)
+
+	if !restored.ramMode {
+		t.Fatalf("Expected RAM banking mode to be restored")
+	}
+	if value := restored.ReadFrom(0xA123); value != 0x42 {
+		t.Fatalf("Expected to read 0x42 from RAM bank 2, got 0x%02X", value)
+	}
+}
+
+func TestMBC3SnapshotClock(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mbc3.gb")
+	rom := makeTestROM(0x10, 0x01, 0x03)
+	original := NewMBC3Cartridge(filename, rom)
+	original.WriteTo(0x0000, 0x0A) // Enable RAM and RTC
+	original.WriteTo(0x4000, 0x09) // RTC minutes
+	original.WriteTo(0xA000, 37)
+	original.WriteTo(0x6000, 0x01) // Latch
+	original.WriteTo(0xA000, 38)
+
+	restored := NewMBC3Cartridge(filename, rom)
+	copyState(t, original, restored)
+
+	if restored.rtc != original.rtc || restored.latchedrtc != original.latchedrtc {
+		t.Fatalf("Expected clock registers %v/%v, got %v/%v",
+			original.rtc, original.latchedrtc, restored.rtc, restored.latchedrtc)
+	}
+}
+
+func TestSnapshotRejectsRAMSizeMismatch(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mbc5.gb")
+	var state bytes.Buffer
+	if err := NewMBC5Cartridge(filename, makeTestROM(0x1B, 0x01, 0x03)).Snapshot(&state); err != nil {
+		t.Fatalf("Unable to snapshot cartridge: %v", err)
+	}
+	if err := NewMBC5Cartridge(filename, makeTestROM(0x1B, 0x01, 0x02)).Restore(&state); err == nil {
+		t.Fatalf("Expected restore with a different number of RAM banks to fail")
+	}
+}
+
+func TestLoadRejectsUnusableROMs(t *testing.T) {
+	dir := t.TempDir()
+	unknownType := makeTestROM(0x00, 0x00, 0x00)
+	unknownType[CartridgeTypeAddress] = 0x42
+	sizeMismatch := makeTestROM(0x01, 0x01, 0x00)[:2*ROMBankSize]
+	for name, data := range map[string][]uint8{
+		"short":        make([]uint8, 0x100),
+		"unknown type": unknownType,
+		"not emulated": makeTestROM(0x20, 0x00, 0x00),
+		"wrong size":   sizeMismatch,
+	} {
+		filename := filepath.Join(dir, name+".gb")
+		if err := os.WriteFile(filename, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(filename); err == nil {
+			t.Errorf("Expected %s ROM to be rejected", name)
+		}
+	}
+	if _, err := Load(filepath.Join(dir, "missing.gb")); err == nil {
+		t.Error("Expected missing ROM file to be rejected")
+	}
+
+	filename := filepath.Join(dir, "mbc1.gb")
+	if err := os.WriteFile(filename, makeTestROM(0x01, 0x01, 0x00), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if c, err := Load(filename); err != nil {
+		t.Errorf("Unable to load MBC1 ROM: %v", err)
+	} else if _, ok := c.(*MemoryBankController1Cartridge); !ok {
+		t.Errorf("Loaded %T, expected MBC1 cartridge", c)
+	}
+}
+
+func TestMBC2Banking(t *testing.T) {
+	rom := makeTestROM(0x06, 0x03, 0x00)
+	for bank := 0; bank < 16; bank++ {
+		rom[bank*ROMBankSize] = uint8(bank)
+	}
+	c := NewMBC2Cartridge(filepath.Join(t.TempDir(), "mbc2.gb"), rom)
+
+	if value := c.ReadFrom(0x4000); value != 1 {
+		t.Errorf("Read bank %d at power on, expected 1", value)
+	}
+	// Address bit 8 set selects the ROM bank register
+	c.WriteTo(0x2100, 0x05)
+	if value := c.ReadFrom(0x4000); value != 5 {
+		t.Errorf("Read bank %d after selecting 5, expected 5", value)
+	}
+	c.WriteTo(0x3FFF, 0x00)
+	if value := c.ReadFrom(0x4000); value != 1 {
+		t.Errorf("Read bank %d after selecting 0, expected 1", value)
+	}
+	// Address bit 8 clear is RAM enable, so the bank is unchanged
+	c.WriteTo(0x2000, 0x07)
+	if bank := c.ROMBank(); bank != 1 {
+		t.Errorf("ROM bank changed to %d by a RAM enable write", bank)
+	}
+}
+
+func TestMBC2BankPastEndOfROM(t *testing.T) {
+	rom := makeTestROM(0x06, 0x00, 0x00)
+	rom[ROMBankSize] = 1
+	c := NewMBC2Cartridge(filepath.Join(t.TempDir(), "mbc2.gb"), rom)
+
+	// Bank 2 of a 2 bank ROM wraps around to bank 0
+	c.WriteTo(0x2100, 0x02)
+	if value := c.ReadFrom(0x4000); value != 0 {
+		t.Errorf("Read bank %d after selecting 2, expected 0", value)
+	}
+	c.WriteTo(0x2100, 0x03)
+	if value := c.ReadFrom(0x4000); value != 1 {
+		t.Errorf("Read bank %d after selecting 3, expected 1", value)
+	}
+}
+
+func TestMBC2RAM(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mbc2.gb")
+	rom := makeTestROM(0x06, 0x01, 0x00)
+	c := NewMBC2Cartridge(filename, rom)
+
+	c.WriteTo(0xA010, 0x5C)
+	if value := c.ReadFrom(0xA010); value != 0xFF {
+		t.Errorf("Read %02X with RAM disabled, expected FF", value)
+	}
+
+	c.WriteTo(0x0000, 0x0A)
+	c.WriteTo(0xA010, 0x5C)
+	if value := c.ReadFrom(0xA010); value != 0xFC {
+		t.Errorf("Read %02X, expected upper nibble as 1s (FC)", value)
+	}
+	// 512 half-bytes are repeated across A000-BFFF
+	if value := c.ReadFrom(0xBE10); value != 0xFC {
+		t.Errorf("Read %02X from echo at BE10, expected FC", value)
+	}
+
+	c.SaveRAM()
+	// Saved as the 512 half-bytes other emulators use
+	if info, err := os.Stat(filename + ".ram"); err != nil || info.Size() != MBC2RAMSize {
+		t.Fatalf("Expected a %d byte save file, got %v (%v)", MBC2RAMSize, info, err)
+	}
+	loaded := NewMBC2Cartridge(filename, rom)
+	loaded.WriteTo(0x0000, 0x0A)
+	if value := loaded.ReadFrom(0xA010); value != 0xFC {
+		t.Errorf("Read %02X after loading the save file, expected FC", value)
+	}
+}
+
+// Build a 1MiB MBC1 ROM with the first byte of each bank holding the bank number, and the logo in the headers of
+// the given 256KiB games
+func makeMulticartROM(games ...int) []uint8 {
+	rom := makeTestROM(0x01, 0x05, 0x00)
+	for bank := 0; bank < 64; bank++ {
+		rom[bank*ROMBankSize] = uint8(bank)
+	}
+	for _, game := range games {
+		copy(rom[game*mbc1MGameSize+LogoAddress:], NintendoLogo)
+	}
+	return rom
+}
+
+func TestMBC1MulticartDetection(t *testing.T) {
+	if !isMBC1Multicart(makeMulticartROM(0, 1, 2)) {
+		t.Error("Multicart with 3 games not detected")
+	}
+	if isMBC1Multicart(makeMulticartROM(0)) {
+		t.Error("1MiB ROM with a single header detected as a multicart")
+	}
+	rom := makeTestROM(0x01, 0x04, 0x00)
+	copy(rom[LogoAddress:], NintendoLogo)
+	copy(rom[mbc1MGameSize+LogoAddress:], NintendoLogo)
+	if isMBC1Multicart(rom) {
+		t.Error("512KiB ROM detected as a multicart")
+	}
+}
+
+func TestMBC1MulticartBanking(t *testing.T) {
+	c := NewMBC1Cartridge(filepath.Join(t.TempDir(), "mbc1m.gb"), makeMulticartROM(0, 1, 2, 3))
+	if !c.multicart {
+		t.Fatal("Multicart not detected")
+	}
+
+	// 4 bits of the ROM bank register are used, with the 2 bit register above them
+	c.WriteTo(0x2000, 0x12)
+	if value := c.ReadFrom(0x4000); value != 0x02 {
+		t.Errorf("Read bank %02X after selecting 12, expected 02", value)
+	}
+	c.WriteTo(0x4000, 0x01)
+	if value := c.ReadFrom(0x4000); value != 0x12 {
+		t.Errorf("Read bank %02X in game 1, expected 12", value)
+	}
+	// Bank 0x10 passes the check for bank 0, but maps the game's first bank
+	c.WriteTo(0x2000, 0x10)
+	if value := c.ReadFrom(0x4000); value != 0x10 {
+		t.Errorf("Read bank %02X after selecting 10, expected 10", value)
+	}
+	c.WriteTo(0x2000, 0x00)
+	if value := c.ReadFrom(0x4000); value != 0x11 {
+		t.Errorf("Read bank %02X after selecting 0, expected 11", value)
+	}
+	// In game 0 that is bank 0 itself
+	c.WriteTo(0x4000, 0x00)
+	c.WriteTo(0x2000, 0x10)
+	if value := c.ReadFrom(0x4000); value != 0x00 {
+		t.Errorf("Read bank %02X after selecting 10 in game 0, expected 00", value)
+	}
+	c.WriteTo(0x2000, 0x00)
+
+	// Bank 0 only follows the game in RAM banking mode
+	if value := c.ReadFrom(0x0000); value != 0x00 {
+		t.Errorf("Read bank %02X at 0000 in ROM banking mode, expected 00", value)
+	}
+	c.WriteTo(0x6000, 0x01)
+	c.WriteTo(0x4000, 0x03)
+	if value := c.ReadFrom(0x0000); value != 0x30 {
+		t.Errorf("Read bank %02X at 0000 in RAM banking mode, expected 30", value)
+	}
+	if value := c.ReadFrom(0x4000); value != 0x31 {
+		t.Errorf("Read bank %02X at 4000 in RAM banking mode, expected 31", value)
+	}
+}
+
+// Build a 128KiB MMM01 ROM with the first byte of each bank holding the bank number and the MMM01 header in the
+// second to last bank, in front of the menu
+func makeMMM01ROM() []uint8 {
+	rom := makeTestROM(0x01, 0x02, 0x00)
+	for bank := 0; bank < 8; bank++ {
+		rom[bank*ROMBankSize] = uint8(bank)
+	}
+	header := rom[6*ROMBankSize:]
+	header[CartridgeTypeAddress] = 0x0D
+	header[ROMSizeAddress] = 0x02
+	header[RAMSizeAddress] = 0x03
+	copy(header[TitleAddress:], "MENU")
+	return rom
+}
+
+func TestMMM01Mapping(t *testing.T) {
+	rom := makeMMM01ROM()
+	if !isMMM01(rom) {
+		t.Fatal("MMM01 header in front of the menu not detected")
+	}
+	c := NewMMM01Cartridge(filepath.Join(t.TempDir(), "mmm01.gb"), rom)
+	if title := c.Title(); title != "MENU" {
+		t.Errorf("Title is %q, expected the menu's", title)
+	}
+
+	// The menu in the last 32KiB runs first
+	if low, high := c.ReadFrom(0x0000), c.ReadFrom(0x4000); low != 6 || high != 7 {
+		t.Errorf("Unmapped banks are %d/%d, expected 6/7", low, high)
+	}
+
+	// Map the game in banks 2-3, fixing ROM bank bit 1, and lock the mapping
+	c.WriteTo(0x2000, 0x02)
+	c.WriteTo(0x6000, 0b0001<<2)
+	c.WriteTo(0x4000, 0x01)
+	c.WriteTo(0x0000, 0x40)
+	if low, high := c.ReadFrom(0x0000), c.ReadFrom(0x4000); low != 2 || high != 3 {
+		t.Errorf("Mapped banks are %d/%d, expected 2/3", low, high)
+	}
+
+	// The game can't leave its banks
+	c.WriteTo(0x2000, 0x01)
+	if bank := c.ROMBank(); bank != 3 {
+		t.Errorf("Game selected bank %d with 1, expected 3", bank)
+	}
+	c.WriteTo(0x2000, 0x04)
+	if bank := c.ROMBank(); bank != 6 {
+		t.Errorf("Game selected bank %d with 4, expected 6", bank)
+	}
+	c.WriteTo(0x2000, 0x00)
+	if bank := c.ROMBank(); bank != 3 {
+		t.Errorf("Game selected bank %d with 0, expected 3", bank)
+	}
+	c.WriteTo(0x6000, 0)
+	c.WriteTo(0x0000, 0x0A)
+	if !c.mapped || c.romMask != 1 {
+		t.Error("Mapping changed after it was locked")
+	}
+
+	// Mapping is restored with the rest of the state
+	restored := NewMMM01Cartridge(c.filename, rom)
+	copyState(t, c, restored)
+	if low := restored.ReadFrom(0x0000); low != 2 {
+		t.Errorf("Restored bank 0 is %d, expected 2", low)
+	}
+}
+
+func TestHuC1BankingAndRAM(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "huc1.gb")
+	rom := makeTestROM(0xFF, 0x03, 0x03)
+	for bank := 0; bank < 16; bank++ {
+		rom[bank*ROMBankSize] = uint8(bank)
+	}
+	c := NewHuC1Cartridge(filename, rom)
+
+	c.WriteTo(0x2000, 0x09)
+	if value := c.ReadFrom(0x4000); value != 9 {
+		t.Errorf("Read bank %d after selecting 9, expected 9", value)
+	}
+	// Bank numbers past the end of the ROM wrap around, reaching bank 0
+	c.WriteTo(0x2000, 0x10)
+	if value := c.ReadFrom(0x4000); value != 0 {
+		t.Errorf("Read bank %d after selecting 10, expected 0", value)
+	}
+
+	// RAM is available without enabling it
+	c.WriteTo(0x4000, 0x02)
+	c.WriteTo(0xA100, 0x42)
+	c.WriteTo(0x4000, 0x00)
+	if value := c.ReadFrom(0xA100); value == 0x42 {
+		t.Error("RAM bank 0 holds the value written to bank 2")
+	}
+	c.WriteTo(0x4000, 0x02)
+	if value := c.ReadFrom(0xA100); value != 0x42 {
+		t.Errorf("Read %02X from RAM bank 2, expected 42", value)
+	}
+
+	c.SaveRAM()
+	loaded := NewHuC1Cartridge(filename, rom)
+	loaded.WriteTo(0x4000, 0x02)
+	if value := loaded.ReadFrom(0xA100); value != 0x42 {
+		t.Errorf("Read %02X after loading the save file, expected 42", value)
+	}
+}
+
+func TestHuC1Infrared(t *testing.T) {
+	rom := makeTestROM(0xFF, 0x01, 0x02)
+	a := NewHuC1Cartridge(filepath.Join(t.TempDir(), "a.gb"), rom)
+	b := NewHuC1Cartridge(filepath.Join(t.TempDir(), "b.gb"), rom)
+
+	a.WriteTo(0x0000, HuC1IRSelect)
+	if value := a.ReadFrom(0xA000); value != IRNoLight {
+		t.Errorf("IR read %02X with nothing connected, expected C0", value)
+	}
+
+	irA, irB := NewInfraredPair()
+	a.SetInfrared(irA)
+	b.SetInfrared(irB)
+	b.WriteTo(0x0000, HuC1IRSelect)
+	b.WriteTo(0xA000, 0x01)
+	if value := a.ReadFrom(0xA000); value != IRLight {
+		t.Errorf("IR read %02X with the other LED on, expected C1", value)
+	}
+	b.WriteTo(0xA000, 0x00)
+	if value := a.ReadFrom(0xA000); value != IRNoLight {
+		t.Errorf("IR read %02X with the other LED off, expected C0", value)
+	}
+
+	// Any other value switches back to RAM
+	a.WriteTo(0x0000, 0x00)
+	a.WriteTo(0xA000, 0x37)
+	if value := a.ReadFrom(0xA000); value != 0x37 {
+		t.Errorf("Read %02X from RAM, expected 37", value)
+	}
+}
+
+// Clock which only moves when told to
+type testClock struct {
+	current time.Time
+}
+
+func (c *testClock) now() time.Time {
+	return c.current
+}
+
+// Create a HuC3 cartridge which saves its clock with the time from a test clock
+func newTestHuC3(filename string, clock *testClock) *HuC3Cartridge {
+	c := NewHuC3Cartridge(filename, makeTestROM(0xFE, 0x02, 0x03))
+	c.now = clock.now
+	return c
+}
+
+// Send a command to the HuC3 clock processor and return the response
+func huc3Command(c *HuC3Cartridge, command uint8, argument uint8) uint8 {
+	c.WriteTo(0x0000, HuC3SelectCommand)
+	c.WriteTo(0xA000, command<<4|argument)
+	c.WriteTo(0x0000, HuC3SelectResponse)
+	return c.ReadFrom(0xA000)
+}
+
+// Latch the clock and read back the minute and day counters
+func readHuC3Clock(c *HuC3Cartridge) (minutes int, days int) {
+	huc3Command(c, HuC3CommandExtended, HuC3ExtendedLatch)
+	huc3Command(c, HuC3CommandAddressLow, 0)
+	huc3Command(c, HuC3CommandAddressHigh, 0)
+	for i := 0; i < 3; i++ {
+		minutes |= int(huc3Command(c, HuC3CommandRead, 0)&0xF) << (4 * i)
+	}
+	for i := 0; i < 3; i++ {
+		days |= int(huc3Command(c, HuC3CommandRead, 0)&0xF) << (4 * i)
+	}
+	return minutes, days
+}
+
+func TestHuC3Clock(t *testing.T) {
+	clock := &testClock{current: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)}
+	filename := filepath.Join(t.TempDir(), "huc3.gb")
+	c := newTestHuC3(filename, clock)
+
+	// Set the clock to 23:59 on day 9
+	huc3Command(c, HuC3CommandAddressLow, 0)
+	huc3Command(c, HuC3CommandAddressHigh, 0)
+	for _, nibble := range []uint8{0xF, 0x9, 0x5, 0x9, 0x0, 0x0} {
+		huc3Command(c, HuC3CommandWrite, nibble)
+	}
+	huc3Command(c, HuC3CommandExtended, HuC3ExtendedSet)
+
+	// Only the console's cycles move the clock while running
+	clock.current = clock.current.Add(5 * time.Hour)
+	c.RunCycles(90 * huc3CyclesPerSecond)
+	if minutes, days := readHuC3Clock(c); minutes != 0 || days != 10 {
+		t.Errorf("Clock reads %d minutes %d days, expected 0 minutes 10 days", minutes, days)
+	}
+	if response := huc3Command(c, HuC3CommandExtended, HuC3ExtendedStatus); response != 0x61 {
+		t.Errorf("Status response %02X, expected 61", response)
+	}
+	c.WriteTo(0x0000, HuC3SelectSemaphore)
+	if value := c.ReadFrom(0xA000); value&1 != 1 {
+		t.Errorf("Semaphore reads %02X, expected ready", value)
+	}
+
+	// The clock file keeps counting while the emulator is closed
+	clock.current = clock.current.Add(-5 * time.Hour)
+	c.SaveRAM()
+	clock.current = clock.current.Add(2 * time.Hour)
+	loaded := newTestHuC3(filename, clock)
+	loaded.LoadRAM()
+	if minutes, days := readHuC3Clock(loaded); minutes != 120 || days != 10 {
+		t.Errorf("Loaded clock reads %d minutes %d days, expected 120 minutes 10 days", minutes, days)
+	}
+
+	// States carry the clock reading, including the part of a minute run so far
+	loaded.RunCycles(huc3CyclesPerMinute - 1)
+	restored := newTestHuC3(filename, clock)
+	copyState(t, loaded, restored)
+	if minutes, days := readHuC3Clock(restored); minutes != 120 || days != 10 {
+		t.Errorf("Restored clock reads %d minutes %d days, expected 120 minutes 10 days", minutes, days)
+	}
+	restored.RunCycles(1)
+	if minutes, days := readHuC3Clock(restored); minutes != 121 || days != 10 {
+		t.Errorf("Restored clock reads %d minutes %d days, expected 121 minutes 10 days", minutes, days)
+	}
+}
+
+func TestHuC3ToneAndRAM(t *testing.T) {
+	clock := &testClock{current: time.Now()}
+	c := newTestHuC3(filepath.Join(t.TempDir(), "huc3.gb"), clock)
+	var tones []uint8
+	c.SetToneCallback(func(tone uint8) { tones = append(tones, tone) })
+
+	huc3Command(c, HuC3CommandAddressLow, 0x7)
+	huc3Command(c, HuC3CommandAddressHigh, 0x2)
+	huc3Command(c, HuC3CommandWrite, 0x3)
+	huc3Command(c, HuC3CommandAddressLow, 0x7)
+	huc3Command(c, HuC3CommandExtended, HuC3ExtendedTone)
+	if len(tones) != 1 || tones[0] != 3 {
+		t.Errorf("Tones played %v, expected [3]", tones)
+	}
+
+	c.WriteTo(0x0000, HuC3SelectRAM)
+	c.WriteTo(0xA000, 0x42)
+	c.WriteTo(0x0000, HuC3SelectRAMReadOnly)
+	c.WriteTo(0xA000, 0x24)
+	if value := c.ReadFrom(0xA000); value != 0x42 {
+		t.Errorf("Read %02X from RAM, expected 42 as read only mode ignores writes", value)
+	}
+
+	c.WriteTo(0x0000, HuC3SelectIR)
+	if value := c.ReadFrom(0xA000); value != IRNoLight {
+		t.Errorf("IR read %02X with nothing connected, expected C0", value)
+	}
+}
+
+func TestHuC3BankPastEndOfROM(t *testing.T) {
+	rom := makeTestROM(0xFE, 0x02, 0x00)
+	for bank := 0; bank < 8; bank++ {
+		rom[bank*ROMBankSize] = uint8(bank)
+	}
+	c := NewHuC3Cartridge(filepath.Join(t.TempDir(), "huc3.gb"), rom)
+
+	// Bank numbers past the end of the 8 bank ROM wrap around, reaching bank 0
+	c.WriteTo(0x2000, 0x7F)
+	if value := c.ReadFrom(0x4000); value != 7 {
+		t.Errorf("Read bank %d after selecting 7F, expected 7", value)
+	}
+	c.WriteTo(0x2000, 0x08)
+	if value := c.ReadFrom(0x4000); value != 0 {
+		t.Errorf("Read bank %d after selecting 08, expected 0", value)
+	}
+}
@@ -0,0 +1,46 @@
+package cartridges
+
+// Infrared values read from the IR register of HuC cartridges
+const (
+	IRNoLight = 0xC0
+	IRLight   = 0xC1
+)
+
+// InfraredLink connects the IR LED and sensor of a cartridge to another device
+type InfraredLink interface {
+	// SetLED is called when the cartridge turns its LED on or off
+	SetLED(on bool)
+	// Light returns whether the cartridge's sensor currently sees light
+	Light() bool
+}
+
+// InfraredCartridge is a cartridge with an IR port
+type InfraredCartridge interface {
+	Cartridge
+	// SetInfrared connects the cartridge's IR LED and sensor to another device, or to nothing if link is nil
+	SetInfrared(link InfraredLink)
+}
+
+// InfraredEnd is one side of an IR connection between two cartridges, each seeing the other's LED
+type InfraredEnd struct {
+	other *InfraredEnd
+	led   bool
+}
+
+// NewInfraredPair returns two ends of an IR connection, to be given to two cartridges with SetInfrared
+func NewInfraredPair() (*InfraredEnd, *InfraredEnd) {
+	a := &InfraredEnd{}
+	b := &InfraredEnd{other: a}
+	a.other = b
+	return a, b
+}
+
+// SetLED turns this end's LED on or off
+func (e *InfraredEnd) SetLED(on bool) {
+	e.led = on
+}
+
+// Light returns whether the LED at the other end is on
+func (e *InfraredEnd) Light() bool {
+	return e.other.led
+}
@@ -0,0 +1,125 @@
+// gbdis disassembles the code in a Game Boy ROM
+//
+// Usage:
+//
+//	gbdis [flags] rom.gb
+//
+// Each ROM bank is disassembled with a linear sweep from its first byte, so data mixed in with
+// code is shown as instructions. Bank 0 is listed at addresses 0000-3FFF and every other bank at
+// 4000-7FFF, as they would be mapped while running.
+// With -sym, labels from an RGBDS symbol file are printed before the address they name and
+// used in place of jump, call and memory addresses.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/gameboy/disasm"
+)
+
+// Parse a bank range in hex, either a single bank ("01") or an inclusive range ("00-03")
+func parseBankRange(text string) (int, int, error) {
+	first, last, isRange := strings.Cut(text, "-")
+	start, err := strconv.ParseUint(first, 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid bank %q", first)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.ParseUint(last, 16, 16); err != nil {
+			return 0, 0, fmt.Errorf("invalid bank %q", last)
+		}
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("bank range %q is backwards", text)
+	}
+	return int(start), int(end), nil
+}
+
+// Write the disassembly of a single ROM bank
+func disassembleBank(w io.Writer, rom []uint8, bank int, symbols *disasm.Symbols) {
+	data := rom[bank*cartridges.ROMBankSize : (bank+1)*cartridges.ROMBankSize]
+	base := uint16(0)
+	if bank > 0 {
+		base = cartridges.ROMBankSize
+	}
+
+	// Addresses in the switchable bank refer to this bank, everything else is looked up without a bank
+	label := func(address uint16) string {
+		name, _ := symbols.Name(bank, address)
+		return name
+	}
+
+	fmt.Fprintf(w, "; Bank %02X\n", bank)
+	for offset := 0; offset < len(data); {
+		address := base + uint16(offset)
+		if name := label(address); name != "" {
+			fmt.Fprintf(w, "%s:\n", name)
+		}
+		inst := disasm.Decode(data[offset:], address)
+		fmt.Fprintf(w, "%02X:%04X  %-8X  %s\n", bank, address, inst.Bytes, inst.Format(label))
+		offset += inst.Length()
+	}
+}
+
+func run() int {
+	bankRange := flag.String("bank", "", "hex ROM bank or range of banks to disassemble, such as 01 or 00-03 (default all)")
+	symFile := flag.String("sym", "", "RGBDS .sym file to read labels from")
+	flag.Parse()
+
+	romFile := flag.Arg(0)
+	if romFile == "" {
+		fmt.Fprintln(os.Stderr, "ROM file must be specified")
+		return 1
+	}
+
+	rom, err := ioutil.ReadFile(romFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to read ROM: %v\n", err)
+		return 1
+	}
+	// Pad a partial last bank so every bank can be sliced in full
+	if extra := len(rom) % cartridges.ROMBankSize; extra != 0 {
+		rom = append(rom, make([]uint8, cartridges.ROMBankSize-extra)...)
+	}
+	banks := len(rom) / cartridges.ROMBankSize
+
+	first, last := 0, banks-1
+	if *bankRange != "" {
+		if first, last, err = parseBankRange(*bankRange); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if last >= banks {
+			fmt.Fprintf(os.Stderr, "ROM only has %d banks\n", banks)
+			return 1
+		}
+	}
+
+	var symbols *disasm.Symbols
+	if *symFile != "" {
+		if symbols, err = disasm.LoadSymbolFile(*symFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load symbols: %v\n", err)
+			return 1
+		}
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for bank := first; bank <= last; bank++ {
+		disassembleBank(w, rom, bank, symbols)
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run())
+}
@@ -0,0 +1,123 @@
+// Joypad input scripts
+// this file handles loading scripted button presses for headless runs
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cbott/GoEmulate/gameboy"
+)
+
+/*
+Input scripts list the buttons held starting from a given frame, one entry per line.
+Buttons stay held until the next entry, frames are counted from 0.
+Lines starting with # are comments.
+
+	# frame  buttons
+	60       start
+	65       none
+	120      a+right
+*/
+
+// inputEvent sets the held buttons starting at a given frame
+type inputEvent struct {
+	frame   int
+	buttons gameboy.ButtonState
+}
+
+// inputScript holds the button states to apply over the course of a run
+type inputScript struct {
+	// Events sorted by frame
+	events []inputEvent
+}
+
+// Parse a button list such as "a+right" into a ButtonState
+func parseButtons(text string) (gameboy.ButtonState, error) {
+	var state gameboy.ButtonState
+	if text == "none" || text == "-" {
+		return state, nil
+	}
+
+	for _, name := range strings.Split(text, "+") {
+		switch strings.ToLower(name) {
+		case "a":
+			state.BtnA = true
+		case "b":
+			state.BtnB = true
+		case "select":
+			state.BtnSelect = true
+		case "start":
+			state.BtnStart = true
+		case "right":
+			state.BtnRight = true
+		case "left":
+			state.BtnLeft = true
+		case "up":
+			state.BtnUp = true
+		case "down":
+			state.BtnDown = true
+		default:
+			return state, fmt.Errorf("unknown button %q", name)
+		}
+	}
+	return state, nil
+}
+
+// Load an input script from a file
+func loadInputScript(filename string) (*inputScript, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	script := &inputScript{}
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<frame> <buttons>\"", filename, lineNumber)
+		}
+		frame, err := strconv.Atoi(fields[0])
+		if err != nil || frame < 0 {
+			return nil, fmt.Errorf("%s:%d: invalid frame number %q", filename, lineNumber, fields[0])
+		}
+		buttons, err := parseButtons(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", filename, lineNumber, err)
+		}
+		script.events = append(script.events, inputEvent{frame: frame, buttons: buttons})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(script.events, func(i, j int) bool {
+		return script.events[i].frame < script.events[j].frame
+	})
+	return script, nil
+}
+
+// Return the buttons held during the given frame
+func (s *inputScript) buttonsForFrame(frame int) gameboy.ButtonState {
+	var state gameboy.ButtonState
+	for _, event := range s.events {
+		if event.frame > frame {
+			break
+		}
+		state = event.buttons
+	}
+	return state
+}
@@ -0,0 +1,422 @@
+// gbheadless runs the emulator without a window or audio device, for use in automated checks
+//
+// Usage:
+//
+//	gbheadless [flags] rom.gb
+//
+// The console runs for up to -frames frames, optionally stopping early once a condition is met,
+// and the final screen is written to a PNG file.
+// With -movie the console instead runs for the length of the movie, checking that it plays back exactly
+// as recorded. -record writes the run to a movie file.
+// -trace writes a line for each instruction run in the gameboy-doctor log format, limited by the
+// other -trace-* flags.
+// -tiles writes every tile in VRAM to a PNG file once the run ends, and -tilemap one of the background tile maps.
+// -oam writes the decoded sprite entries as JSON.
+// -cheats loads Game Genie and GameShark codes from a cheat file.
+// -link-listen and -link-connect connect the link cable to another emulator over TCP.
+// -printer connects a Game Boy Printer instead, writing printed pages to a directory.
+//
+// Exit codes:
+//
+//	0  All frames ran, or the -until condition was met
+//	1  Invalid arguments or an I/O error
+//	2  An -until condition was given but not met within -frames frames
+//	3  Movie playback desynced from the recording
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/gameboy"
+	"github.com/cbott/GoEmulate/link"
+	"github.com/cbott/GoEmulate/printer"
+)
+
+// Process exit codes
+const (
+	ExitSuccess         = 0
+	ExitError           = 1
+	ExitConditionNotMet = 2
+	ExitMovieDesync     = 3
+)
+
+// memoryCondition is satisfied when the value at address equals value
+type memoryCondition struct {
+	address uint16
+	value   uint8
+}
+
+// Parse a memory condition in the form ADDR=VAL, both in hex (e.g. "C0A0=01")
+func parseMemoryCondition(text string) (*memoryCondition, error) {
+	parts := strings.SplitN(text, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("memory condition %q must be in the form ADDR=VAL", text)
+	}
+	address, err := strconv.ParseUint(strings.TrimPrefix(parts[0], "0x"), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address in memory condition %q: %v", text, err)
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(parts[1], "0x"), 16, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value in memory condition %q: %v", text, err)
+	}
+	return &memoryCondition{address: uint16(address), value: uint8(value)}, nil
+}
+
+// screenImage converts the Game Boy screen buffer into an image
+func screenImage(gb *gameboy.Gameboy) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight))
+	for x := 0; x < gameboy.ScreenWidth; x++ {
+		for y := 0; y < gameboy.ScreenHeight; y++ {
+			rgb := gb.ScreenData[x][y]
+			img.SetRGBA(x, y, color.RGBA{R: rgb[0], G: rgb[1], B: rgb[2], A: 0xFF})
+		}
+	}
+	return img
+}
+
+// Write an image to a PNG file
+func writePNG(filename string, img image.Image) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return png.Encode(f, img)
+}
+
+// Read a PNG file, checking that it is the size of the Game Boy screen
+func readScreenPNG(filename string) (image.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, err
+	}
+	size := img.Bounds().Size()
+	if size.X != gameboy.ScreenWidth || size.Y != gameboy.ScreenHeight {
+		return nil, fmt.Errorf("reference image %s is %dx%d, expected %dx%d",
+			filename, size.X, size.Y, gameboy.ScreenWidth, gameboy.ScreenHeight)
+	}
+	return img, nil
+}
+
+// Return whether the current screen exactly matches a reference image
+func screenMatches(gb *gameboy.Gameboy, reference image.Image) bool {
+	bounds := reference.Bounds()
+	for x := 0; x < gameboy.ScreenWidth; x++ {
+		for y := 0; y < gameboy.ScreenHeight; y++ {
+			r, g, b, _ := reference.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			rgb := gb.ScreenData[x][y]
+			if uint8(r>>8) != rgb[0] || uint8(g>>8) != rgb[1] || uint8(b>>8) != rgb[2] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Read a movie file
+func loadMovie(filename string) (*gameboy.Movie, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return gameboy.LoadMovie(f)
+}
+
+// Write a movie file
+func saveMovie(filename string, movie *gameboy.Movie) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = movie.Save(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func run() int {
+	runBootROM := flag.Bool("bootrom", false, "run boot ROM prior to cartridge")
+	frames := flag.Int("frames", 600, "maximum number of frames to run")
+	inputFile := flag.String("input", "", "joypad input script to play back")
+	screenshot := flag.String("screenshot", "screenshot.png", "PNG file to write the final screen to (empty to skip)")
+	untilMemory := flag.String("until-mem", "", "stop once the memory value matches, as hex ADDR=VAL")
+	untilScreen := flag.String("until-screen", "", "stop once the screen matches this reference PNG")
+	movieFile := flag.String("movie", "", "input movie to play back, runs for the length of the movie")
+	recordFile := flag.String("record", "", "movie file to record the run to")
+	traceFile := flag.String("trace", "", "file to write an instruction trace to, in gameboy-doctor format")
+	tracePC := flag.String("trace-pc", "", "only trace instructions in this hex address range, such as 0150-3FFF")
+	traceBank := flag.String("trace-bank", "", "only trace instructions running from this hex ROM bank")
+	traceLimit := flag.Int("trace-limit", 0, "stop tracing after this many instructions (0 for no limit)")
+	traceAfter := flag.String("trace-after", "", "start tracing once this [BB:]ADDR instruction is reached")
+	tilesFile := flag.String("tiles", "", "PNG file to write the final VRAM tiles to")
+	tilesPalette := flag.String("tiles-palette", "bgp", "palette to color -tiles with: bgp, obp0 or obp1")
+	tileMapFile := flag.String("tilemap", "", "PNG file to write the final background tile map to")
+	tileMapAddress := flag.String("tilemap-address", "", "tile map for -tilemap, 9800 or 9C00 (default the background's)")
+	oamFile := flag.String("oam", "", "JSON file to write the final OAM sprite entries to")
+	cheatFile := flag.String("cheats", "", "cheat file to load")
+	linkListen := flag.String("link-listen", "", "wait for another emulator to connect a link cable on this TCP address")
+	linkConnect := flag.String("link-connect", "", "connect a link cable to another emulator listening on this TCP address")
+	linkTimeout := flag.Duration("link-timeout", link.DefaultReplyTimeout, "how long a link cable transfer waits for the other emulator's reply")
+	printerDir := flag.String("printer", "", "connect a Game Boy Printer, writing printed pages to this directory")
+	flag.Parse()
+
+	romFile := flag.Arg(0)
+	if romFile == "" {
+		fmt.Fprintln(os.Stderr, "ROM file must be specified")
+		return ExitError
+	}
+	if *movieFile != "" && *recordFile != "" {
+		fmt.Fprintln(os.Stderr, "Only one of -movie and -record can be used")
+		return ExitError
+	}
+
+	var err error
+	var script *inputScript
+	if *inputFile != "" {
+		script, err = loadInputScript(*inputFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load input script: %v\n", err)
+			return ExitError
+		}
+	}
+
+	var memoryTarget *memoryCondition
+	if *untilMemory != "" {
+		memoryTarget, err = parseMemoryCondition(*untilMemory)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+	}
+
+	var screenTarget image.Image
+	if *untilScreen != "" {
+		screenTarget, err = readScreenPNG(*untilScreen)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load reference screen: %v\n", err)
+			return ExitError
+		}
+	}
+	hasCondition := memoryTarget != nil || screenTarget != nil
+
+	palette, err := gameboy.ParseTilePalette(*tilesPalette)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return ExitError
+	}
+	var tileMap uint16
+	if *tileMapAddress != "" {
+		if tileMap, err = gameboy.ParseTileMapAddress(*tileMapAddress); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+	}
+
+	cartridge, err := cartridges.Load(romFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to load ROM: %v\n", err)
+		return ExitError
+	}
+
+	// Construct Game Boy emulator
+	gb := gameboy.NewGameBoy(!*runBootROM, false)
+	gb.LoadCartridge(cartridge)
+
+	linkFlags := 0
+	for _, value := range []string{*linkListen, *linkConnect, *printerDir} {
+		if value != "" {
+			linkFlags++
+		}
+	}
+	if linkFlags > 1 {
+		fmt.Fprintln(os.Stderr, "Only one of -link-listen, -link-connect and -printer can be used")
+		return ExitError
+	}
+	if *linkListen != "" || *linkConnect != "" {
+		var linkPeer *link.TCPPeer
+		if *linkListen != "" {
+			linkPeer, err = link.Listen(*linkListen)
+		} else {
+			linkPeer, err = link.Dial(*linkConnect)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to connect link cable: %v\n", err)
+			return ExitError
+		}
+		defer linkPeer.Close()
+		linkPeer.SetReplyTimeout(*linkTimeout)
+		gb.SetLinkPeer(linkPeer)
+	}
+	if *printerDir != "" {
+		gbPrinter, err := printer.New(*printerDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to connect printer: %v\n", err)
+			return ExitError
+		}
+		gbPrinter.SetPageCallback(func(filename string) { fmt.Printf("Printed to %s\n", filename) })
+		gb.SetLinkPeer(gbPrinter)
+	}
+
+	if *cheatFile != "" {
+		f, err := os.Open(*cheatFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load cheats: %v\n", err)
+			return ExitError
+		}
+		cheats, err := gameboy.LoadCheats(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to load cheats: %v\n", err)
+			return ExitError
+		}
+		gb.SetCheats(cheats)
+	}
+
+	if *movieFile != "" {
+		movie, err := loadMovie(*movieFile)
+		if err == nil {
+			err = gb.PlayMovie(movie)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to play movie: %v\n", err)
+			return ExitError
+		}
+		*frames = len(movie.Frames)
+	}
+	if *recordFile != "" {
+		if err := gb.StartRecording(false); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to start recording: %v\n", err)
+			return ExitError
+		}
+	}
+
+	if *traceFile != "" {
+		options, err := gameboy.ParseTraceOptions(*tracePC, *traceBank, *traceLimit, *traceAfter)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return ExitError
+		}
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to create trace file: %v\n", err)
+			return ExitError
+		}
+		defer f.Close()
+		gb.StartTrace(f, options)
+	}
+
+	conditionMet := false
+	frame := 0
+	for ; frame < *frames; frame++ {
+		if script != nil {
+			buttons := script.buttonsForFrame(frame)
+			gb.SetButtonStates(&buttons)
+		}
+		gb.RunNextFrame()
+
+		if memoryTarget != nil && gb.ReadMemory(memoryTarget.address) == memoryTarget.value {
+			conditionMet = true
+		}
+		if screenTarget != nil && screenMatches(gb, screenTarget) {
+			conditionMet = true
+		}
+		if conditionMet {
+			frame++
+			break
+		}
+	}
+	fmt.Printf("Ran %d frames\n", frame)
+
+	if *traceFile != "" {
+		traced := gb.TracedInstructions()
+		if err := gb.StopTrace(); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write trace: %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("Traced %d instructions to %s\n", traced, *traceFile)
+	}
+
+	if *recordFile != "" {
+		if err := saveMovie(*recordFile, gb.StopRecording()); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write movie: %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("Wrote movie to %s\n", *recordFile)
+	}
+
+	if *screenshot != "" {
+		if err := writePNG(*screenshot, screenImage(gb)); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write screenshot: %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("Wrote screen to %s\n", *screenshot)
+	}
+
+	if *tilesFile != "" {
+		if err := writePNG(*tilesFile, gb.TileSheet(palette)); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write tiles: %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("Wrote tiles to %s\n", *tilesFile)
+	}
+	if *tileMapFile != "" {
+		if tileMap == 0 {
+			tileMap = gameboy.TileMapAddressLow
+			if gb.ReadMemory(gameboy.LCDC)&gameboy.LCDC_bg_map_select != 0 {
+				tileMap = gameboy.TileMapAddressHigh
+			}
+		}
+		if err := writePNG(*tileMapFile, gb.TileMap(tileMap, true)); err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write tile map: %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("Wrote tile map %04X to %s\n", tileMap, *tileMapFile)
+	}
+
+	if *oamFile != "" {
+		f, err := os.Create(*oamFile)
+		if err == nil {
+			err = gb.WriteOAMJSON(f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write OAM: %v\n", err)
+			return ExitError
+		}
+		fmt.Printf("Wrote OAM to %s\n", *oamFile)
+	}
+
+	if desyncFrame, desynced := gb.MovieDesyncFrame(); desynced {
+		fmt.Printf("Movie desynced at frame %d\n", desyncFrame)
+		return ExitMovieDesync
+	}
+	if hasCondition && !conditionMet {
+		fmt.Println("Condition not met")
+		return ExitConditionNotMet
+	}
+	return ExitSuccess
+}
+
+func main() {
+	os.Exit(run())
+}
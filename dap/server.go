@@ -0,0 +1,668 @@
+// Package dap serves the Debug Adapter Protocol over TCP, so editors such as VS Code can debug the code running on
+// the console at the level of RGBDS assembly labels
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cbott/GoEmulate/gameboy"
+	"github.com/cbott/GoEmulate/gameboy/disasm"
+)
+
+// The console only has one thread of execution
+const threadID = 1
+
+// Variable references for the scopes shown for every stack frame
+const (
+	registersReference = iota + 1
+	ioReference
+	wramReference
+)
+
+// Named I/O registers shown in the I/O scope
+var ioRegisters = []struct {
+	name    string
+	address uint16
+}{
+	{"P1", 0xFF00}, {"SB", 0xFF01}, {"SC", 0xFF02}, {"DIV", 0xFF04}, {"TIMA", 0xFF05}, {"TMA", 0xFF06},
+	{"TAC", 0xFF07}, {"IF", 0xFF0F}, {"NR10", 0xFF10}, {"NR11", 0xFF11}, {"NR12", 0xFF12}, {"NR13", 0xFF13},
+	{"NR14", 0xFF14}, {"NR21", 0xFF16}, {"NR22", 0xFF17}, {"NR23", 0xFF18}, {"NR24", 0xFF19}, {"NR30", 0xFF1A},
+	{"NR31", 0xFF1B}, {"NR32", 0xFF1C}, {"NR33", 0xFF1D}, {"NR34", 0xFF1E}, {"NR41", 0xFF20}, {"NR42", 0xFF21},
+	{"NR43", 0xFF22}, {"NR44", 0xFF23}, {"NR50", 0xFF24}, {"NR51", 0xFF25}, {"NR52", 0xFF26}, {"LCDC", 0xFF40},
+	{"STAT", 0xFF41}, {"SCY", 0xFF42}, {"SCX", 0xFF43}, {"LY", 0xFF44}, {"LYC", 0xFF45}, {"DMA", 0xFF46},
+	{"BGP", 0xFF47}, {"OBP0", 0xFF48}, {"OBP1", 0xFF49}, {"WY", 0xFF4A}, {"WX", 0xFF4B}, {"IE", 0xFFFF},
+}
+
+// Work RAM is shown in rows of this many bytes
+const wramRowLength = 16
+
+// Server is a Debug Adapter Protocol server for a single client at a time.
+// Requests are read in the background but only run by Poll, which must be called from the goroutine running
+// the console
+type Server struct {
+	console *gameboy.Gameboy
+	// Creates a new console for launch requests, nil if only attaching is supported
+	launcher func(program string) (*gameboy.Gameboy, error)
+
+	listener    net.Listener
+	connections chan net.Conn
+	messages    chan incoming
+
+	// Connected client, nil if none
+	conn net.Conn
+	seq  int
+	// Events to send once the response to the current request has been sent
+	events []Event
+
+	// Debugger attached by launch or attach, nil before then
+	debugger    *gameboy.Debugger
+	stopOnEntry bool
+	symbols     *disasm.Symbols
+	// Assembly source files which breakpoints have been set in, by path
+	sources map[string]*sourceFile
+
+	// Breakpoints from each kind of request, each request replaces all previous breakpoints of its kind
+	sourceBreakpoints      map[string][]gameboy.Breakpoint
+	functionBreakpoints    []gameboy.Breakpoint
+	instructionBreakpoints []gameboy.Breakpoint
+	nextBreakpointID       int
+}
+
+// A message read from a connection, or the error which ended it
+type incoming struct {
+	conn    net.Conn
+	request *Request
+	err     error
+}
+
+// NewServer creates a server for debugging the given console
+func NewServer(console *gameboy.Gameboy) *Server {
+	return &Server{
+		console:     console,
+		connections: make(chan net.Conn),
+		messages:    make(chan incoming, 64),
+	}
+}
+
+// SetLauncher enables launch requests, which replace the console with one created by launcher for the ROM file
+// named by the request
+func (s *Server) SetLauncher(launcher func(program string) (*gameboy.Gameboy, error)) {
+	s.launcher = launcher
+}
+
+// Console returns the console being debugged, which changes after a launch request
+func (s *Server) Console() *gameboy.Gameboy {
+	return s.console
+}
+
+// Listen starts accepting clients on a TCP address such as "localhost:4711"
+func (s *Server) Listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.connections <- conn
+		}
+	}()
+	return nil
+}
+
+// Addr returns the address the server is listening on
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops listening and disconnects any client
+func (s *Server) Close() error {
+	s.endSession()
+	return s.listener.Close()
+}
+
+// Read messages from a connection until it is closed
+func (s *Server) read(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		content, err := readMessage(r)
+		if err != nil {
+			s.messages <- incoming{conn: conn, err: err}
+			return
+		}
+		var request Request
+		if err := json.Unmarshal(content, &request); err != nil {
+			s.messages <- incoming{conn: conn, err: fmt.Errorf("invalid message: %v", err)}
+			return
+		}
+		s.messages <- incoming{conn: conn, request: &request}
+	}
+}
+
+// Poll runs any requests received since the last call
+func (s *Server) Poll() {
+	s.flushEvents()
+	for {
+		select {
+		case conn := <-s.connections:
+			if s.conn != nil {
+				// Only one client can control the console at a time
+				conn.Close()
+				continue
+			}
+			s.conn = conn
+			go s.read(conn)
+		case message := <-s.messages:
+			if message.conn != s.conn {
+				// Left over from a client which has gone
+				continue
+			}
+			if message.err != nil {
+				s.endSession()
+				continue
+			}
+			s.handle(message.request)
+			s.flushEvents()
+		default:
+			return
+		}
+	}
+}
+
+// Send a message to the client, disconnecting it if the message cannot be sent
+func (s *Server) send(message interface{}) {
+	if s.conn == nil {
+		return
+	}
+	if err := writeMessage(s.conn, message); err != nil {
+		log.Printf("DAP client write failed: %v", err)
+		s.endSession()
+	}
+}
+
+func (s *Server) nextSeq() int {
+	s.seq++
+	return s.seq
+}
+
+// Queue an event to be sent after the response to the current request
+func (s *Server) event(name string, body interface{}) {
+	s.events = append(s.events, Event{Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) flushEvents() {
+	events := s.events
+	s.events = nil
+	for _, event := range events {
+		event.Seq = s.nextSeq()
+		s.send(event)
+	}
+}
+
+// Detach from the console and disconnect the client, the console keeps running
+func (s *Server) endSession() {
+	if s.debugger != nil {
+		s.console.DetachDebugger()
+		s.debugger = nil
+	}
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	s.events = nil
+	s.sourceBreakpoints = nil
+	s.functionBreakpoints = nil
+	s.instructionBreakpoints = nil
+}
+
+// Run a request and send its response
+func (s *Server) handle(request *Request) {
+	body, err := s.run(request)
+	response := Response{
+		Seq:        s.nextSeq(),
+		Type:       "response",
+		RequestSeq: request.Seq,
+		Success:    err == nil,
+		Command:    request.Command,
+		Body:       body,
+	}
+	if err != nil {
+		response.Message = err.Error()
+	}
+	s.send(response)
+
+	if request.Command == "disconnect" {
+		s.endSession()
+	}
+}
+
+// Run a request, returning the body of its response
+func (s *Server) run(request *Request) (interface{}, error) {
+	switch request.Command {
+	case "initialize":
+		return capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsFunctionBreakpoints:      true,
+			SupportsInstructionBreakpoints:   true,
+			SupportsSetVariable:              true,
+		}, nil
+	case "launch", "attach":
+		var args launchArguments
+		if err := decodeArguments(request, &args); err != nil {
+			return nil, err
+		}
+		return nil, s.start(request.Command == "launch", args)
+	case "disconnect":
+		return nil, nil
+	case "threads":
+		return threadsBody{Threads: []thread{{ID: threadID, Name: "SM83"}}}, nil
+	}
+
+	// Everything else needs the debugger
+	if s.debugger == nil {
+		return nil, fmt.Errorf("%s before launch or attach", request.Command)
+	}
+	d := s.debugger
+	switch request.Command {
+	case "configurationDone":
+		if s.stopOnEntry {
+			s.event("stopped", stoppedBody{Reason: "entry", ThreadID: threadID, AllThreadsStopped: true})
+		} else {
+			d.Continue()
+		}
+		return nil, nil
+	case "setBreakpoints":
+		var args setBreakpointsArguments
+		if err := decodeArguments(request, &args); err != nil {
+			return nil, err
+		}
+		return s.setSourceBreakpoints(args), nil
+	case "setFunctionBreakpoints":
+		var args setFunctionBreakpointsArguments
+		if err := decodeArguments(request, &args); err != nil {
+			return nil, err
+		}
+		return s.setFunctionBreakpoints(args), nil
+	case "setInstructionBreakpoints":
+		var args setInstructionBreakpointsArguments
+		if err := decodeArguments(request, &args); err != nil {
+			return nil, err
+		}
+		return s.setInstructionBreakpoints(args), nil
+	case "stackTrace":
+		var args stackTraceArguments
+		if err := decodeArguments(request, &args); err != nil {
+			return nil, err
+		}
+		return s.stackTrace(args), nil
+	case "scopes":
+		return scopesBody{Scopes: []scope{
+			{Name: "Registers", VariablesReference: registersReference},
+			{Name: "I/O", VariablesReference: ioReference},
+			{Name: "WRAM", VariablesReference: wramReference, Expensive: true},
+		}}, nil
+	case "variables":
+		var args variablesArguments
+		if err := decodeArguments(request, &args); err != nil {
+			return nil, err
+		}
+		return s.variables(args.VariablesReference), nil
+	case "setVariable":
+		var args setVariableArguments
+		if err := decodeArguments(request, &args); err != nil {
+			return nil, err
+		}
+		return s.setVariable(args)
+	case "continue":
+		d.Continue()
+		return continueBody{AllThreadsContinued: true}, nil
+	case "next":
+		d.StepOver()
+		return nil, nil
+	case "stepIn":
+		d.StepInto()
+		return nil, nil
+	case "stepOut":
+		d.StepOut()
+		return nil, nil
+	case "pause":
+		if !d.Paused() {
+			d.Pause()
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", request.Command)
+}
+
+func decodeArguments(request *Request, args interface{}) error {
+	if len(request.Arguments) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(request.Arguments, args); err != nil {
+		return fmt.Errorf("invalid %s arguments: %v", request.Command, err)
+	}
+	return nil
+}
+
+// Handle launch and attach, the console stays paused until configuration is done
+func (s *Server) start(launch bool, args launchArguments) error {
+	if s.debugger != nil {
+		return fmt.Errorf("already debugging")
+	}
+
+	symbols := disasm.NewSymbols()
+	if args.SymbolFile != "" {
+		loaded, err := disasm.LoadSymbolFile(args.SymbolFile)
+		if err != nil {
+			return err
+		}
+		symbols.Merge(loaded)
+	}
+	if args.MapFile != "" {
+		loaded, err := disasm.LoadMapFile(args.MapFile)
+		if err != nil {
+			return err
+		}
+		symbols.Merge(loaded)
+	}
+
+	if launch {
+		if s.launcher == nil {
+			return fmt.Errorf("launch is not supported, attach to the running console instead")
+		}
+		if args.Program == "" {
+			return fmt.Errorf("launch requires a program")
+		}
+		console, err := s.launcher(args.Program)
+		if err != nil {
+			return err
+		}
+		s.console = console
+	}
+
+	s.symbols = symbols
+	s.sources = make(map[string]*sourceFile)
+	s.sourceBreakpoints = make(map[string][]gameboy.Breakpoint)
+	s.stopOnEntry = args.StopOnEntry
+	s.debugger = s.console.AttachDebugger()
+	s.debugger.Pause()
+	s.debugger.SetStopHandler(s.stopped)
+	s.event("initialized", nil)
+	return nil
+}
+
+// Called by the debugger whenever execution stops
+func (s *Server) stopped(reason gameboy.StopReason) {
+	body := stoppedBody{ThreadID: threadID, AllThreadsStopped: true}
+	switch reason {
+	case gameboy.StopBreakpoint:
+		body.Reason = "breakpoint"
+	case gameboy.StopStep:
+		body.Reason = "step"
+	case gameboy.StopWatchpoint:
+		body.Reason = "data breakpoint"
+		body.Description = s.debugger.LastWatchHit().String()
+	default:
+		body.Reason = "pause"
+	}
+	s.event("stopped", body)
+}
+
+// Breakpoint on a symbol, limited to the symbol's bank when it is in switchable ROM
+func symbolBreakpoint(symbol disasm.Symbol) gameboy.Breakpoint {
+	if symbol.Address >= 0x4000 && symbol.Address < 0x8000 {
+		return gameboy.Breakpoint{Bank: symbol.Bank, Address: symbol.Address}
+	}
+	return gameboy.Breakpoint{Bank: gameboy.AnyBank, Address: symbol.Address}
+}
+
+func (s *Server) newBreakpointID() int {
+	s.nextBreakpointID++
+	return s.nextBreakpointID
+}
+
+func (s *Server) setSourceBreakpoints(args setBreakpointsArguments) breakpointsBody {
+	path := args.Source.Path
+	file, err := loadSourceFile(path)
+	if err == nil {
+		s.sources[path] = file
+	}
+
+	var breakpoints []gameboy.Breakpoint
+	body := breakpointsBody{Breakpoints: []breakpoint{}}
+	for _, requested := range args.Breakpoints {
+		result := breakpoint{ID: s.newBreakpointID(), Line: requested.Line, Source: &args.Source}
+		label, found := "", false
+		if file != nil {
+			label, found = file.labelForLine(requested.Line)
+		}
+		symbol, known := s.symbols.Lookup(label)
+		switch {
+		case err != nil:
+			result.Message = fmt.Sprintf("Unable to read source: %v", err)
+		case !found:
+			result.Message = "Breakpoints can only be set on a label or the first instruction after it"
+		case !known:
+			result.Message = fmt.Sprintf("Label %s is not in the symbol or map file", label)
+		default:
+			bp := symbolBreakpoint(symbol)
+			breakpoints = append(breakpoints, bp)
+			result.Verified = true
+			result.InstructionReference = bp.String()
+		}
+		body.Breakpoints = append(body.Breakpoints, result)
+	}
+	s.sourceBreakpoints[path] = breakpoints
+	s.updateBreakpoints()
+	return body
+}
+
+func (s *Server) setFunctionBreakpoints(args setFunctionBreakpointsArguments) breakpointsBody {
+	s.functionBreakpoints = nil
+	body := breakpointsBody{Breakpoints: []breakpoint{}}
+	for _, requested := range args.Breakpoints {
+		result := breakpoint{ID: s.newBreakpointID()}
+		if symbol, ok := s.symbols.Lookup(requested.Name); ok {
+			bp := symbolBreakpoint(symbol)
+			s.functionBreakpoints = append(s.functionBreakpoints, bp)
+			result.Verified = true
+			result.InstructionReference = bp.String()
+		} else {
+			result.Message = fmt.Sprintf("No label named %s", requested.Name)
+		}
+		body.Breakpoints = append(body.Breakpoints, result)
+	}
+	s.updateBreakpoints()
+	return body
+}
+
+func (s *Server) setInstructionBreakpoints(args setInstructionBreakpointsArguments) breakpointsBody {
+	s.instructionBreakpoints = nil
+	body := breakpointsBody{Breakpoints: []breakpoint{}}
+	for _, requested := range args.Breakpoints {
+		result := breakpoint{ID: s.newBreakpointID()}
+		if bp, err := gameboy.ParseBreakpoint(requested.InstructionReference); err == nil {
+			bp.Address += uint16(requested.Offset)
+			s.instructionBreakpoints = append(s.instructionBreakpoints, bp)
+			result.Verified = true
+			result.InstructionReference = bp.String()
+		} else {
+			result.Message = err.Error()
+		}
+		body.Breakpoints = append(body.Breakpoints, result)
+	}
+	s.updateBreakpoints()
+	return body
+}
+
+// Set the debugger's breakpoints to those from every request
+func (s *Server) updateBreakpoints() {
+	s.debugger.ClearBreakpoints()
+	for _, breakpoints := range s.sourceBreakpoints {
+		for _, bp := range breakpoints {
+			s.debugger.AddBreakpoint(bp)
+		}
+	}
+	for _, bp := range s.functionBreakpoints {
+		s.debugger.AddBreakpoint(bp)
+	}
+	for _, bp := range s.instructionBreakpoints {
+		s.debugger.AddBreakpoint(bp)
+	}
+}
+
+// Describe a location by the label it is in, and the source line of the label if known
+func (s *Server) frameAt(id int, location gameboy.Breakpoint) stackFrame {
+	frame := stackFrame{ID: id, Name: location.String(), InstructionPointerReference: location.String()}
+	symbol, ok := s.symbols.Nearest(location.Bank, location.Address)
+	if !ok {
+		return frame
+	}
+	frame.Name = symbol.Name
+	if offset := location.Address - symbol.Address; offset != 0 {
+		frame.Name += fmt.Sprintf("+%d", offset)
+	}
+
+	// Sort paths so the same source is chosen every time if more than one defines the label
+	paths := make([]string, 0, len(s.sources))
+	for path := range s.sources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if line, ok := s.sources[path].lineOf(symbol.Name); ok {
+			frame.Source = &source{Path: path}
+			frame.Line = line
+			frame.Column = 1
+			break
+		}
+	}
+	return frame
+}
+
+func (s *Server) stackTrace(args stackTraceArguments) stackTraceBody {
+	frames := []stackFrame{s.frameAt(0, s.debugger.CurrentLocation())}
+	for i, call := range s.debugger.CallStack() {
+		frame := s.frameAt(i+1, call.Caller)
+		if call.Interrupt {
+			frame.Name += " (interrupted)"
+		}
+		frames = append(frames, frame)
+	}
+
+	body := stackTraceBody{TotalFrames: len(frames)}
+	start := args.StartFrame
+	if start > len(frames) {
+		start = len(frames)
+	}
+	end := len(frames)
+	if args.Levels > 0 && start+args.Levels < end {
+		end = start + args.Levels
+	}
+	body.StackFrames = frames[start:end]
+	return body
+}
+
+func (s *Server) variables(reference int) variablesBody {
+	console := s.console
+	body := variablesBody{Variables: []variable{}}
+	add := func(name string, value string, address int) {
+		v := variable{Name: name, Value: value}
+		if address >= 0 {
+			v.MemoryReference = fmt.Sprintf("%04X", address)
+		}
+		body.Variables = append(body.Variables, v)
+	}
+
+	switch reference {
+	case registersReference:
+		r := s.debugger.Registers()
+		for _, register := range []struct {
+			name  string
+			value uint8
+		}{{"A", r.A}, {"F", r.F}, {"B", r.B}, {"C", r.C}, {"D", r.D}, {"E", r.E}, {"H", r.H}, {"L", r.L}} {
+			add(register.name, fmt.Sprintf("$%02X", register.value), -1)
+		}
+		add("SP", fmt.Sprintf("$%04X", r.SP), -1)
+		add("PC", fmt.Sprintf("$%04X", r.PC), -1)
+		z, n, h, c := r.Flags()
+		for i, set := range []bool{z, n, h, c} {
+			value := "0"
+			if set {
+				value = "1"
+			}
+			add(string("ZNHC"[i]), value, -1)
+		}
+	case ioReference:
+		for _, register := range ioRegisters {
+			add(register.name, fmt.Sprintf("$%02X", console.ReadMemory(register.address)), int(register.address))
+		}
+	case wramReference:
+		// Labelled variables first, then the raw contents
+		var labelled []disasm.Symbol
+		for _, symbol := range s.symbols.All() {
+			if symbol.Address >= 0xC000 && symbol.Address < 0xE000 {
+				labelled = append(labelled, symbol)
+			}
+		}
+		sort.Slice(labelled, func(i, j int) bool {
+			if labelled[i].Address != labelled[j].Address {
+				return labelled[i].Address < labelled[j].Address
+			}
+			return labelled[i].Name < labelled[j].Name
+		})
+		for _, symbol := range labelled {
+			add(symbol.Name, fmt.Sprintf("$%02X", console.ReadMemory(symbol.Address)), int(symbol.Address))
+		}
+		for address := 0xC000; address < 0xE000; address += wramRowLength {
+			values := make([]string, wramRowLength)
+			for i := range values {
+				values[i] = fmt.Sprintf("%02X", console.ReadMemory(uint16(address+i)))
+			}
+			add(fmt.Sprintf("%04X", address), strings.Join(values, " "), address)
+		}
+	}
+	return body
+}
+
+// Set a register or flag from the Registers scope
+func (s *Server) setVariable(args setVariableArguments) (interface{}, error) {
+	if args.VariablesReference != registersReference {
+		return nil, fmt.Errorf("only registers can be changed")
+	}
+	text := strings.TrimSpace(args.Value)
+	switch args.Name {
+	case "Z", "N", "H", "C":
+		value, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("flag value must be 0 or 1")
+		}
+		if err := s.debugger.SetFlag(args.Name, value); err != nil {
+			return nil, err
+		}
+		return setVariableBody{Value: text}, nil
+	}
+
+	value, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(text, "$"), "0x"), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex value %q", args.Value)
+	}
+	if err := s.debugger.SetRegister(args.Name, uint16(value)); err != nil {
+		return nil, err
+	}
+	if len(args.Name) == 1 {
+		return setVariableBody{Value: fmt.Sprintf("$%02X", value)}, nil
+	}
+	return setVariableBody{Value: fmt.Sprintf("$%04X", value)}, nil
+}
@@ -0,0 +1,394 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/gameboy"
+)
+
+// Program which keeps calling a subroutine to store 2 in WRAM, matching testSource and testSymbols
+var testProgram = map[int][]uint8{
+	0x0100: {0x00, 0xC3, 0x50, 0x01}, // NOP; JP Main
+	0x0150: {
+		0x3E, 0x01, // Main: LD A,1
+		0xCD, 0x60, 0x01, // .loop: CALL Sub
+		0x18, 0xF9, // JR Main
+	},
+	0x0160: {
+		0x3C,             // Sub: INC A
+		0xEA, 0x00, 0xC0, // LD (wCounter),A
+		0xC9, // RET
+	},
+}
+
+const testSource = `SECTION "Main", ROM0[$150]
+Main:
+    ld a, 1
+.loop:
+    call Sub
+    jr Main
+
+SECTION "Sub", ROM0[$160]
+Sub: ; count up
+    inc a
+    ld [wCounter], a
+    ret
+`
+
+const testSymbols = `; File generated by rgblink
+00:0150 Main
+00:0152 Main.loop
+00:0160 Sub
+00:c000 wCounter
+`
+
+func newTestConsole() *gameboy.Gameboy {
+	rom := make([]uint8, 2*cartridges.ROMBankSize)
+	for address, code := range testProgram {
+		copy(rom[address:], code)
+	}
+	gb := gameboy.NewGameBoy(true, false)
+	gb.LoadCartridge(cartridges.NewROMOnlyCartridge(rom))
+	return gb
+}
+
+// A message from the server, either a response or an event
+type testMessage struct {
+	Seq        int             `json:"seq"`
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// testClient scripts a debugging session against a server, as an editor would
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	r      *bufio.Reader
+	seq    int
+	events []testMessage
+}
+
+func (c *testClient) read() testMessage {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	content, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatalf("Unable to read message: %v", err)
+	}
+	var message testMessage
+	if err := json.Unmarshal(content, &message); err != nil {
+		c.t.Fatalf("Invalid message %s: %v", content, err)
+	}
+	return message
+}
+
+// Send a request and wait for its response, keeping any events received in the meantime.
+// The response body is decoded into body if it is not nil
+func (c *testClient) request(command string, args interface{}, body interface{}) testMessage {
+	c.seq++
+	arguments, _ := json.Marshal(args)
+	if err := writeMessage(c.conn, Request{Seq: c.seq, Type: "request", Command: command, Arguments: arguments}); err != nil {
+		c.t.Fatalf("Unable to send %s request: %v", command, err)
+	}
+	for {
+		message := c.read()
+		if message.Type == "event" {
+			c.events = append(c.events, message)
+			continue
+		}
+		if message.RequestSeq != c.seq || message.Command != command {
+			c.t.Fatalf("Unexpected response %+v to %s request", message, command)
+		}
+		if body != nil {
+			if err := json.Unmarshal(message.Body, body); err != nil {
+				c.t.Fatalf("Invalid %s response body %s: %v", command, message.Body, err)
+			}
+		}
+		return message
+	}
+}
+
+// Send a request which must succeed
+func (c *testClient) mustRequest(command string, args interface{}, body interface{}) {
+	if response := c.request(command, args, body); !response.Success {
+		c.t.Fatalf("%s request failed: %s", command, response.Message)
+	}
+}
+
+// Wait for an event, returning its body
+func (c *testClient) waitEvent(name string) json.RawMessage {
+	for {
+		for i, event := range c.events {
+			if event.Event == name {
+				c.events = append(c.events[:i], c.events[i+1:]...)
+				return event.Body
+			}
+		}
+		c.events = append(c.events, c.read())
+	}
+}
+
+// Wait for execution to stop, checking the reason
+func (c *testClient) waitStopped(reason string) {
+	var body stoppedBody
+	json.Unmarshal(c.waitEvent("stopped"), &body)
+	if body.Reason != reason {
+		c.t.Fatalf("Expected to stop for %q, stopped for %q", reason, body.Reason)
+	}
+}
+
+// Return the names of the stack frames and the source line of each
+func (c *testClient) stackTrace() ([]string, []int) {
+	var body stackTraceBody
+	c.mustRequest("stackTrace", stackTraceArguments{}, &body)
+	names := make([]string, len(body.StackFrames))
+	lines := make([]int, len(body.StackFrames))
+	for i, frame := range body.StackFrames {
+		names[i] = frame.Name
+		lines[i] = frame.Line
+	}
+	return names, lines
+}
+
+// Return the value of a variable in a scope
+func (c *testClient) variable(reference int, name string) string {
+	var body variablesBody
+	c.mustRequest("variables", variablesArguments{VariablesReference: reference}, &body)
+	for _, v := range body.Variables {
+		if v.Name == name {
+			return v.Value
+		}
+	}
+	c.t.Fatalf("No variable %s in scope %d", name, reference)
+	return ""
+}
+
+// Start a server running the console in the background, and connect a client to it.
+// The returned function stops the server once the test is done with it
+func startTestServer(t *testing.T, server *Server) (*testClient, func()) {
+	if err := server.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+
+	done := make(chan bool)
+	var running sync.WaitGroup
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			server.Poll()
+			server.Console().RunNextFrame()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Unable to connect: %v", err)
+	}
+	client := &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+	return client, func() {
+		conn.Close()
+		close(done)
+		running.Wait()
+		server.Close()
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDebugSession(t *testing.T) {
+	dir := t.TempDir()
+	sourcePath := filepath.Join(dir, "main.asm")
+	symbolPath := filepath.Join(dir, "game.sym")
+	ioutil.WriteFile(sourcePath, []byte(testSource), 0644)
+	ioutil.WriteFile(symbolPath, []byte(testSymbols), 0644)
+
+	server := NewServer(newTestConsole())
+	client, stop := startTestServer(t, server)
+
+	var capabilities capabilities
+	client.mustRequest("initialize", map[string]string{"adapterID": "gameboy"}, &capabilities)
+	if !capabilities.SupportsConfigurationDoneRequest || !capabilities.SupportsFunctionBreakpoints {
+		t.Errorf("Unexpected capabilities %+v", capabilities)
+	}
+	if response := client.request("stackTrace", stackTraceArguments{}, nil); response.Success {
+		t.Errorf("Expected stackTrace to fail before attaching")
+	}
+
+	client.mustRequest("attach", launchArguments{SymbolFile: symbolPath, StopOnEntry: true}, nil)
+	client.waitEvent("initialized")
+
+	// The line after Sub has the same address, the one after that does not have a known address
+	var breakpoints breakpointsBody
+	client.mustRequest("setBreakpoints", setBreakpointsArguments{
+		Source:      source{Path: sourcePath},
+		Breakpoints: []sourceBreakpoint{{Line: 10}, {Line: 11}},
+	}, &breakpoints)
+	if len(breakpoints.Breakpoints) != 2 || !breakpoints.Breakpoints[0].Verified || breakpoints.Breakpoints[1].Verified {
+		t.Fatalf("Unexpected breakpoints %+v", breakpoints.Breakpoints)
+	}
+	if reference := breakpoints.Breakpoints[0].InstructionReference; reference != "0160" {
+		t.Errorf("Expected breakpoint at 0160, got %s", reference)
+	}
+
+	client.mustRequest("configurationDone", nil, nil)
+	client.waitStopped("entry")
+
+	client.mustRequest("continue", nil, nil)
+	client.waitStopped("breakpoint")
+	names, lines := client.stackTrace()
+	if !equalStrings(names, []string{"Sub", "Main.loop"}) || lines[0] != 9 || lines[1] != 4 {
+		t.Errorf("Unexpected stack %v at lines %v", names, lines)
+	}
+	if a := client.variable(registersReference, "A"); a != "$01" {
+		t.Errorf("Expected A to be $01, got %s", a)
+	}
+
+	client.mustRequest("stepIn", nil, nil)
+	client.waitStopped("step")
+	if names, _ := client.stackTrace(); names[0] != "Sub+1" {
+		t.Errorf("Expected to step to Sub+1, got %v", names)
+	}
+	client.mustRequest("stepOut", nil, nil)
+	client.waitStopped("step")
+	if names, _ := client.stackTrace(); !equalStrings(names, []string{"Main.loop+3"}) {
+		t.Errorf("Expected to step out to Main.loop+3, got %v", names)
+	}
+	if counter := client.variable(wramReference, "wCounter"); counter != "$02" {
+		t.Errorf("Expected wCounter to be $02, got %s", counter)
+	}
+	if ly := client.variable(ioReference, "LCDC"); ly != "$91" {
+		t.Errorf("Expected LCDC to be $91, got %s", ly)
+	}
+
+	var set setVariableBody
+	client.mustRequest("setVariable", setVariableArguments{VariablesReference: registersReference, Name: "A", Value: "$10"}, &set)
+	if set.Value != "$10" || client.variable(registersReference, "A") != "$10" {
+		t.Errorf("Expected A to be set to $10, got %s", set.Value)
+	}
+
+	// Replace the source breakpoint with one on a label name
+	client.mustRequest("setBreakpoints", setBreakpointsArguments{Source: source{Path: sourcePath}}, nil)
+	client.mustRequest("setFunctionBreakpoints", setFunctionBreakpointsArguments{
+		Breakpoints: []functionBreakpoint{{Name: "Main.loop"}, {Name: "Missing"}},
+	}, &breakpoints)
+	if !breakpoints.Breakpoints[0].Verified || breakpoints.Breakpoints[1].Verified {
+		t.Errorf("Unexpected function breakpoints %+v", breakpoints.Breakpoints)
+	}
+	client.mustRequest("next", nil, nil)
+	client.waitStopped("step")
+	if names, _ := client.stackTrace(); !equalStrings(names, []string{"Main"}) {
+		t.Errorf("Expected to step to Main, got %v", names)
+	}
+	client.mustRequest("continue", nil, nil)
+	client.waitStopped("breakpoint")
+	if names, _ := client.stackTrace(); !equalStrings(names, []string{"Main.loop"}) {
+		t.Errorf("Expected to stop at Main.loop, got %v", names)
+	}
+
+	// Stepping over the call runs the whole subroutine
+	client.mustRequest("next", nil, nil)
+	client.waitStopped("step")
+	if names, _ := client.stackTrace(); !equalStrings(names, []string{"Main.loop+3"}) {
+		t.Errorf("Expected to step over the call to Main.loop+3, got %v", names)
+	}
+	if a := client.variable(registersReference, "A"); a != "$02" {
+		t.Errorf("Expected A to be $02 after the call, got %s", a)
+	}
+
+	// Instruction breakpoints take an address
+	client.mustRequest("setFunctionBreakpoints", setFunctionBreakpointsArguments{}, nil)
+	client.mustRequest("setInstructionBreakpoints", setInstructionBreakpointsArguments{
+		Breakpoints: []instructionBreakpoint{{InstructionReference: "0160", Offset: 1}},
+	}, nil)
+	client.mustRequest("continue", nil, nil)
+	client.waitStopped("breakpoint")
+	if names, _ := client.stackTrace(); !equalStrings(names, []string{"Sub+1", "Main.loop"}) {
+		t.Errorf("Expected to stop at Sub+1, got %v", names)
+	}
+
+	client.mustRequest("setInstructionBreakpoints", setInstructionBreakpointsArguments{}, nil)
+	client.mustRequest("continue", nil, nil)
+	client.mustRequest("pause", nil, nil)
+	client.waitStopped("pause")
+
+	client.mustRequest("disconnect", nil, nil)
+	stop()
+	if server.Console().DebuggerPaused() {
+		t.Errorf("Expected the console to keep running after disconnecting")
+	}
+}
+
+func TestLaunch(t *testing.T) {
+	server := NewServer(newTestConsole())
+	client, stop := startTestServer(t, server)
+	if response := client.request("launch", launchArguments{Program: "game.gb"}, nil); response.Success {
+		t.Errorf("Expected launch to fail without a launcher")
+	}
+	stop()
+
+	launched := newTestConsole()
+	server = NewServer(newTestConsole())
+	server.SetLauncher(func(program string) (*gameboy.Gameboy, error) {
+		if program != "game.gb" {
+			t.Errorf("Unexpected program %s", program)
+		}
+		return launched, nil
+	})
+	client, stop = startTestServer(t, server)
+	client.mustRequest("initialize", nil, nil)
+	client.mustRequest("launch", launchArguments{Program: "game.gb"}, nil)
+	client.waitEvent("initialized")
+	client.mustRequest("configurationDone", nil, nil)
+	client.mustRequest("pause", nil, nil)
+	client.waitStopped("pause")
+	stop()
+	if server.Console() != launched {
+		t.Errorf("Expected the launched console to be debugged")
+	}
+}
+
+func TestSourceLabels(t *testing.T) {
+	file, err := parseSource(strings.NewReader(testSource))
+	if err != nil {
+		t.Fatalf("Unable to parse source: %v", err)
+	}
+	expected := map[int]string{1: "", 2: "Main", 3: "Main", 4: "Main.loop", 5: "Main.loop", 6: "", 9: "Sub", 10: "Sub", 11: ""}
+	for line, label := range expected {
+		if found, _ := file.labelForLine(line); found != label {
+			t.Errorf("Expected line %d to be at label %q, got %q", line, label, found)
+		}
+	}
+	if line, ok := file.lineOf("Main.loop"); !ok || line != 4 {
+		t.Errorf("Expected Main.loop on line 4, got %d", line)
+	}
+}
@@ -0,0 +1,130 @@
+package dap
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+)
+
+/*
+RGBDS symbol and map files only give the address of each label, not of each source line, so source lines are
+mapped to addresses through the labels defined in the assembly source. A breakpoint on a line defining a label,
+or on the first instruction after a label with only blank lines and comments in between, is placed at the label.
+
+	Main:              ; line 1, Main
+	    ; set up       ; line 2, no code
+	    ld a, 1        ; line 3, Main
+	    ld b, 2        ; line 4, unknown address
+	.loop:             ; line 5, Main.loop
+*/
+
+// sourceFile holds the labels defined in an assembly source file
+type sourceFile struct {
+	// Label defined on each line, indexed from 0, "" for lines without one
+	labels []string
+	// Whether each line holds an instruction or directive, after any label
+	code []bool
+}
+
+func loadSourceFile(path string) (*sourceFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseSource(f)
+}
+
+func parseSource(r io.Reader) (*sourceFile, error) {
+	file := &sourceFile{}
+	scope := ""
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, ';'); comment >= 0 {
+			line = line[:comment]
+		}
+
+		label, rest := splitLabel(line)
+		if strings.HasPrefix(label, ".") {
+			label = scope + label
+		} else if label != "" {
+			scope, _, _ = strings.Cut(label, ".")
+		}
+		file.labels = append(file.labels, label)
+		file.code = append(file.code, strings.TrimSpace(rest) != "")
+	}
+	return file, scanner.Err()
+}
+
+// Split a line into the label it defines, if any, and the rest of the line
+func splitLabel(line string) (string, string) {
+	trimmed := strings.TrimLeft(line, " \t")
+	end := strings.IndexAny(trimmed, " \t")
+	if end < 0 {
+		end = len(trimmed)
+	}
+	field := trimmed[:end]
+
+	name := strings.TrimRight(field, ":")
+	switch {
+	case name != field:
+		// Labels are followed by one colon, or two when exported
+	case strings.HasPrefix(field, ".") && trimmed == line:
+		// Local labels at the start of a line do not need a colon
+	default:
+		return "", line
+	}
+	if !isIdentifier(name) {
+		return "", line
+	}
+	return name, trimmed[end:]
+}
+
+func isIdentifier(name string) bool {
+	if name == "" || name == "." {
+		return false
+	}
+	for i, r := range name {
+		letter := r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '_' || r == '.'
+		if !letter && !(i > 0 && (r >= '0' && r <= '9' || r == '@' || r == '#' || r == '$')) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the label which has the same address as a line, numbered from 1
+func (f *sourceFile) labelForLine(line int) (string, bool) {
+	index := line - 1
+	if index < 0 || index >= len(f.labels) {
+		return "", false
+	}
+	if f.labels[index] != "" {
+		return f.labels[index], true
+	}
+	if !f.code[index] {
+		return "", false
+	}
+	// Look back past blank lines for a label with nothing after it
+	for index--; index >= 0; index-- {
+		if f.code[index] {
+			return "", false
+		}
+		if f.labels[index] != "" {
+			return f.labels[index], true
+		}
+	}
+	return "", false
+}
+
+// Returns the line a label is defined on, numbered from 1
+func (f *sourceFile) lineOf(label string) (int, bool) {
+	for index, name := range f.labels {
+		if name == label {
+			return index + 1, true
+		}
+	}
+	return 0, false
+}
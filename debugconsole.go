@@ -0,0 +1,656 @@
+// Debug Console
+// this file handles the terminal REPL for controlling the debugger, enabled with --debug-console
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/cbott/GoEmulate/gameboy"
+)
+
+const debugConsoleHelp = `Commands:
+  c, continue          resume execution
+  p, pause             stop execution
+  s, step              run one instruction
+  n, next              run one instruction, stepping over calls
+  finish               run until the current subroutine returns
+  until [BB:]ADDR      run until the address is reached
+  b, break [BB:]ADDR   set a breakpoint, optionally only in ROM bank BB
+  d, delete [BB:]ADDR  remove a breakpoint ("delete all" removes every breakpoint)
+  breakpoints          list breakpoints
+  watch [r|w|c] ADDR[-END] [=VAL] [log]
+                       stop on a read, write (default) or value change of an address or range,
+                       optionally only for value VAL, or with "log" print the access and keep running
+  unwatch N            remove watchpoint N ("unwatch all" removes every watchpoint)
+  watchpoints          list watchpoints
+  r, regs              show registers and flags
+  set REG VALUE        set a register (A F B C D E H L AF BC DE HL SP PC, hex value) or flag (Z N H C, 0/1)
+  stack [N]            show N entries from the top of the stack
+  bt, backtrace        show the calls and interrupts which have not yet returned
+  x ADDR [N]           show N bytes of memory starting at ADDR
+  l, list [ADDR] [N]   disassemble N instructions starting at ADDR (default PC)
+  tiles FILE [PAL]     write every tile in VRAM to a PNG file, colored with palette bgp (default), obp0 or obp1
+  tilemap FILE [MAP]   write tile map 9800 or 9C00 (default the background's) to a PNG file, outlining the
+                       visible background in red and window in green
+  oam [LINE]           list every sprite in OAM, marking those drawn (*) or dropped (!) on screen line LINE
+  cheats               list cheats
+  cheat CODE [DESC]    add a Game Genie (ABC-DEF-GHI) or GameShark (01VVLLHH) code
+  cheat on|off N|all   turn cheat N, or all cheats at once, on or off
+  cheat del N          remove cheat N
+  cheat save           write the cheats to the ROM's cheat file
+  search start [8|16] [signed]
+                       start a RAM search over cartridge RAM, WRAM and HRAM, for 8 (default) or 16 bit values
+  search OP [VALUE]    keep the candidates comparing (=, !=, >, <, >=, <=) with VALUE (decimal, or hex
+                       with $), or without VALUE with their value at the previous search
+  search list [N]      show N (default 20) of the remaining candidates
+  search freeze ADDR [DESC]
+                       hold an address at its current value with GameShark cheats
+  help                 show this help
+`
+
+// debugConsole reads commands from the terminal and runs them against the debugger
+// Commands are read on a separate goroutine and executed by poll, which is called from the emulator's update loop
+type debugConsole struct {
+	console  *gameboy.Gameboy
+	debugger *gameboy.Debugger
+	commands chan string
+	out      io.Writer
+	// Set once the prompt has been shown for the current command
+	prompted bool
+	// File "cheat save" writes to
+	cheatFile string
+	// RAM search in progress, nil before "search start"
+	search *gameboy.RAMSearch
+}
+
+// Attach a debugger to the console and start reading commands from stdin
+// The console starts out paused so breakpoints can be set before anything runs
+func newDebugConsole(console *gameboy.Gameboy) *debugConsole {
+	c := &debugConsole{
+		console:  console,
+		debugger: console.AttachDebugger(),
+		commands: make(chan string, 16),
+		out:      os.Stdout,
+	}
+	c.debugger.SetStopHandler(c.stopped)
+	c.debugger.SetWatchHandler(func(hit gameboy.WatchHit) {
+		fmt.Fprintf(c.out, "Watchpoint: %v\n", hit)
+	})
+	c.debugger.Pause()
+
+	go func() {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			c.commands <- scanner.Text()
+		}
+	}()
+	return c
+}
+
+// Run any commands entered since the last call
+func (c *debugConsole) poll() {
+	for {
+		select {
+		case line := <-c.commands:
+			c.prompted = false
+			c.execute(line)
+			if c.debugger.Paused() && !c.prompted {
+				c.prompt()
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (c *debugConsole) prompt() {
+	fmt.Fprint(c.out, "(gb) ")
+	c.prompted = true
+}
+
+// Called by the debugger whenever execution stops
+func (c *debugConsole) stopped(reason gameboy.StopReason) {
+	fmt.Fprintf(c.out, "\nStopped (%s) at %v\n", reason, c.debugger.CurrentLocation())
+	if reason == gameboy.StopWatchpoint {
+		fmt.Fprintf(c.out, "Watchpoint: %v\n", c.debugger.LastWatchHit())
+	}
+	c.printRegisters()
+	c.printInstructions(c.debugger.Registers().PC, 1)
+	c.prompt()
+}
+
+// Run a single command
+func (c *debugConsole) execute(line string) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return
+	}
+	command, args := fields[0], fields[1:]
+
+	var err error
+	switch command {
+	case "c", "continue":
+		c.debugger.Continue()
+	case "p", "pause":
+		if !c.debugger.Paused() {
+			c.debugger.Pause()
+		}
+	case "s", "step":
+		c.debugger.StepInto()
+	case "n", "next":
+		c.debugger.StepOver()
+	case "finish":
+		c.debugger.StepOut()
+	case "until":
+		var bp gameboy.Breakpoint
+		if bp, err = c.breakpointArg(args); err == nil {
+			c.debugger.RunTo(bp)
+		}
+	case "b", "break":
+		var bp gameboy.Breakpoint
+		if bp, err = c.breakpointArg(args); err == nil {
+			c.debugger.AddBreakpoint(bp)
+			fmt.Fprintf(c.out, "Breakpoint set at %v\n", bp)
+		}
+	case "d", "delete":
+		err = c.deleteBreakpoint(args)
+	case "breakpoints":
+		for _, bp := range c.debugger.Breakpoints() {
+			fmt.Fprintln(c.out, bp)
+		}
+	case "watch":
+		var wp gameboy.Watchpoint
+		if wp, err = parseWatchpoint(args); err == nil {
+			c.debugger.AddWatchpoint(wp)
+			fmt.Fprintf(c.out, "Watchpoint set: %v\n", wp)
+		}
+	case "unwatch":
+		err = c.deleteWatchpoint(args)
+	case "watchpoints":
+		for i, wp := range c.debugger.Watchpoints() {
+			fmt.Fprintf(c.out, "%d: %v\n", i+1, wp)
+		}
+	case "r", "regs":
+		c.printRegisters()
+	case "set":
+		err = c.set(args)
+	case "stack":
+		err = c.printStack(args)
+	case "bt", "backtrace":
+		c.printBacktrace()
+	case "x":
+		err = c.printMemory(args)
+	case "l", "list":
+		err = c.list(args)
+	case "tiles":
+		err = c.writeTiles(args)
+	case "tilemap":
+		err = c.writeTileMap(args)
+	case "oam":
+		err = c.printOAM(args)
+	case "cheats":
+		c.printCheats()
+	case "cheat":
+		err = c.cheat(args)
+	case "search":
+		err = c.ramSearch(args)
+	case "help":
+		fmt.Fprint(c.out, debugConsoleHelp)
+	default:
+		err = fmt.Errorf("unknown command %q, try \"help\"", command)
+	}
+
+	if err != nil {
+		fmt.Fprintln(c.out, err)
+	}
+}
+
+// Parse the single breakpoint argument of a command
+func (c *debugConsole) breakpointArg(args []string) (gameboy.Breakpoint, error) {
+	if len(args) != 1 {
+		return gameboy.Breakpoint{}, fmt.Errorf("expected an address such as 0150 or 03:4A10")
+	}
+	return gameboy.ParseBreakpoint(args[0])
+}
+
+func (c *debugConsole) deleteBreakpoint(args []string) error {
+	if len(args) == 1 && args[0] == "all" {
+		c.debugger.ClearBreakpoints()
+		return nil
+	}
+	bp, err := c.breakpointArg(args)
+	if err != nil {
+		return err
+	}
+	if !c.debugger.RemoveBreakpoint(bp) {
+		return fmt.Errorf("no breakpoint at %v", bp)
+	}
+	return nil
+}
+
+// Parse the arguments of the watch command
+func parseWatchpoint(args []string) (gameboy.Watchpoint, error) {
+	wp := gameboy.Watchpoint{Kind: gameboy.WatchWrite, Action: gameboy.WatchBreak}
+	if len(args) > 0 {
+		kinds := map[string]gameboy.WatchKind{"r": gameboy.WatchRead, "w": gameboy.WatchWrite, "c": gameboy.WatchChange}
+		if kind, ok := kinds[args[0]]; ok {
+			wp.Kind = kind
+			args = args[1:]
+		}
+	}
+	if len(args) == 0 {
+		return wp, fmt.Errorf("expected \"watch [r|w|c] ADDR[-END] [=VAL] [log]\"")
+	}
+
+	var err error
+	if wp.Start, wp.End, err = gameboy.ParseAddressRange(args[0]); err != nil {
+		return wp, err
+	}
+	for _, arg := range args[1:] {
+		if arg == "log" {
+			wp.Action = gameboy.WatchLog
+		} else if strings.HasPrefix(arg, "=") {
+			value, err := parseHex(arg[1:], 8)
+			if err != nil {
+				return wp, err
+			}
+			wp.HasValue = true
+			wp.Value = uint8(value)
+		} else {
+			return wp, fmt.Errorf("unexpected watch argument %q", arg)
+		}
+	}
+	return wp, nil
+}
+
+func (c *debugConsole) deleteWatchpoint(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a watchpoint number from \"watchpoints\", or \"all\"")
+	}
+	if args[0] == "all" {
+		c.debugger.ClearWatchpoints()
+		return nil
+	}
+	watchpoints := c.debugger.Watchpoints()
+	index, err := strconv.Atoi(args[0])
+	if err != nil || index < 1 || index > len(watchpoints) {
+		return fmt.Errorf("no watchpoint %q", args[0])
+	}
+	c.debugger.RemoveWatchpoint(watchpoints[index-1])
+	return nil
+}
+
+func (c *debugConsole) printRegisters() {
+	r := c.debugger.Registers()
+	z, n, h, carry := r.Flags()
+	flags := []byte("----")
+	for i, set := range []bool{z, n, h, carry} {
+		if set {
+			flags[i] = "ZNHC"[i]
+		}
+	}
+	fmt.Fprintf(c.out, "A:%02X F:%02X [%s] B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X\n",
+		r.A, r.F, flags, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, r.PC)
+}
+
+func (c *debugConsole) set(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expected \"set REG VALUE\"")
+	}
+	switch strings.ToUpper(args[0]) {
+	case "Z", "N", "H", "C":
+		value, err := strconv.ParseBool(args[1])
+		if err != nil {
+			return fmt.Errorf("flag value must be 0 or 1")
+		}
+		if err := c.debugger.SetFlag(args[0], value); err != nil {
+			return err
+		}
+	default:
+		value, err := parseHex(args[1], 16)
+		if err != nil {
+			return err
+		}
+		if err := c.debugger.SetRegister(args[0], uint16(value)); err != nil {
+			return err
+		}
+	}
+	c.printRegisters()
+	return nil
+}
+
+func (c *debugConsole) printStack(args []string) error {
+	depth := 8
+	if len(args) > 0 {
+		var err error
+		if depth, err = strconv.Atoi(args[0]); err != nil || depth < 1 {
+			return fmt.Errorf("invalid stack depth %q", args[0])
+		}
+	}
+	sp := c.debugger.Registers().SP
+	for i, value := range c.debugger.Stack(depth) {
+		fmt.Fprintf(c.out, "%04X: %04X\n", sp+uint16(2*i), value)
+	}
+	return nil
+}
+
+func (c *debugConsole) printBacktrace() {
+	fmt.Fprintf(c.out, "#0 %v\n", c.debugger.CurrentLocation())
+	for i, frame := range c.debugger.CallStack() {
+		kind := "called"
+		if frame.Interrupt {
+			kind = "interrupted"
+		}
+		fmt.Fprintf(c.out, "#%d %v %s from %v\n", i+1, frame.Entry, kind, frame.Caller)
+	}
+}
+
+func (c *debugConsole) printMemory(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("expected \"x ADDR [N]\"")
+	}
+	address, err := parseHex(args[0], 16)
+	if err != nil {
+		return err
+	}
+	count := 16
+	if len(args) == 2 {
+		if count, err = strconv.Atoi(args[1]); err != nil || count < 1 {
+			return fmt.Errorf("invalid byte count %q", args[1])
+		}
+	}
+
+	for row := 0; row < count; row += 16 {
+		fmt.Fprintf(c.out, "%04X:", uint16(address)+uint16(row))
+		for i := row; i < count && i < row+16; i++ {
+			fmt.Fprintf(c.out, " %02X", c.console.ReadMemory(uint16(address)+uint16(i)))
+		}
+		fmt.Fprintln(c.out)
+	}
+	return nil
+}
+
+func (c *debugConsole) list(args []string) error {
+	if len(args) > 2 {
+		return fmt.Errorf("expected \"list [ADDR] [N]\"")
+	}
+	address := c.debugger.Registers().PC
+	if len(args) > 0 {
+		value, err := parseHex(args[0], 16)
+		if err != nil {
+			return err
+		}
+		address = uint16(value)
+	}
+	count := 10
+	if len(args) == 2 {
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil || count < 1 {
+			return fmt.Errorf("invalid instruction count %q", args[1])
+		}
+	}
+	c.printInstructions(address, count)
+	return nil
+}
+
+// Print disassembled instructions, marking the one at PC
+func (c *debugConsole) printInstructions(address uint16, count int) {
+	pc := c.debugger.Registers().PC
+	for _, inst := range c.debugger.Disassemble(address, count) {
+		marker := " "
+		if inst.Address == pc {
+			marker = ">"
+		}
+		fmt.Fprintf(c.out, "%s %04X  %-8X  %s\n", marker, inst.Address, inst.Bytes, inst)
+	}
+}
+
+func (c *debugConsole) writeTiles(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("expected \"tiles FILE [bgp|obp0|obp1]\"")
+	}
+	palette := gameboy.TilePaletteBGP
+	if len(args) == 2 {
+		var err error
+		if palette, err = gameboy.ParseTilePalette(args[1]); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	err = c.console.WriteTileSheetPNG(f, palette)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		fmt.Fprintf(c.out, "Wrote tiles to %s\n", args[0])
+	}
+	return err
+}
+
+func (c *debugConsole) writeTileMap(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("expected \"tilemap FILE [9800|9C00]\"")
+	}
+	var address uint16 = gameboy.TileMapAddressLow
+	if c.console.ReadMemory(gameboy.LCDC)&gameboy.LCDC_bg_map_select != 0 {
+		address = gameboy.TileMapAddressHigh
+	}
+	if len(args) == 2 {
+		var err error
+		if address, err = gameboy.ParseTileMapAddress(args[1]); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	err = c.console.WriteTileMapPNG(f, address, true)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		fmt.Fprintf(c.out, "Wrote tile map %04X to %s\n", address, args[0])
+	}
+	return err
+}
+
+func (c *debugConsole) printOAM(args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("expected \"oam [LINE]\"")
+	}
+	markers := make(map[int]string)
+	if len(args) == 1 {
+		line, err := strconv.Atoi(args[0])
+		if err != nil || line < 0 || line >= gameboy.ScreenHeight {
+			return fmt.Errorf("invalid screen line %q", args[0])
+		}
+		onLine := c.console.SpritesOnLine(line)
+		for _, index := range onLine.Drawn {
+			markers[index] = "*"
+		}
+		for _, index := range onLine.Dropped {
+			markers[index] = "!"
+		}
+		fmt.Fprintf(c.out, "Line %d: %d drawn, %d dropped\n", line, len(onLine.Drawn), len(onLine.Dropped))
+	}
+	for _, sprite := range c.console.Sprites() {
+		marker, ok := markers[sprite.Index]
+		if !ok {
+			marker = " "
+		}
+		fmt.Fprintf(c.out, "%s %v\n", marker, sprite)
+	}
+	return nil
+}
+
+func (c *debugConsole) printCheats() {
+	if !c.console.CheatsEnabled() {
+		fmt.Fprintln(c.out, "All cheats are off")
+	}
+	for i, cheat := range c.console.Cheats() {
+		fmt.Fprintf(c.out, "%d: %v\n", i+1, cheat)
+	}
+}
+
+func (c *debugConsole) cheat(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"cheat CODE [DESC]\", \"cheat on|off N|all\", \"cheat del N\" or \"cheat save\"")
+	}
+
+	// Find the cheat numbered by the second argument, as listed by "cheats"
+	index := func() (int, error) {
+		if len(args) != 2 {
+			return 0, fmt.Errorf("expected a cheat number from \"cheats\"")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > len(c.console.Cheats()) {
+			return 0, fmt.Errorf("no cheat %q", args[1])
+		}
+		return n - 1, nil
+	}
+
+	switch args[0] {
+	case "on", "off":
+		enabled := args[0] == "on"
+		if len(args) == 2 && args[1] == "all" {
+			c.console.SetCheatsEnabled(enabled)
+			return nil
+		}
+		i, err := index()
+		if err != nil {
+			return err
+		}
+		return c.console.EnableCheat(i, enabled)
+	case "del":
+		i, err := index()
+		if err != nil {
+			return err
+		}
+		return c.console.RemoveCheat(i)
+	case "save":
+		if err := writeCheatFile(c.console, c.cheatFile); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.out, "Wrote cheats to %s\n", c.cheatFile)
+		return nil
+	}
+
+	cheat, err := gameboy.ParseCheat(args[0])
+	if err != nil {
+		return err
+	}
+	cheat.Description = strings.Join(args[1:], " ")
+	c.console.AddCheat(cheat)
+	fmt.Fprintf(c.out, "%d: %v\n", len(c.console.Cheats()), cheat)
+	return nil
+}
+
+// Number of RAM search candidates listed by default
+const searchListLength = 20
+
+func (c *debugConsole) ramSearch(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected \"search start\", \"search OP [VALUE]\", \"search list\" or \"search freeze\"")
+	}
+	if args[0] == "start" {
+		bits, signed := 8, false
+		for _, arg := range args[1:] {
+			switch arg {
+			case "8", "16":
+				bits, _ = strconv.Atoi(arg)
+			case "signed":
+				signed = true
+			default:
+				return fmt.Errorf("unexpected search argument %q", arg)
+			}
+		}
+		search, err := c.console.StartRAMSearch(bits, signed)
+		if err != nil {
+			return err
+		}
+		c.search = search
+		fmt.Fprintf(c.out, "%d candidates\n", search.Count())
+		return nil
+	}
+	if c.search == nil {
+		return fmt.Errorf("no search running, use \"search start\"")
+	}
+
+	switch args[0] {
+	case "list":
+		limit := searchListLength
+		if len(args) > 1 {
+			var err error
+			if limit, err = strconv.Atoi(args[1]); err != nil || limit < 1 {
+				return fmt.Errorf("invalid candidate count %q", args[1])
+			}
+		}
+		for _, result := range c.search.Results(limit) {
+			fmt.Fprintf(c.out, "%04X: %d (was %d)\n", result.Address, result.Value, result.Previous)
+		}
+		if remaining := c.search.Count() - limit; remaining > 0 {
+			fmt.Fprintf(c.out, "... %d more\n", remaining)
+		}
+		return nil
+	case "freeze":
+		if len(args) < 2 {
+			return fmt.Errorf("expected \"search freeze ADDR [DESC]\"")
+		}
+		address, err := parseHex(args[1], 16)
+		if err != nil {
+			return err
+		}
+		cheats, err := c.search.FreezeCheats(uint16(address), strings.Join(args[2:], " "))
+		if err != nil {
+			return err
+		}
+		for _, cheat := range cheats {
+			c.console.AddCheat(cheat)
+			fmt.Fprintf(c.out, "%d: %v\n", len(c.console.Cheats()), cheat)
+		}
+		return nil
+	}
+
+	comparison, err := gameboy.ParseSearchComparison(args[0])
+	if err != nil {
+		return err
+	}
+	var count int
+	switch len(args) {
+	case 1:
+		count = c.search.FilterPrevious(comparison)
+	case 2:
+		value, err := gameboy.ParseSearchValue(args[1])
+		if err != nil {
+			return err
+		}
+		count = c.search.FilterValue(comparison, value)
+	default:
+		return fmt.Errorf("expected \"search OP [VALUE]\"")
+	}
+	fmt.Fprintf(c.out, "%d candidates\n", count)
+	if count > 0 && count <= searchListLength {
+		return c.ramSearch([]string{"list"})
+	}
+	return nil
+}
+
+// Parse a hex value, with or without a $ or 0x prefix
+func parseHex(text string, bits int) (uint64, error) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(text, "$"), "0x"), 16, bits)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hex value %q", text)
+	}
+	return value, nil
+}
@@ -0,0 +1,76 @@
+package gameboy
+
+// Maximum number of calls tracked, the oldest are dropped beyond this
+const maxCallStackDepth = 1024
+
+// CallFrame is a subroutine call or interrupt which has not yet returned
+type CallFrame struct {
+	// Location of the CALL or RST instruction, for interrupts the instruction which was about to run
+	Caller Breakpoint
+	// First instruction of the subroutine or interrupt handler
+	Entry Breakpoint
+	// Stack pointer after the return address was pushed
+	SP uint16
+	// Set for interrupt handlers
+	Interrupt bool
+}
+
+// Called at each instruction boundary to follow calls and returns, comparing the CPU state with the previous boundary.
+// A call is recorded when a CALL, RST or interrupt pushes a return address and jumps somewhere other than the next
+// instruction. Calls are forgotten once the stack pointer rises above their return address, which covers RET, RETI
+// and code which discards return addresses by resetting the stack
+func (d *Debugger) trackCalls() {
+	gb := d.gb
+	pc, sp := gb.cpu.PC, gb.cpu.SP
+	if d.callTrackValid && pc == d.callTrackPC && sp == d.callTrackSP {
+		// Nothing has run since the last boundary
+		return
+	}
+
+	for len(d.calls) > 0 && d.calls[len(d.calls)-1].SP < sp {
+		d.calls = d.calls[:len(d.calls)-1]
+	}
+	if d.callTrackValid && sp == d.callTrackSP-2 && pc != d.callTrackNext {
+		frame := CallFrame{
+			Caller:    d.callTrackLocation,
+			Entry:     Breakpoint{Bank: gb.romBank(pc), Address: pc},
+			SP:        sp,
+			Interrupt: !d.callTrackIsCall || pc != d.callTrackTarget,
+		}
+		if frame.Interrupt {
+			// The return address is the instruction which the interrupt ran before
+			returnAddress := uint16(gb.memory.read(sp+1))<<8 | uint16(gb.memory.read(sp))
+			frame.Caller = Breakpoint{Bank: gb.romBank(returnAddress), Address: returnAddress}
+		}
+		if len(d.calls) == maxCallStackDepth {
+			d.calls = append(d.calls[:0], d.calls[1:]...)
+		}
+		d.calls = append(d.calls, frame)
+	}
+
+	inst := d.instructionAt(pc)
+	d.callTrackValid = true
+	d.callTrackPC = pc
+	d.callTrackSP = sp
+	d.callTrackNext = pc + uint16(inst.Length())
+	d.callTrackIsCall = inst.IsCall()
+	d.callTrackTarget = inst.Target
+	if inst.IsCall() && !inst.HasTarget {
+		// RST n encodes its target in bits 3-5
+		d.callTrackTarget = uint16(inst.Bytes[0] & 0x38)
+	}
+	d.callTrackLocation = Breakpoint{Bank: gb.romBank(pc), Address: pc}
+}
+
+// CallStack returns the calls which have not yet returned, the most recent first.
+// Only calls made while the debugger is attached are known
+func (d *Debugger) CallStack() []CallFrame {
+	if !d.gb.halted {
+		d.trackCalls()
+	}
+	frames := make([]CallFrame, len(d.calls))
+	for i, frame := range d.calls {
+		frames[len(frames)-1-i] = frame
+	}
+	return frames
+}
@@ -0,0 +1,245 @@
+package gameboy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+Cheat Codes
+
+Game Genie codes patch bytes read from cartridge ROM, written ABC-DEF-GHI (or ABC-DEF without a compare byte)
+with each letter a hex digit:
+  AB        new value
+  FCDE      address, with F inverted (XOR 0xF), always in 0000-7FFF
+  GI        compare byte, inverted, rotated right 2 bits and XORed with 0x45
+            the value is only replaced when the ROM holds the compare byte, which limits the patch to one ROM bank
+  H         not used
+
+GameShark codes write a value to RAM at the start of every frame, written TTVVLLHH:
+  TT        code type, only 01 (write) is supported
+  VV        value
+  HHLL      address, in VRAM, cartridge RAM, WRAM, OAM or HRAM
+
+Cheat File
+
+Cheats for a ROM are stored in a text file, one per line: "on" or "off", the code, then an optional description.
+Blank lines and lines starting with # are ignored
+
+on  01FF57C1 Infinite lives
+off 00A-17B-C49 Start on level 5
+*/
+
+// CheatKind identifies the device a cheat code is for
+type CheatKind int
+
+const (
+	CheatGameGenie CheatKind = iota
+	CheatGameShark
+)
+
+func (k CheatKind) String() string {
+	switch k {
+	case CheatGameGenie:
+		return "Game Genie"
+	case CheatGameShark:
+		return "GameShark"
+	}
+	return fmt.Sprintf("CheatKind(%d)", int(k))
+}
+
+// Cheat is a decoded cheat code
+type Cheat struct {
+	// Code as entered, in upper case
+	Code        string
+	Description string
+	Enabled     bool
+
+	Kind    CheatKind
+	Address uint16
+	Value   uint8
+	// Game Genie codes with a compare byte only patch the address when the ROM holds Compare
+	HasCompare bool
+	Compare    uint8
+}
+
+func (c Cheat) String() string {
+	state := "off"
+	if c.Enabled {
+		state = "on"
+	}
+	text := fmt.Sprintf("%-3s %s", state, c.Code)
+	if c.Description != "" {
+		text += " " + c.Description
+	}
+	return text
+}
+
+// ParseCheat decodes a Game Genie (ABC-DEF-GHI or ABC-DEF) or GameShark (01VVLLHH) code.
+// The cheat returned is enabled
+func ParseCheat(code string) (Cheat, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	cheat := Cheat{Code: code, Enabled: true}
+	digits := strings.ReplaceAll(code, "-", "")
+	value, err := strconv.ParseUint(digits, 16, 64)
+	if err != nil {
+		return cheat, fmt.Errorf("invalid cheat code %q, expected ABC-DEF-GHI, ABC-DEF or 01VVLLHH", code)
+	}
+
+	switch {
+	case len(code) == 11 && code[3] == '-' && code[7] == '-', len(code) == 7 && code[3] == '-':
+		cheat.Kind = CheatGameGenie
+		// Digit i of the code, counting from A as 0
+		nibble := func(i int) uint8 {
+			return uint8(value>>(4*(len(digits)-1-i))) & 0xF
+		}
+		cheat.Value = nibble(0)<<4 | nibble(1)
+		cheat.Address = uint16(nibble(5)^0xF)<<12 | uint16(nibble(2))<<8 | uint16(nibble(3))<<4 | uint16(nibble(4))
+		if len(digits) == 9 {
+			cheat.HasCompare = true
+			compare := ^(nibble(6)<<4 | nibble(8))
+			cheat.Compare = (compare>>2 | compare<<6) ^ 0x45
+		}
+		if cheat.Address >= 0x8000 {
+			return cheat, fmt.Errorf("Game Genie code %q patches %04X, which is outside of ROM", code, cheat.Address)
+		}
+	case len(code) == 8 && !strings.Contains(code, "-"):
+		cheat.Kind = CheatGameShark
+		if codeType := uint8(value >> 24); codeType != 0x01 {
+			return cheat, fmt.Errorf("GameShark code %q has unsupported type %02X", code, codeType)
+		}
+		cheat.Value = uint8(value >> 16)
+		cheat.Address = uint16(value&0xFF)<<8 | uint16(value>>8&0xFF)
+		if cheat.Address < 0x8000 {
+			return cheat, fmt.Errorf("GameShark code %q writes to %04X, which is in ROM", code, cheat.Address)
+		}
+	default:
+		return cheat, fmt.Errorf("invalid cheat code %q, expected ABC-DEF-GHI, ABC-DEF or 01VVLLHH", code)
+	}
+	return cheat, nil
+}
+
+// LoadCheats reads cheats from a cheat file
+func LoadCheats(r io.Reader) ([]Cheat, error) {
+	var cheats []Cheat
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		state := fields[0]
+		if state != "on" && state != "off" || len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"on\" or \"off\" followed by a code", lineNumber)
+		}
+		code, description, _ := strings.Cut(strings.TrimSpace(fields[1]), " ")
+		cheat, err := ParseCheat(code)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		cheat.Enabled = state == "on"
+		cheat.Description = strings.TrimSpace(description)
+		cheats = append(cheats, cheat)
+	}
+	return cheats, scanner.Err()
+}
+
+// SaveCheats writes cheats in the cheat file format
+func SaveCheats(w io.Writer, cheats []Cheat) error {
+	for _, cheat := range cheats {
+		if _, err := fmt.Fprintln(w, cheat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddCheat adds a cheat to the end of the list
+func (gb *Gameboy) AddCheat(cheat Cheat) {
+	gb.cheats = append(gb.cheats, cheat)
+	gb.updateROMPatches()
+}
+
+// RemoveCheat removes the cheat at an index in the list
+func (gb *Gameboy) RemoveCheat(index int) error {
+	if index < 0 || index >= len(gb.cheats) {
+		return fmt.Errorf("no cheat %d", index)
+	}
+	gb.cheats = append(gb.cheats[:index], gb.cheats[index+1:]...)
+	gb.updateROMPatches()
+	return nil
+}
+
+// SetCheats replaces every cheat
+func (gb *Gameboy) SetCheats(cheats []Cheat) {
+	gb.cheats = append([]Cheat{}, cheats...)
+	gb.updateROMPatches()
+}
+
+// Cheats returns every cheat in the order they were added
+func (gb *Gameboy) Cheats() []Cheat {
+	return append([]Cheat{}, gb.cheats...)
+}
+
+// EnableCheat turns the cheat at an index in the list on or off
+func (gb *Gameboy) EnableCheat(index int, enabled bool) error {
+	if index < 0 || index >= len(gb.cheats) {
+		return fmt.Errorf("no cheat %d", index)
+	}
+	gb.cheats[index].Enabled = enabled
+	gb.updateROMPatches()
+	return nil
+}
+
+// SetCheatsEnabled turns all cheats on or off at once, without changing whether each cheat is enabled
+func (gb *Gameboy) SetCheatsEnabled(enabled bool) {
+	gb.cheatsDisabled = !enabled
+	gb.updateROMPatches()
+}
+
+// CheatsEnabled returns false while all cheats are turned off by SetCheatsEnabled
+func (gb *Gameboy) CheatsEnabled() bool {
+	return !gb.cheatsDisabled
+}
+
+// Rebuild the Game Genie patches checked by ROM reads
+func (gb *Gameboy) updateROMPatches() {
+	gb.memory.romPatches = nil
+	if gb.cheatsDisabled {
+		return
+	}
+	for _, cheat := range gb.cheats {
+		if cheat.Enabled && cheat.Kind == CheatGameGenie {
+			if gb.memory.romPatches == nil {
+				gb.memory.romPatches = make(map[uint16][]Cheat)
+			}
+			gb.memory.romPatches[cheat.Address] = append(gb.memory.romPatches[cheat.Address], cheat)
+		}
+	}
+}
+
+// Replace a value read from ROM if a Game Genie code patches it
+func (m *Memory) patchROM(address uint16, value uint8) uint8 {
+	for _, cheat := range m.romPatches[address] {
+		if !cheat.HasCompare || cheat.Compare == value {
+			return cheat.Value
+		}
+	}
+	return value
+}
+
+// Apply GameShark codes, called at the start of each frame
+func (gb *Gameboy) applyRAMCheats() {
+	if gb.cheatsDisabled {
+		return
+	}
+	for _, cheat := range gb.cheats {
+		if cheat.Enabled && cheat.Kind == CheatGameShark {
+			gb.memory.write(cheat.Address, cheat.Value)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package gameboy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseCheat(t *testing.T) {
+	testcases := map[string]Cheat{
+		"3CA-10B-6EA": {
+			Code: "3CA-10B-6EA", Enabled: true, Kind: CheatGameGenie, Address: 0x4A10, Value: 0x3C,
+			HasCompare: true, Compare: 0x20,
+		},
+		"3d1-50f-1ea": {
+			Code: "3D1-50F-1EA", Enabled: true, Kind: CheatGameGenie, Address: 0x0150, Value: 0x3D,
+			HasCompare: true, Compare: 0x3C,
+		},
+		"00A-17B":  {Code: "00A-17B", Enabled: true, Kind: CheatGameGenie, Address: 0x4A17, Value: 0x00},
+		"01FF57C1": {Code: "01FF57C1", Enabled: true, Kind: CheatGameShark, Address: 0xC157, Value: 0xFF},
+	}
+	for code, expected := range testcases {
+		cheat, err := ParseCheat(code)
+		if err != nil {
+			t.Errorf("Unable to parse %q: %v", code, err)
+		} else if cheat != expected {
+			t.Errorf("ParseCheat(%q) = %+v, expected %+v", code, cheat, expected)
+		}
+	}
+
+	for _, code := range []string{"", "3CA-10B-6E", "3CA10B6EA", "01FF57C", "91FF57C1", "01FF0040", "3CA-107-6EA", "XYZ-10B-6EA"} {
+		if _, err := ParseCheat(code); err == nil {
+			t.Errorf("Expected an error parsing %q", code)
+		}
+	}
+}
+
+func TestCheatFile(t *testing.T) {
+	file := `# Cheats for a test
+on  01FF57C1 Infinite lives
+off 3CA-10B-6EA
+
+on 00A-17B Start on level 5
+`
+	cheats, err := LoadCheats(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("Unable to load cheats: %v", err)
+	}
+	if len(cheats) != 3 || !cheats[0].Enabled || cheats[0].Description != "Infinite lives" || cheats[1].Enabled ||
+		cheats[1].Description != "" || cheats[2].Description != "Start on level 5" {
+		t.Fatalf("Unexpected cheats loaded: %+v", cheats)
+	}
+
+	var buf bytes.Buffer
+	if err := SaveCheats(&buf, cheats); err != nil {
+		t.Fatalf("Unable to save cheats: %v", err)
+	}
+	expected := "on  01FF57C1 Infinite lives\noff 3CA-10B-6EA\non  00A-17B Start on level 5\n"
+	if buf.String() != expected {
+		t.Errorf("Saved cheats as %q, expected %q", buf.String(), expected)
+	}
+
+	for _, bad := range []string{"01FF57C1\n", "maybe 01FF57C1\n", "on 01FF57\n"} {
+		if _, err := LoadCheats(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected an error loading %q", bad)
+		}
+	}
+}
+
+func TestGameGenie(t *testing.T) {
+	gb := newTestGameBoy("CHEATS", counterProgram)
+	// Turn INC A into DEC A, only while the ROM holds INC A
+	patch, _ := ParseCheat("3D1-50F-1EA")
+	gb.AddCheat(patch)
+	if value := gb.ReadMemory(0x0150); value != 0x3D {
+		t.Fatalf("Patched ROM reads %02X, expected 3D", value)
+	}
+	if value := gb.ReadMemory(0x0151); value != 0xEA {
+		t.Errorf("Unpatched ROM reads %02X, expected EA", value)
+	}
+	// NOP; JP 0150; DEC A; LD (C000),A
+	for i := 0; i < 4; i++ {
+		gb.RunNextOpcode()
+	}
+	if value := gb.ReadMemory(0xC000); value != 0x00 {
+		t.Errorf("Counter is %02X, expected it to have counted down from 1 to 0", value)
+	}
+
+	gb.SetCheatsEnabled(false)
+	if value := gb.ReadMemory(0x0150); value != 0x3C {
+		t.Errorf("ROM reads %02X with cheats off, expected 3C", value)
+	}
+	gb.SetCheatsEnabled(true)
+	if err := gb.EnableCheat(0, false); err != nil {
+		t.Fatal(err)
+	}
+	if value := gb.ReadMemory(0x0150); value != 0x3C {
+		t.Errorf("ROM reads %02X with the cheat off, expected 3C", value)
+	}
+
+	// A compare byte which does not match leaves the ROM alone
+	mismatch, _ := ParseCheat("3D1-50F-6EA")
+	gb.SetCheats([]Cheat{mismatch})
+	if value := gb.ReadMemory(0x0150); value != 0x3C {
+		t.Errorf("ROM reads %02X with a mismatched compare byte, expected 3C", value)
+	}
+}
+
+func TestGameShark(t *testing.T) {
+	gb := newTestGameBoy("CHEATS", counterProgram)
+	freeze, _ := ParseCheat("014200C1")
+	gb.AddCheat(freeze)
+
+	gb.RunNextFrame()
+	if value := gb.ReadMemory(0xC100); value != 0x42 {
+		t.Fatalf("Frozen address reads %02X, expected 42", value)
+	}
+	gb.memory.write(0xC100, 0x00)
+	gb.RunNextFrame()
+	if value := gb.ReadMemory(0xC100); value != 0x42 {
+		t.Errorf("Frozen address reads %02X after the next frame, expected 42", value)
+	}
+
+	if err := gb.RemoveCheat(0); err != nil {
+		t.Fatal(err)
+	}
+	gb.memory.write(0xC100, 0x00)
+	gb.RunNextFrame()
+	if value := gb.ReadMemory(0xC100); value != 0x00 {
+		t.Errorf("Address reads %02X after removing the cheat, expected 00", value)
+	}
+	if err := gb.RemoveCheat(0); err == nil {
+		t.Error("Expected an error removing a cheat which does not exist")
+	}
+}
@@ -0,0 +1,389 @@
+package gameboy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/gameboy/disasm"
+)
+
+// Bank value for breakpoints which apply no matter which ROM bank is mapped in
+const AnyBank = -1
+
+// Breakpoint identifies an instruction address, optionally within a specific ROM bank
+type Breakpoint struct {
+	// ROM bank the address must be mapped from, or AnyBank
+	// Only used for ROM addresses, 0000-3FFF is bank 0 unless the cartridge can switch it as multicarts do
+	Bank    int
+	Address uint16
+}
+
+// ParseBreakpoint reads a breakpoint written as a hex address, optionally preceded by a hex ROM bank (03:4A10)
+func ParseBreakpoint(text string) (Breakpoint, error) {
+	bp := Breakpoint{Bank: AnyBank}
+	address := text
+	if bank, rest, found := strings.Cut(text, ":"); found {
+		value, err := strconv.ParseUint(bank, 16, 16)
+		if err != nil {
+			return bp, fmt.Errorf("invalid bank in breakpoint %q", text)
+		}
+		bp.Bank = int(value)
+		address = rest
+	}
+
+	address = strings.TrimPrefix(strings.TrimPrefix(address, "$"), "0x")
+	value, err := strconv.ParseUint(address, 16, 16)
+	if err != nil {
+		return bp, fmt.Errorf("invalid address in breakpoint %q", text)
+	}
+	bp.Address = uint16(value)
+	return bp, nil
+}
+
+func (bp Breakpoint) String() string {
+	if bp.Bank == AnyBank {
+		return fmt.Sprintf("%04X", bp.Address)
+	}
+	return fmt.Sprintf("%02X:%04X", bp.Bank, bp.Address)
+}
+
+// StopReason describes why the debugger stopped execution
+type StopReason string
+
+const (
+	StopPause      StopReason = "pause"
+	StopBreakpoint StopReason = "breakpoint"
+	StopStep       StopReason = "step"
+	StopWatchpoint StopReason = "watchpoint"
+)
+
+// Debugger controls execution of the console one instruction at a time.
+// All methods must be called from the goroutine running the console
+type Debugger struct {
+	gb *Gameboy
+
+	// Execution is stopped, RunNextFrame will not run anything until resumed
+	paused bool
+	// Skip breakpoints for the first instruction after resuming, so we do not stop where we already are
+	resuming bool
+	// Called whenever execution stops
+	onStop func(reason StopReason)
+
+	breakpoints map[Breakpoint]bool
+	// Temporary breakpoint set by RunTo, cleared the next time execution stops
+	runTo *Breakpoint
+
+	// Step over a call: stop when returning to this address with the stack pointer back at or above stepOverSP
+	stepOver   bool
+	stepOverPC uint16
+	stepOverSP uint16
+	// Step out: stop after a return instruction takes the stack pointer above stepOutSP
+	stepOut   bool
+	stepOutSP uint16
+
+	// Address of the instruction run last and whether it was a return, for detecting returns
+	lastPC     uint16
+	lastReturn bool
+
+	watchpoints []Watchpoint
+	// Called for each access triggering a WatchLog watchpoint
+	onWatch func(hit WatchHit)
+	// Location of the instruction currently being run, for reporting watchpoint hits
+	watchLocation Breakpoint
+	// A WatchBreak watchpoint was triggered by the last instruction, stop before the next
+	watchStop    bool
+	lastWatchHit WatchHit
+
+	// Calls which have not yet returned, oldest first
+	calls []CallFrame
+	// CPU state at the last instruction boundary seen by trackCalls, once callTrackValid is set
+	callTrackValid    bool
+	callTrackPC       uint16
+	callTrackSP       uint16
+	callTrackNext     uint16
+	callTrackIsCall   bool
+	callTrackTarget   uint16
+	callTrackLocation Breakpoint
+}
+
+// AttachDebugger returns the console's debugger, creating it if it is not already attached.
+// The console keeps running until the debugger is paused or hits a breakpoint
+func (gb *Gameboy) AttachDebugger() *Debugger {
+	if gb.debugger == nil {
+		gb.debugger = &Debugger{
+			gb:          gb,
+			onStop:      func(StopReason) {},
+			onWatch:     logWatchHit,
+			breakpoints: make(map[Breakpoint]bool),
+		}
+	}
+	return gb.debugger
+}
+
+// DetachDebugger removes the debugger, clearing all breakpoints and watchpoints and resuming execution
+func (gb *Gameboy) DetachDebugger() {
+	gb.debugger = nil
+}
+
+// DebuggerPaused returns whether an attached debugger has stopped execution
+func (gb *Gameboy) DebuggerPaused() bool {
+	return gb.debugger != nil && gb.debugger.paused
+}
+
+// SetStopHandler sets a function to be called each time execution stops
+func (d *Debugger) SetStopHandler(handler func(reason StopReason)) {
+	if handler == nil {
+		handler = func(StopReason) {}
+	}
+	d.onStop = handler
+}
+
+// Paused returns whether execution is currently stopped
+func (d *Debugger) Paused() bool {
+	return d.paused
+}
+
+// Stop execution before the next instruction
+func (d *Debugger) stop(reason StopReason) {
+	if d.watchStop {
+		// A watchpoint triggered on the way to stopping for some other reason, report it instead
+		reason = StopWatchpoint
+		d.watchStop = false
+	}
+	d.paused = true
+	d.runTo = nil
+	d.stepOver = false
+	d.stepOut = false
+	d.onStop(reason)
+}
+
+// Pause stops execution before the next instruction
+func (d *Debugger) Pause() {
+	d.stop(StopPause)
+}
+
+// Continue resumes execution until the next breakpoint or pause
+func (d *Debugger) Continue() {
+	d.paused = false
+	d.resuming = true
+}
+
+// AddBreakpoint stops execution before running the instruction at the breakpoint
+func (d *Debugger) AddBreakpoint(bp Breakpoint) {
+	d.breakpoints[bp] = true
+}
+
+// RemoveBreakpoint removes a breakpoint, returning false if it was not set
+func (d *Debugger) RemoveBreakpoint(bp Breakpoint) bool {
+	if !d.breakpoints[bp] {
+		return false
+	}
+	delete(d.breakpoints, bp)
+	return true
+}
+
+// ClearBreakpoints removes all breakpoints
+func (d *Debugger) ClearBreakpoints() {
+	d.breakpoints = make(map[Breakpoint]bool)
+}
+
+// Breakpoints returns every breakpoint currently set
+func (d *Debugger) Breakpoints() []Breakpoint {
+	breakpoints := make([]Breakpoint, 0, len(d.breakpoints))
+	for bp := range d.breakpoints {
+		breakpoints = append(breakpoints, bp)
+	}
+	return breakpoints
+}
+
+// RunTo resumes execution until the given address is reached, or something else stops execution first
+func (d *Debugger) RunTo(bp Breakpoint) {
+	d.Continue()
+	d.runTo = &bp
+}
+
+// StepInto runs a single instruction and stops again.
+// If the CPU is halted it runs until the CPU wakes up, or for at most one frame
+func (d *Debugger) StepInto() {
+	gb := d.gb
+	for cycles := 0; cycles < CyclesPerFrame; cycles += 4 {
+		if !gb.halted {
+			d.trackCalls()
+		}
+		gb.stepInstruction()
+		if !gb.halted {
+			break
+		}
+	}
+	d.stop(StopStep)
+}
+
+// StepOver runs a single instruction, or an entire subroutine if the instruction is a CALL or RST
+func (d *Debugger) StepOver() {
+	gb := d.gb
+	inst := d.instructionAt(gb.cpu.PC)
+	if !inst.IsCall() || gb.halted {
+		d.StepInto()
+		return
+	}
+
+	d.Continue()
+	d.stepOver = true
+	d.stepOverPC = gb.cpu.PC + uint16(inst.Length())
+	d.stepOverSP = gb.cpu.SP
+}
+
+// StepOut runs until the current subroutine returns
+func (d *Debugger) StepOut() {
+	d.Continue()
+	d.stepOut = true
+	d.stepOutSP = d.gb.cpu.SP
+}
+
+// Decode the instruction at an address as currently mapped
+func (d *Debugger) instructionAt(address uint16) disasm.Instruction {
+	var data [3]uint8
+	for i := range data {
+		data[i] = d.gb.memory.read(address + uint16(i))
+	}
+	return disasm.Decode(data[:], address)
+}
+
+// Disassemble decodes count instructions starting at address, reading memory as currently mapped
+func (d *Debugger) Disassemble(address uint16, count int) []disasm.Instruction {
+	instructions := make([]disasm.Instruction, 0, count)
+	for i := 0; i < count; i++ {
+		inst := d.instructionAt(address)
+		instructions = append(instructions, inst)
+		address += uint16(inst.Length())
+	}
+	return instructions
+}
+
+// Run a single instruction outside of RunNextFrame, completing the frame if it reaches the end
+func (gb *Gameboy) stepInstruction() {
+	if !gb.frameRunning {
+		gb.startFrame()
+	}
+	gb.step()
+	if gb.frameCycles >= CyclesPerFrame {
+		gb.finishFrame()
+	}
+}
+
+// Called before each instruction while running, returns whether execution should stop
+func (d *Debugger) shouldStop() bool {
+	gb := d.gb
+	if d.watchStop {
+		d.stop(StopWatchpoint)
+		return true
+	}
+	if gb.halted {
+		// There is no instruction boundary until the CPU wakes up
+		return false
+	}
+	d.trackCalls()
+	pc := gb.cpu.PC
+
+	returned := d.lastReturn && pc != d.lastPC+1
+	d.lastPC = pc
+	// Decoding is only needed for stepping out, skip it otherwise as this runs before every instruction
+	d.lastReturn = d.stepOut && d.instructionAt(pc).IsReturn()
+
+	if d.resuming {
+		d.resuming = false
+		return false
+	}
+
+	if d.stepOver && pc == d.stepOverPC && gb.cpu.SP >= d.stepOverSP {
+		d.stop(StopStep)
+		return true
+	}
+	if d.stepOut && returned && gb.cpu.SP > d.stepOutSP {
+		d.stop(StopStep)
+		return true
+	}
+	if d.runTo != nil && d.runTo.matches(gb, pc) {
+		d.stop(StopBreakpoint)
+		return true
+	}
+	if len(d.breakpoints) > 0 {
+		if d.breakpoints[Breakpoint{Bank: AnyBank, Address: pc}] || d.breakpoints[Breakpoint{Bank: gb.romBank(pc), Address: pc}] {
+			d.stop(StopBreakpoint)
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the breakpoint refers to the given address as currently mapped
+func (bp Breakpoint) matches(gb *Gameboy, address uint16) bool {
+	return bp.Address == address && (bp.Bank == AnyBank || bp.Bank == gb.romBank(address))
+}
+
+// ROM bank an address is currently mapped from, AnyBank for addresses outside of ROM
+func (gb *Gameboy) romBank(address uint16) int {
+	if address < cartridges.ROMBankSize {
+		return int(gb.memory.cartridge.LowROMBank())
+	}
+	if address < cartridges.ROMEndAddress {
+		return int(gb.memory.cartridge.ROMBank())
+	}
+	return AnyBank
+}
+
+// CurrentLocation returns the address of the next instruction along with the ROM bank it is mapped from
+func (d *Debugger) CurrentLocation() Breakpoint {
+	return Breakpoint{Bank: d.gb.romBank(d.gb.cpu.PC), Address: d.gb.cpu.PC}
+}
+
+// Registers returns a copy of the CPU registers
+func (d *Debugger) Registers() CpuRegisters {
+	return *d.gb.cpu
+}
+
+// SetRegister sets an 8 or 16 bit register by name (A, F, B, C, D, E, H, L, AF, BC, DE, HL, SP or PC)
+func (d *Debugger) SetRegister(name string, value uint16) error {
+	name = strings.ToUpper(name)
+	switch name {
+	case "A", "F", "B", "C", "D", "E", "H", "L":
+		if value > 0xFF {
+			return fmt.Errorf("value %X is too large for 8 bit register %s", value, name)
+		}
+		return d.gb.cpu.setRegister(register8Name(name), uint8(value))
+	case "AF", "BC", "DE", "HL", "SP", "PC":
+		return d.gb.cpu.setRegister16(register16Name(name), value)
+	}
+	return fmt.Errorf("unknown register %q", name)
+}
+
+// Flag names and their bit in the F register
+var flagNames = map[string]uint8{"Z": FlagZ, "N": FlagN, "H": FlagH, "C": FlagC}
+
+// SetFlag sets or clears a CPU flag by name (Z, N, H or C)
+func (d *Debugger) SetFlag(name string, value bool) error {
+	flag, ok := flagNames[strings.ToUpper(name)]
+	if !ok {
+		return fmt.Errorf("unknown flag %q", name)
+	}
+	d.gb.cpu.set_flag(flag, value)
+	return nil
+}
+
+// Flags returns the CPU flags in the order Z, N, H, C
+func (r CpuRegisters) Flags() (z, n, h, c bool) {
+	return r.getFlag(FlagZ), r.getFlag(FlagN), r.getFlag(FlagH), r.getFlag(FlagC)
+}
+
+// Stack returns up to depth 16 bit values from the top of the stack, starting at SP
+func (d *Debugger) Stack(depth int) []uint16 {
+	values := make([]uint16, 0, depth)
+	for address := int(d.gb.cpu.SP); len(values) < depth && address < 0xFFFF; address += 2 {
+		low := uint16(d.gb.memory.read(uint16(address)))
+		high := uint16(d.gb.memory.read(uint16(address + 1)))
+		values = append(values, high<<8|low)
+	}
+	return values
+}
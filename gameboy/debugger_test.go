@@ -0,0 +1,294 @@
+package gameboy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cbott/GoEmulate/cartridges"
+)
+
+// Program which calls a subroutine in ROM bank 2 forever, bank 1 holds a different subroutine
+var bankedCallProgram = map[int][]uint8{
+	0x0150: {
+		0x3E, 0x02, // LD A,2
+		0xEA, 0x00, 0x20, // LD (2000),A
+		0xCD, 0x00, 0x40, // loop: CALL 4000
+		0x18, 0xFB, // JR loop
+	},
+	// Bank 1
+	0x4000: {
+		0x05, // DEC B
+		0xC9, // RET
+	},
+	// Bank 2
+	0x8000: {
+		0x04, // INC B
+		0xC5, // PUSH BC
+		0xC1, // POP BC
+		0xC9, // RET
+	},
+}
+
+// Create a Game Boy with a 64KiB MBC1 cartridge running bankedCallProgram
+func newBankedGameBoy() *Gameboy {
+	rom := make([]uint8, 4*cartridges.ROMBankSize)
+	copy(rom, makeTestROM("BANKED", nil))
+	for offset, code := range bankedCallProgram {
+		copy(rom[offset:], code)
+	}
+	rom[cartridges.CartridgeTypeAddress] = 0x01
+	rom[cartridges.ROMSizeAddress] = 0x01
+
+	gb := NewGameBoy(true, false)
+	gb.LoadCartridge(cartridges.NewMBC1Cartridge("", rom))
+	return gb
+}
+
+func mustParseBreakpoint(t *testing.T, text string) Breakpoint {
+	bp, err := ParseBreakpoint(text)
+	if err != nil {
+		t.Fatalf("Unable to parse breakpoint: %v", err)
+	}
+	return bp
+}
+
+func TestParseBreakpoint(t *testing.T) {
+	testcases := map[string]Breakpoint{
+		"4A10":    {Bank: AnyBank, Address: 0x4A10},
+		"$0150":   {Bank: AnyBank, Address: 0x0150},
+		"03:4A10": {Bank: 3, Address: 0x4A10},
+	}
+	for text, expected := range testcases {
+		if bp := mustParseBreakpoint(t, text); bp != expected {
+			t.Errorf("%s: expected %v, got %v", text, expected, bp)
+		}
+	}
+	for _, text := range []string{"", "xyz", "03:", "10000"} {
+		if _, err := ParseBreakpoint(text); err == nil {
+			t.Errorf("Expected %q to be rejected", text)
+		}
+	}
+}
+
+func TestBreakpointStopsMidFrame(t *testing.T) {
+	gb := newTestGameBoy("COUNTER", counterProgram)
+	debugger := gb.AttachDebugger()
+	var stops []StopReason
+	debugger.SetStopHandler(func(reason StopReason) { stops = append(stops, reason) })
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "0154"))
+
+	gb.RunNextFrame()
+	if !debugger.Paused() || gb.cpu.PC != 0x0154 {
+		t.Fatalf("Expected to stop at 0154, stopped at %04X (paused %v)", gb.cpu.PC, debugger.Paused())
+	}
+	if !gb.frameRunning || gb.frameCycles >= CyclesPerFrame {
+		t.Fatalf("Expected to stop partway through the frame")
+	}
+	a := gb.cpu.A
+
+	// Nothing runs while paused
+	gb.RunNextFrame()
+	if gb.cpu.PC != 0x0154 || gb.cpu.A != a {
+		t.Fatalf("Expected console not to run while paused")
+	}
+
+	// Continuing runs one loop until the breakpoint is hit again
+	debugger.Continue()
+	gb.RunNextFrame()
+	if !debugger.Paused() || gb.cpu.PC != 0x0154 || gb.cpu.A != a+1 {
+		t.Fatalf("Expected to stop at 0154 after one loop, stopped at %04X with A=%02X", gb.cpu.PC, gb.cpu.A)
+	}
+	if len(stops) != 2 || stops[0] != StopBreakpoint {
+		t.Fatalf("Expected two breakpoint stops, got %v", stops)
+	}
+}
+
+func TestBreakpointBank(t *testing.T) {
+	gb := newBankedGameBoy()
+	debugger := gb.AttachDebugger()
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "01:4000"))
+	gb.RunNextFrame()
+	if debugger.Paused() {
+		t.Fatalf("Expected breakpoint in bank 1 not to be hit")
+	}
+
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "02:4000"))
+	gb.RunNextFrame()
+	if !debugger.Paused() || debugger.CurrentLocation() != (Breakpoint{Bank: 2, Address: 0x4000}) {
+		t.Fatalf("Expected to stop at 02:4000, stopped at %v", debugger.CurrentLocation())
+	}
+}
+
+// Create a Game Boy with a 1MiB MBC1 multicart, whose menu in bank 0 switches 0000-3FFF to the second game
+// and carries on running from bank 10 at 015A
+func newMulticartGameBoy() *Gameboy {
+	rom := make([]uint8, 4*cartridges.MBC1MGameBanks*cartridges.ROMBankSize)
+	copy(rom, makeTestROM("MULTICART", []uint8{
+		0x3E, 0x01, // LD A,1
+		0xEA, 0x00, 0x60, // LD (6000),A
+		0x3E, 0x01, // LD A,1
+		0xEA, 0x00, 0x40, // LD (4000),A
+		0x18, 0xFE, // JR -2
+	}))
+	rom[cartridges.CartridgeTypeAddress] = 0x01
+	rom[cartridges.ROMSizeAddress] = 0x05
+
+	game := cartridges.MBC1MGameBanks * cartridges.ROMBankSize
+	copy(rom[game+0x015A:], []uint8{
+		0x04,       // INC B
+		0x18, 0xFD, // JR -3
+	})
+	copy(rom[cartridges.LogoAddress:], cartridges.NintendoLogo)
+	copy(rom[game+cartridges.LogoAddress:], cartridges.NintendoLogo)
+
+	gb := NewGameBoy(true, false)
+	gb.LoadCartridge(cartridges.NewMBC1Cartridge("", rom))
+	return gb
+}
+
+func TestBreakpointMulticartLowBank(t *testing.T) {
+	gb := newMulticartGameBoy()
+	debugger := gb.AttachDebugger()
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "00:015A"))
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "10:015A"))
+	gb.RunNextFrame()
+	if !debugger.Paused() || debugger.CurrentLocation() != (Breakpoint{Bank: 0x10, Address: 0x015A}) {
+		t.Fatalf("Expected to stop at 10:015A, stopped at %v", debugger.CurrentLocation())
+	}
+	if gb.cpu.B != 0 {
+		t.Fatalf("Expected to stop before running the second game, B is %02X", gb.cpu.B)
+	}
+}
+
+func TestStepping(t *testing.T) {
+	gb := newBankedGameBoy()
+	debugger := gb.AttachDebugger()
+	debugger.RunTo(mustParseBreakpoint(t, "0155"))
+	gb.RunNextFrame()
+	if gb.cpu.PC != 0x0155 {
+		t.Fatalf("Expected run to stop at 0155, stopped at %04X", gb.cpu.PC)
+	}
+
+	// Step over the call
+	b := gb.cpu.B
+	debugger.StepOver()
+	gb.RunNextFrame()
+	if !debugger.Paused() || gb.cpu.PC != 0x0158 || gb.cpu.B != b+1 {
+		t.Fatalf("Expected step over to stop at 0158 after the call, stopped at %04X with B=%02X", gb.cpu.PC, gb.cpu.B)
+	}
+
+	// Step into the call
+	debugger.StepInto()
+	debugger.StepInto()
+	if gb.cpu.PC != 0x4000 {
+		t.Fatalf("Expected step into call to stop at 4000, stopped at %04X", gb.cpu.PC)
+	}
+	if stack := debugger.Stack(1); stack[0] != 0x0158 {
+		t.Fatalf("Expected return address 0158 on the stack, got %04X", stack[0])
+	}
+
+	// Step out past the PUSH and POP
+	debugger.StepInto()
+	debugger.StepInto()
+	debugger.StepOut()
+	gb.RunNextFrame()
+	if !debugger.Paused() || gb.cpu.PC != 0x0158 {
+		t.Fatalf("Expected step out to stop at 0158, stopped at %04X", gb.cpu.PC)
+	}
+}
+
+func TestEditRegisters(t *testing.T) {
+	gb := newTestGameBoy("COUNTER", counterProgram)
+	debugger := gb.AttachDebugger()
+	if err := debugger.SetRegister("hl", 0x1234); err != nil {
+		t.Fatalf("Unable to set HL: %v", err)
+	}
+	if err := debugger.SetRegister("f", 0xFF); err != nil {
+		t.Fatalf("Unable to set F: %v", err)
+	}
+	if err := debugger.SetFlag("Z", false); err != nil {
+		t.Fatalf("Unable to set Z: %v", err)
+	}
+	registers := debugger.Registers()
+	if registers.H != 0x12 || registers.L != 0x34 || registers.F != 0x70 {
+		t.Fatalf("Unexpected registers after edit: %+v", registers)
+	}
+	if err := debugger.SetRegister("A", 0x100); err == nil {
+		t.Fatalf("Expected 16 bit value to be rejected for A")
+	}
+	if err := debugger.SetRegister("X", 0); err == nil {
+		t.Fatalf("Expected unknown register to be rejected")
+	}
+}
+
+func TestStoppingDoesNotChangeExecution(t *testing.T) {
+	reference, _ := newBusyGameBoy(t)
+	for i := 0; i < 20; i++ {
+		reference.RunNextFrame()
+	}
+
+	// Stop repeatedly partway through frames, saving and restoring state while stopped
+	gb, _ := newBusyGameBoy(t)
+	debugger := gb.AttachDebugger()
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "0050"))
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "015F"))
+	stops := 0
+	for frames := 0; frames < 20; {
+		gb.RunNextFrame()
+		if !debugger.Paused() {
+			frames++
+			continue
+		}
+		stops++
+		state := encodedState(t, gb)
+		if err := gb.Restore(bytes.NewReader(state)); err != nil {
+			t.Fatalf("Unable to restore state: %v", err)
+		}
+		if stops%3 == 0 {
+			debugger.StepInto()
+		}
+		debugger.Continue()
+	}
+	if stops < 100 {
+		t.Fatalf("Expected many stops, got %d", stops)
+	}
+	if !bytes.Equal(encodedState(t, gb), encodedState(t, reference)) {
+		t.Fatalf("State differs after running with the debugger")
+	}
+}
+
+func TestCallStack(t *testing.T) {
+	gb := newBankedGameBoy()
+	debugger := gb.AttachDebugger()
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "02:4002"))
+	gb.RunNextFrame()
+
+	frames := debugger.CallStack()
+	expected := CallFrame{
+		Caller: Breakpoint{Bank: 0, Address: 0x0155},
+		Entry:  Breakpoint{Bank: 2, Address: 0x4000},
+		SP:     0xFFFC,
+	}
+	if len(frames) != 1 || frames[0] != expected {
+		t.Fatalf("Expected a single call from 0155, got %+v", frames)
+	}
+
+	// Returning from the subroutine removes the call
+	debugger.StepOut()
+	gb.RunNextFrame()
+	if frames := debugger.CallStack(); len(frames) != 0 {
+		t.Errorf("Expected no calls after returning, got %+v", frames)
+	}
+
+	// Interrupt handlers are tracked as calls from the interrupted instruction
+	gb, _ = newBusyGameBoy(t)
+	debugger = gb.AttachDebugger()
+	debugger.AddBreakpoint(mustParseBreakpoint(t, "0043"))
+	gb.RunNextFrame()
+	gb.RunNextFrame()
+	frames = debugger.CallStack()
+	if len(frames) != 1 || !frames[0].Interrupt || frames[0].Entry.Address != 0x0040 ||
+		frames[0].Caller.Address < 0x0150 || frames[0].Caller.Address > 0x0180 {
+		t.Errorf("Expected a single VBlank interrupt frame, got %+v", frames)
+	}
+}
@@ -0,0 +1,352 @@
+// Package disasm decodes SM83 (Game Boy CPU) machine code into readable instructions
+package disasm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Opcode data from https://izik1.github.io/gbops/index.html
+
+/*
+Mnemonic templates use these placeholders for operands which follow the opcode
+
+d8   8 bit immediate value
+d16  16 bit immediate value
+a8   8 bit offset into the I/O page FF00-FFFF
+a16  16 bit address
+r8   8 bit signed offset from the end of the instruction (relative jumps)
+s8   8 bit signed value added to SP
+*/
+
+// opcodeInfo describes a single instruction encoding
+type opcodeInfo struct {
+	mnemonic string
+	// Size in bytes including the opcode
+	length int
+	// 4MHz clock cycles, for conditional instructions this is when the condition is not met
+	cycles int
+	// 4MHz clock cycles for conditional instructions when the condition is met, 0 for all other instructions
+	cyclesTaken int
+}
+
+// Instructions without a CB prefix
+var opcodes = [256]opcodeInfo{
+	0x00: {"NOP", 1, 4, 0},
+	0x01: {"LD BC,d16", 3, 12, 0},
+	0x02: {"LD (BC),A", 1, 8, 0},
+	0x03: {"INC BC", 1, 8, 0},
+	0x04: {"INC B", 1, 4, 0},
+	0x05: {"DEC B", 1, 4, 0},
+	0x06: {"LD B,d8", 2, 8, 0},
+	0x07: {"RLCA", 1, 4, 0},
+	0x08: {"LD (a16),SP", 3, 20, 0},
+	0x09: {"ADD HL,BC", 1, 8, 0},
+	0x0A: {"LD A,(BC)", 1, 8, 0},
+	0x0B: {"DEC BC", 1, 8, 0},
+	0x0C: {"INC C", 1, 4, 0},
+	0x0D: {"DEC C", 1, 4, 0},
+	0x0E: {"LD C,d8", 2, 8, 0},
+	0x0F: {"RRCA", 1, 4, 0},
+
+	0x10: {"STOP", 2, 4, 0},
+	0x11: {"LD DE,d16", 3, 12, 0},
+	0x12: {"LD (DE),A", 1, 8, 0},
+	0x13: {"INC DE", 1, 8, 0},
+	0x14: {"INC D", 1, 4, 0},
+	0x15: {"DEC D", 1, 4, 0},
+	0x16: {"LD D,d8", 2, 8, 0},
+	0x17: {"RLA", 1, 4, 0},
+	0x18: {"JR r8", 2, 12, 0},
+	0x19: {"ADD HL,DE", 1, 8, 0},
+	0x1A: {"LD A,(DE)", 1, 8, 0},
+	0x1B: {"DEC DE", 1, 8, 0},
+	0x1C: {"INC E", 1, 4, 0},
+	0x1D: {"DEC E", 1, 4, 0},
+	0x1E: {"LD E,d8", 2, 8, 0},
+	0x1F: {"RRA", 1, 4, 0},
+
+	0x20: {"JR NZ,r8", 2, 8, 12},
+	0x21: {"LD HL,d16", 3, 12, 0},
+	0x22: {"LD (HL+),A", 1, 8, 0},
+	0x23: {"INC HL", 1, 8, 0},
+	0x24: {"INC H", 1, 4, 0},
+	0x25: {"DEC H", 1, 4, 0},
+	0x26: {"LD H,d8", 2, 8, 0},
+	0x27: {"DAA", 1, 4, 0},
+	0x28: {"JR Z,r8", 2, 8, 12},
+	0x29: {"ADD HL,HL", 1, 8, 0},
+	0x2A: {"LD A,(HL+)", 1, 8, 0},
+	0x2B: {"DEC HL", 1, 8, 0},
+	0x2C: {"INC L", 1, 4, 0},
+	0x2D: {"DEC L", 1, 4, 0},
+	0x2E: {"LD L,d8", 2, 8, 0},
+	0x2F: {"CPL", 1, 4, 0},
+
+	0x30: {"JR NC,r8", 2, 8, 12},
+	0x31: {"LD SP,d16", 3, 12, 0},
+	0x32: {"LD (HL-),A", 1, 8, 0},
+	0x33: {"INC SP", 1, 8, 0},
+	0x34: {"INC (HL)", 1, 12, 0},
+	0x35: {"DEC (HL)", 1, 12, 0},
+	0x36: {"LD (HL),d8", 2, 12, 0},
+	0x37: {"SCF", 1, 4, 0},
+	0x38: {"JR C,r8", 2, 8, 12},
+	0x39: {"ADD HL,SP", 1, 8, 0},
+	0x3A: {"LD A,(HL-)", 1, 8, 0},
+	0x3B: {"DEC SP", 1, 8, 0},
+	0x3C: {"INC A", 1, 4, 0},
+	0x3D: {"DEC A", 1, 4, 0},
+	0x3E: {"LD A,d8", 2, 8, 0},
+	0x3F: {"CCF", 1, 4, 0},
+
+	// 0x40-0xBF are filled in by init
+
+	0xC0: {"RET NZ", 1, 8, 20},
+	0xC1: {"POP BC", 1, 12, 0},
+	0xC2: {"JP NZ,a16", 3, 12, 16},
+	0xC3: {"JP a16", 3, 16, 0},
+	0xC4: {"CALL NZ,a16", 3, 12, 24},
+	0xC5: {"PUSH BC", 1, 16, 0},
+	0xC6: {"ADD A,d8", 2, 8, 0},
+	0xC7: {"RST $00", 1, 16, 0},
+	0xC8: {"RET Z", 1, 8, 20},
+	0xC9: {"RET", 1, 16, 0},
+	0xCA: {"JP Z,a16", 3, 12, 16},
+	0xCB: {"PREFIX CB", 2, 4, 0},
+	0xCC: {"CALL Z,a16", 3, 12, 24},
+	0xCD: {"CALL a16", 3, 24, 0},
+	0xCE: {"ADC A,d8", 2, 8, 0},
+	0xCF: {"RST $08", 1, 16, 0},
+
+	0xD0: {"RET NC", 1, 8, 20},
+	0xD1: {"POP DE", 1, 12, 0},
+	0xD2: {"JP NC,a16", 3, 12, 16},
+	0xD4: {"CALL NC,a16", 3, 12, 24},
+	0xD5: {"PUSH DE", 1, 16, 0},
+	0xD6: {"SUB d8", 2, 8, 0},
+	0xD7: {"RST $10", 1, 16, 0},
+	0xD8: {"RET C", 1, 8, 20},
+	0xD9: {"RETI", 1, 16, 0},
+	0xDA: {"JP C,a16", 3, 12, 16},
+	0xDC: {"CALL C,a16", 3, 12, 24},
+	0xDE: {"SBC A,d8", 2, 8, 0},
+	0xDF: {"RST $18", 1, 16, 0},
+
+	0xE0: {"LDH (a8),A", 2, 12, 0},
+	0xE1: {"POP HL", 1, 12, 0},
+	0xE2: {"LD (C),A", 1, 8, 0},
+	0xE5: {"PUSH HL", 1, 16, 0},
+	0xE6: {"AND d8", 2, 8, 0},
+	0xE7: {"RST $20", 1, 16, 0},
+	0xE8: {"ADD SP,s8", 2, 16, 0},
+	0xE9: {"JP HL", 1, 4, 0},
+	0xEA: {"LD (a16),A", 3, 16, 0},
+	0xEE: {"XOR d8", 2, 8, 0},
+	0xEF: {"RST $28", 1, 16, 0},
+
+	0xF0: {"LDH A,(a8)", 2, 12, 0},
+	0xF1: {"POP AF", 1, 12, 0},
+	0xF2: {"LD A,(C)", 1, 8, 0},
+	0xF3: {"DI", 1, 4, 0},
+	0xF5: {"PUSH AF", 1, 16, 0},
+	0xF6: {"OR d8", 2, 8, 0},
+	0xF7: {"RST $30", 1, 16, 0},
+	0xF8: {"LD HL,SP+s8", 2, 12, 0},
+	0xF9: {"LD SP,HL", 1, 8, 0},
+	0xFA: {"LD A,(a16)", 3, 16, 0},
+	0xFB: {"EI", 1, 4, 0},
+	0xFE: {"CP d8", 2, 8, 0},
+	0xFF: {"RST $38", 1, 16, 0},
+}
+
+// Instructions following a CB prefix, filled in by init
+var cbOpcodes [256]opcodeInfo
+
+// Operands in the order they are encoded in the lower 3 bits of many opcodes
+var registerOperands = [8]string{"B", "C", "D", "E", "H", "L", "(HL)", "A"}
+
+func init() {
+	// 8 bit loads between registers, with HALT in place of LD (HL),(HL)
+	for opcode := 0x40; opcode < 0x80; opcode++ {
+		to, from := registerOperands[(opcode>>3)&7], registerOperands[opcode&7]
+		cycles := 4
+		if to == "(HL)" || from == "(HL)" {
+			cycles = 8
+		}
+		opcodes[opcode] = opcodeInfo{fmt.Sprintf("LD %s,%s", to, from), 1, cycles, 0}
+	}
+	opcodes[0x76] = opcodeInfo{"HALT", 1, 4, 0}
+
+	// 8 bit arithmetic on A
+	operations := [8]string{"ADD A,", "ADC A,", "SUB ", "SBC A,", "AND ", "XOR ", "OR ", "CP "}
+	for opcode := 0x80; opcode < 0xC0; opcode++ {
+		operand := registerOperands[opcode&7]
+		cycles := 4
+		if operand == "(HL)" {
+			cycles = 8
+		}
+		opcodes[opcode] = opcodeInfo{operations[(opcode>>3)&7] + operand, 1, cycles, 0}
+	}
+
+	// Opcodes with no defined instruction lock up the CPU
+	for opcode := range opcodes {
+		if opcodes[opcode].mnemonic == "" {
+			opcodes[opcode] = opcodeInfo{fmt.Sprintf("DB $%02X", opcode), 1, 4, 0}
+		}
+	}
+
+	// CB prefixed instructions are all 2 bytes, (HL) operands take longer to read and write memory
+	shifts := [8]string{"RLC", "RRC", "RL", "RR", "SLA", "SRA", "SWAP", "SRL"}
+	for opcode := 0; opcode < 0x100; opcode++ {
+		operand := registerOperands[opcode&7]
+		bit := (opcode >> 3) & 7
+		var mnemonic string
+		switch opcode >> 6 {
+		case 0:
+			mnemonic = fmt.Sprintf("%s %s", shifts[bit], operand)
+		case 1:
+			mnemonic = fmt.Sprintf("BIT %d,%s", bit, operand)
+		case 2:
+			mnemonic = fmt.Sprintf("RES %d,%s", bit, operand)
+		default:
+			mnemonic = fmt.Sprintf("SET %d,%s", bit, operand)
+		}
+
+		cycles := 8
+		if operand == "(HL)" {
+			if opcode>>6 == 1 {
+				// BIT only reads (HL)
+				cycles = 12
+			} else {
+				cycles = 16
+			}
+		}
+		cbOpcodes[opcode] = opcodeInfo{mnemonic, 2, cycles, 0}
+	}
+}
+
+// Instruction is a single decoded instruction
+type Instruction struct {
+	// Address the instruction was read from
+	Address uint16
+	// Encoded instruction, including any prefix and operands
+	Bytes []uint8
+	// 4MHz clock cycles, for conditional instructions this is when the condition is not met
+	Cycles int
+	// 4MHz clock cycles for conditional instructions when the condition is met, otherwise the same as Cycles
+	CyclesTaken int
+	// Address referred to by an a8, a16 or r8 operand (jump targets and memory accesses), when HasTarget is set
+	Target    uint16
+	HasTarget bool
+
+	mnemonic string
+}
+
+// Decode reads the instruction at the start of data, which is located at address.
+// If data ends partway through the instruction it is decoded as a single DB byte
+func Decode(data []uint8, address uint16) Instruction {
+	if len(data) == 0 {
+		return Instruction{Address: address}
+	}
+
+	info := opcodes[data[0]]
+	if data[0] == 0xCB && len(data) > 1 {
+		info = cbOpcodes[data[1]]
+	}
+	if len(data) < info.length {
+		info = opcodeInfo{fmt.Sprintf("DB $%02X", data[0]), 1, 4, 0}
+	}
+
+	inst := Instruction{
+		Address:     address,
+		Bytes:       append([]uint8{}, data[:info.length]...),
+		Cycles:      info.cycles,
+		CyclesTaken: info.cycles,
+		mnemonic:    info.mnemonic,
+	}
+	if info.cyclesTaken != 0 {
+		inst.CyclesTaken = info.cyclesTaken
+	}
+
+	switch {
+	case strings.Contains(info.mnemonic, "a16"):
+		inst.Target = inst.immediate16()
+		inst.HasTarget = true
+	case strings.Contains(info.mnemonic, "a8"):
+		inst.Target = 0xFF00 | uint16(inst.Bytes[1])
+		inst.HasTarget = true
+	case strings.Contains(info.mnemonic, "r8"):
+		inst.Target = address + uint16(info.length) + uint16(int8(inst.Bytes[1]))
+		inst.HasTarget = true
+	}
+	return inst
+}
+
+// Length returns the size of the instruction in bytes
+func (inst Instruction) Length() int {
+	return len(inst.Bytes)
+}
+
+// Value of a 16 bit operand, stored little endian after the opcode
+func (inst Instruction) immediate16() uint16 {
+	return uint16(inst.Bytes[2])<<8 | uint16(inst.Bytes[1])
+}
+
+// IsCall returns whether the instruction is a CALL or RST, which push a return address
+func (inst Instruction) IsCall() bool {
+	return strings.HasPrefix(inst.mnemonic, "CALL") || strings.HasPrefix(inst.mnemonic, "RST")
+}
+
+// IsReturn returns whether the instruction is a RET or RETI
+func (inst Instruction) IsReturn() bool {
+	return strings.HasPrefix(inst.mnemonic, "RET")
+}
+
+// IsConditional returns whether the instruction only branches when a flag condition is met
+func (inst Instruction) IsConditional() bool {
+	return inst.CyclesTaken != inst.Cycles
+}
+
+// String formats the instruction with all values in hex, such as "LD A,($C000)"
+func (inst Instruction) String() string {
+	return inst.Format(nil)
+}
+
+// Format formats the instruction, using label to name the target address when it returns a non-empty string
+func (inst Instruction) Format(label func(address uint16) string) string {
+	text := inst.mnemonic
+	target := ""
+	if inst.HasTarget {
+		if label != nil {
+			target = label(inst.Target)
+		}
+		if target == "" {
+			target = fmt.Sprintf("$%04X", inst.Target)
+		}
+	}
+
+	switch {
+	case strings.Contains(text, "d16"):
+		return strings.Replace(text, "d16", fmt.Sprintf("$%04X", inst.immediate16()), 1)
+	case strings.Contains(text, "d8"):
+		return strings.Replace(text, "d8", fmt.Sprintf("$%02X", inst.Bytes[len(inst.Bytes)-1]), 1)
+	case strings.Contains(text, "s8"):
+		offset := int8(inst.Bytes[1])
+		if strings.HasSuffix(text, "+s8") && offset < 0 {
+			// SP+s8 with a negative offset reads better as SP-n
+			return strings.Replace(text, "+s8", fmt.Sprintf("-$%02X", -int(offset)), 1)
+		}
+		if offset < 0 {
+			return strings.Replace(text, "s8", fmt.Sprintf("-$%02X", -int(offset)), 1)
+		}
+		return strings.Replace(text, "s8", fmt.Sprintf("$%02X", offset), 1)
+	case strings.Contains(text, "a16"):
+		return strings.Replace(text, "a16", target, 1)
+	case strings.Contains(text, "a8"):
+		return strings.Replace(text, "a8", target, 1)
+	case strings.Contains(text, "r8"):
+		return strings.Replace(text, "r8", target, 1)
+	}
+	return text
+}
@@ -0,0 +1,152 @@
+package disasm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	testcases := []struct {
+		code     []uint8
+		address  uint16
+		expected string
+		length   int
+	}{
+		{[]uint8{0x00}, 0x0100, "NOP", 1},
+		{[]uint8{0x3E, 0x12}, 0x0150, "LD A,$12", 2},
+		{[]uint8{0x21, 0x34, 0x12}, 0x0150, "LD HL,$1234", 3},
+		{[]uint8{0xEA, 0x00, 0xC0}, 0x0150, "LD ($C000),A", 3},
+		{[]uint8{0xE0, 0x44}, 0x0150, "LDH ($FF44),A", 2},
+		{[]uint8{0x18, 0xFA}, 0x0154, "JR $0150", 2},
+		{[]uint8{0x20, 0x05}, 0x0150, "JR NZ,$0157", 2},
+		{[]uint8{0xCD, 0x00, 0x40}, 0x0150, "CALL $4000", 3},
+		{[]uint8{0xF8, 0xFD}, 0x0150, "LD HL,SP-$03", 2},
+		{[]uint8{0xE8, 0x05}, 0x0150, "ADD SP,$05", 2},
+		{[]uint8{0x7E}, 0x0150, "LD A,(HL)", 1},
+		{[]uint8{0xAF}, 0x0150, "XOR A", 1},
+		{[]uint8{0xCB, 0x37}, 0x0150, "SWAP A", 2},
+		{[]uint8{0xCB, 0x7E}, 0x0150, "BIT 7,(HL)", 2},
+		{[]uint8{0xD3}, 0x0150, "DB $D3", 1},
+		// Truncated instructions
+		{[]uint8{0xCD, 0x00}, 0x7FFE, "DB $CD", 1},
+		{[]uint8{0xCB}, 0x7FFF, "DB $CB", 1},
+	}
+	for _, testcase := range testcases {
+		inst := Decode(testcase.code, testcase.address)
+		if inst.String() != testcase.expected || inst.Length() != testcase.length {
+			t.Errorf("Decoding %X: expected %q (%d bytes), got %q (%d bytes)",
+				testcase.code, testcase.expected, testcase.length, inst.String(), inst.Length())
+		}
+	}
+}
+
+func TestInstructionKinds(t *testing.T) {
+	call := Decode([]uint8{0xC4, 0x00, 0x40}, 0)
+	if !call.IsCall() || !call.IsConditional() || call.Cycles != 12 || call.CyclesTaken != 24 {
+		t.Errorf("Unexpected decoding of CALL NZ: %+v", call)
+	}
+	if rst := Decode([]uint8{0xFF}, 0); !rst.IsCall() || rst.IsConditional() {
+		t.Errorf("Expected RST to be an unconditional call")
+	}
+	if ret := Decode([]uint8{0xD9}, 0); !ret.IsReturn() {
+		t.Errorf("Expected RETI to be a return")
+	}
+	if jp := Decode([]uint8{0xC3, 0x50, 0x01}, 0); jp.IsCall() || jp.IsReturn() || !jp.HasTarget || jp.Target != 0x0150 {
+		t.Errorf("Unexpected decoding of JP: %+v", jp)
+	}
+}
+
+func TestSymbols(t *testing.T) {
+	file := `; comment line
+00:0150 Main
+00:0158 Main.loop ; trailing comment
+02:4000 LoadLevel
+03:4000 DrawLevel
+00:C000 wPlayerX
+`
+	symbols, err := ParseSymbols(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("Unable to parse symbols: %v", err)
+	}
+
+	if name, ok := symbols.Name(5, 0x0158); !ok || name != "Main.loop" {
+		t.Errorf("Expected Main.loop at 0158 from any bank, got %q", name)
+	}
+	if name, _ := symbols.Name(3, 0x4000); name != "DrawLevel" {
+		t.Errorf("Expected DrawLevel at 03:4000, got %q", name)
+	}
+	if _, ok := symbols.Name(1, 0x4000); ok {
+		t.Errorf("Expected no symbol at 01:4000")
+	}
+	if symbol, ok := symbols.Lookup("LoadLevel"); !ok || symbol.Bank != 2 || symbol.Address != 0x4000 {
+		t.Errorf("Unexpected lookup of LoadLevel: %+v", symbol)
+	}
+
+	label := func(address uint16) string {
+		name, _ := symbols.Name(2, address)
+		return name
+	}
+	if text := Decode([]uint8{0xCD, 0x00, 0x40}, 0x0150).Format(label); text != "CALL LoadLevel" {
+		t.Errorf("Expected call to be labelled, got %q", text)
+	}
+	if text := Decode([]uint8{0xFA, 0x00, 0xC0}, 0x0150).Format(label); text != "LD A,(wPlayerX)" {
+		t.Errorf("Expected load to be labelled, got %q", text)
+	}
+
+	for _, bad := range []string{"0150 Main", "00:XYZ Main", "00:0150"} {
+		if _, err := ParseSymbols(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestMapFile(t *testing.T) {
+	file := `SUMMARY:
+	ROM0: 340 bytes used / 16044 free
+
+ROM0 bank #0:
+	SECTION: $0150-$0163 ($0014 bytes) ["Main"]
+	         $0150 = Main
+	         $0158 = Main.loop
+	EMPTY: $0164-$3fff ($3e9c bytes)
+
+ROMX bank #2:
+	SECTION: $4000-$4003 ($0004 bytes) ["Levels"]
+	         $4000 = LoadLevel
+
+WRAM0 bank #0:
+	SECTION: $c000-$c001 ($0002 bytes) ["Variables"]
+	         $c000 = wPlayerX
+`
+	symbols, err := ParseMapFile(strings.NewReader(file))
+	if err != nil {
+		t.Fatalf("Unable to parse map file: %v", err)
+	}
+	if symbol, ok := symbols.Lookup("LoadLevel"); !ok || symbol.Bank != 2 || symbol.Address != 0x4000 {
+		t.Errorf("Unexpected lookup of LoadLevel: %+v", symbol)
+	}
+	if symbol, ok := symbols.Lookup("wPlayerX"); !ok || symbol.Address != 0xC000 {
+		t.Errorf("Unexpected lookup of wPlayerX: %+v", symbol)
+	}
+
+	testcases := []struct {
+		bank     int
+		address  uint16
+		expected string
+	}{
+		{0, 0x0157, "Main"},
+		{0, 0x015A, "Main.loop"},
+		{2, 0x4002, "LoadLevel"},
+		{1, 0x4002, ""},
+		{0, 0xC001, "wPlayerX"},
+		// Labels do not extend into other regions of memory
+		{0, 0x3000, "Main.loop"},
+		{2, 0x8000, ""},
+	}
+	for _, testcase := range testcases {
+		symbol, _ := symbols.Nearest(testcase.bank, testcase.address)
+		if symbol.Name != testcase.expected {
+			t.Errorf("Expected nearest symbol to %02X:%04X to be %q, got %q", testcase.bank, testcase.address, testcase.expected, symbol.Name)
+		}
+	}
+}
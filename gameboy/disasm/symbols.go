@@ -0,0 +1,262 @@
+package disasm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+Symbol files list one label per line as a hex bank and address followed by the name, as written by RGBDS
+(rgblink -n) and understood by most Game Boy debuggers. Anything after a ; is a comment.
+
+	; game.sym
+	00:0150 Main
+	00:0158 Main.loop
+	02:4000 LoadLevel
+	00:C000 wPlayerX
+
+Map files written by rgblink -m list the sections placed in each bank, with the labels in each section:
+
+	ROMX bank #2:
+		SECTION: $4000-$4fff ($1000 bytes) ["Level code"]
+		         $4000 = LoadLevel
+
+Only the bank headers and label lines are used from map files.
+*/
+
+// Symbol is a named location in the Game Boy address space
+type Symbol struct {
+	// ROM bank for addresses in 4000-7FFF, 0 everywhere else
+	Bank    int
+	Address uint16
+	Name    string
+}
+
+type symbolLocation struct {
+	bank    int
+	address uint16
+}
+
+// Symbols holds the labels loaded from a symbol file
+type Symbols struct {
+	byLocation map[symbolLocation]string
+	byName     map[string]Symbol
+}
+
+// Normalize a location so only switchable ROM addresses depend on the bank
+func locationOf(bank int, address uint16) symbolLocation {
+	if address < 0x4000 || address >= 0x8000 {
+		bank = 0
+	}
+	return symbolLocation{bank: bank, address: address}
+}
+
+// NewSymbols returns an empty set of symbols
+func NewSymbols() *Symbols {
+	return &Symbols{
+		byLocation: make(map[symbolLocation]string),
+		byName:     make(map[string]Symbol),
+	}
+}
+
+// ParseSymbols reads symbols in the symbol file format described above
+func ParseSymbols(r io.Reader) (*Symbols, error) {
+	symbols := NewSymbols()
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if comment := strings.IndexByte(line, ';'); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"BB:AAAA name\"", lineNumber)
+		}
+
+		bankText, addressText, found := strings.Cut(fields[0], ":")
+		if !found {
+			return nil, fmt.Errorf("line %d: expected \"BB:AAAA name\"", lineNumber)
+		}
+		bank, err := strconv.ParseUint(bankText, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid bank %q", lineNumber, bankText)
+		}
+		address, err := strconv.ParseUint(addressText, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", lineNumber, addressText)
+		}
+
+		symbols.Add(Symbol{Bank: int(bank), Address: uint16(address), Name: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
+
+// LoadSymbolFile reads a symbol file
+func LoadSymbolFile(filename string) (*Symbols, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	symbols, err := ParseSymbols(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return symbols, nil
+}
+
+// ParseMapFile reads the labels from an rgblink map file
+func ParseMapFile(r io.Reader) (*Symbols, error) {
+	symbols := NewSymbols()
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	bank := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Bank header, such as "ROMX bank #2:"
+		if _, number, found := strings.Cut(line, " bank #"); found && strings.HasSuffix(number, ":") {
+			value, err := strconv.ParseUint(strings.TrimSuffix(number, ":"), 10, 16)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid bank number %q", lineNumber, number)
+			}
+			bank = int(value)
+			continue
+		}
+
+		// Label, such as "$4000 = LoadLevel"
+		if !strings.HasPrefix(line, "$") {
+			continue
+		}
+		addressText, name, found := strings.Cut(line[1:], " = ")
+		if !found {
+			continue
+		}
+		address, err := strconv.ParseUint(addressText, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid address %q", lineNumber, addressText)
+		}
+		symbols.Add(Symbol{Bank: bank, Address: uint16(address), Name: strings.TrimSpace(name)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return symbols, nil
+}
+
+// LoadMapFile reads the labels from an rgblink map file
+func LoadMapFile(filename string) (*Symbols, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	symbols, err := ParseMapFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return symbols, nil
+}
+
+// Merge adds every symbol from other which does not share a name with an existing symbol
+func (s *Symbols) Merge(other *Symbols) {
+	for _, symbol := range other.All() {
+		if _, exists := s.byName[symbol.Name]; !exists {
+			s.Add(symbol)
+		}
+	}
+}
+
+// Add a symbol, if a location has more than one name the first one added is used when looking up the location
+func (s *Symbols) Add(symbol Symbol) {
+	location := locationOf(symbol.Bank, symbol.Address)
+	symbol.Bank = location.bank
+	if _, exists := s.byLocation[location]; !exists {
+		s.byLocation[location] = symbol.Name
+	}
+	s.byName[symbol.Name] = symbol
+}
+
+// Name returns the label at an address, the bank is only used for addresses in 4000-7FFF
+func (s *Symbols) Name(bank int, address uint16) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	name, ok := s.byLocation[locationOf(bank, address)]
+	return name, ok
+}
+
+// Region of the address space an address is in, labels never extend from one region into another
+func regionOf(address uint16) int {
+	switch {
+	case address < 0x4000:
+		return 0 // ROM bank 0
+	case address < 0x8000:
+		return 1 // Switchable ROM bank
+	case address < 0xA000:
+		return 2 // Video RAM
+	case address < 0xC000:
+		return 3 // External RAM
+	case address < 0xE000:
+		return 4 // Work RAM
+	}
+	return 5 // Echo RAM, OAM, I/O and HRAM
+}
+
+// Nearest returns the closest symbol at or before an address, within the same bank and memory region
+func (s *Symbols) Nearest(bank int, address uint16) (Symbol, bool) {
+	location := locationOf(bank, address)
+	found := false
+	var nearest symbolLocation
+	for _, symbol := range s.All() {
+		if symbol.Bank != location.bank || symbol.Address > address || regionOf(symbol.Address) != regionOf(address) {
+			continue
+		}
+		if !found || symbol.Address > nearest.address {
+			nearest = locationOf(symbol.Bank, symbol.Address)
+			found = true
+		}
+	}
+	if !found {
+		return Symbol{}, false
+	}
+	return Symbol{Bank: nearest.bank, Address: nearest.address, Name: s.byLocation[nearest]}, true
+}
+
+// Lookup returns the symbol with the given name
+func (s *Symbols) Lookup(name string) (Symbol, bool) {
+	if s == nil {
+		return Symbol{}, false
+	}
+	symbol, ok := s.byName[name]
+	return symbol, ok
+}
+
+// All returns every symbol
+func (s *Symbols) All() []Symbol {
+	if s == nil {
+		return nil
+	}
+	all := make([]Symbol, 0, len(s.byName))
+	for _, symbol := range s.byName {
+		all = append(all, symbol)
+	}
+	return all
+}
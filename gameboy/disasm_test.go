@@ -0,0 +1,55 @@
+package gameboy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cbott/GoEmulate/gameboy/disasm"
+)
+
+// Check the cycle counts from the disassembler against those of the emulator for every instruction
+func TestDisassemblerCyclesMatchCPU(t *testing.T) {
+	conditions := map[string]uint8{"NZ": 1 << FlagZ, "Z": 0, "NC": 1 << FlagC, "C": 0}
+
+	for prefix := 0; prefix < 2; prefix++ {
+		for opcode := 0; opcode < 0x100; opcode++ {
+			code := []uint8{uint8(opcode), 0x34, 0x12}
+			if prefix == 1 {
+				code = []uint8{0xCB, uint8(opcode)}
+			}
+			inst := disasm.Decode(code, 0xC000)
+			text := inst.String()
+			if strings.HasPrefix(text, "DB") || text == "HALT" || text == "STOP" {
+				continue
+			}
+
+			// The flags which fail the condition come first, then those which meet it
+			flagSets := []uint8{0x00}
+			expected := []int{inst.Cycles}
+			if inst.IsConditional() {
+				condition := strings.Split(strings.Fields(text)[1], ",")[0]
+				failing := conditions[condition]
+				flagSets = []uint8{failing, failing ^ (1 << FlagZ) ^ (1 << FlagC)}
+				expected = []int{inst.Cycles, inst.CyclesTaken}
+			}
+
+			for i, flags := range flagSets {
+				gb := newTestGameBoy("CYCLES", nil)
+				for j, b := range code {
+					gb.memory.set(0xC000+uint16(j), b)
+				}
+				gb.cpu.PC = 0xC000
+				gb.cpu.SP = 0xD000
+				gb.cpu.setRegister16(regHL, 0xC100)
+				gb.cpu.F = flags
+
+				if cycles := gb.RunNextOpcode(); cycles != expected[i] {
+					t.Errorf("%s (%X): disassembler has %d cycles, CPU took %d", text, code[:inst.Length()], expected[i], cycles)
+				}
+				if !inst.IsConditional() && inst.HasTarget && strings.HasPrefix(text, "J") && gb.cpu.PC != inst.Target {
+					t.Errorf("%s: disassembler target %04X, CPU jumped to %04X", text, inst.Target, gb.cpu.PC)
+				}
+			}
+		}
+	}
+}
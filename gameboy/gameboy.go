@@ -1,6 +1,9 @@
 package gameboy
 
-import "github.com/cbott/GoEmulate/cartridges"
+import (
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/sound"
+)
 
 const (
 	CpuSpeed        = 4194304                                      // Hz
@@ -11,6 +14,8 @@ const (
 type Gameboy struct {
 	cpu    *CpuRegisters
 	memory *Memory
+	// Cartridge hardware run alongside the console, nil if the cartridge has none
+	clockedCartridge cartridges.ClockedCartridge
 
 	// Array of RGB triplets for each pixel on the Game Boy screen
 	// This is filled in throughout the PPU processes and then displayed
@@ -26,12 +31,36 @@ type Gameboy struct {
 	interruptMasterEnable bool
 	// Some instructions set interrupt state with a 1 operation delay, these bools track that state
 	pendingInterruptEnable bool
-	// Storage for CPU save states
-	savestates [NumSaveStates]*SaveState
+	// Storage for save states, each a snapshot of the full console state
+	savestates [NumSaveStates][]byte
+	// History of recent states for rewinding, nil when rewind is disabled
+	rewind *rewindHistory
+	// Input movie being recorded or played back, nil when not in use
+	recording *movieRecorder
+	playback  *moviePlayer
 
 	screenCleared  bool
 	displayEnabled bool
 	debugColors    bool
+
+	// Progress through the current frame, kept here so a frame stopped by the debugger can be resumed
+	frameCycles  int
+	frameRunning bool
+	// Set once the console has run or had a state restored, so it is no longer at power-on
+	started bool
+	// Cycles spent servicing interrupts which the rest of the hardware has not yet been run for
+	interruptCycles int
+	// Debugger controlling execution, nil when not attached
+	debugger *Debugger
+	// Instruction trace being written, nil when not tracing
+	tracer *tracer
+	// Cheat codes, which can all be turned off at once without losing which are enabled
+	cheats         []Cheat
+	cheatsDisabled bool
+	// Serial port and the link cable peer attached to it
+	serial serialPort
+	// Set when a failed restore could not be undone, nothing runs until a state is restored
+	stateLost bool
 }
 
 // Create and initialize a Game Boy struct
@@ -54,6 +83,17 @@ func NewGameBoy(skipboot bool, debugColors bool) *Gameboy {
 // Load an initialized Cartridge struct into Game Boy memory
 func (gb *Gameboy) LoadCartridge(c cartridges.Cartridge) {
 	gb.memory.cartridge = c
+	gb.clockedCartridge, _ = c.(cartridges.ClockedCartridge)
+}
+
+// SetAudioSink sets where the console's sound output is sent, audio is discarded if no sink is set
+func (gb *Gameboy) SetAudioSink(sink sound.AudioSink) {
+	gb.memory.apu.SetSink(sink)
+}
+
+// ReadMemory returns the value the CPU would currently read from the given address
+func (gb *Gameboy) ReadMemory(address uint16) uint8 {
+	return gb.memory.read(address)
 }
 
 // Write cartridge RAM contents to the save file
@@ -62,37 +102,107 @@ func (gb *Gameboy) SaveCartridgeRAM() {
 }
 
 // RunNextFrame executes Game Boy processes up to the next complete frame to be displayed
+// If the debugger stops execution partway through, the rest of the frame is run by the next call after it resumes
 func (gb *Gameboy) RunNextFrame() {
-	var totalCycles int
-	var lastTotalCycles int
+	if gb.stateLost || gb.debugger != nil && gb.debugger.paused {
+		return
+	}
+	if !gb.frameRunning {
+		gb.startFrame()
+	}
+
+	for gb.frameCycles < CyclesPerFrame {
+		if gb.debugger != nil && gb.debugger.shouldStop() {
+			return
+		}
+		gb.step()
+	}
+
+	gb.finishFrame()
+}
+
+// RunCycles executes instructions for at least the specified number of machine cycles, carrying on into the next
+// frame as needed but stopping early once a frame is completed. It returns the number of cycles run and whether a
+// frame was completed, so a caller can step several consoles in lockstep and still know when to display them
+func (gb *Gameboy) RunCycles(cycles int) (int, bool) {
+	if gb.stateLost || gb.debugger != nil && gb.debugger.paused {
+		return 0, false
+	}
+
+	run := 0
+	for run < cycles {
+		if !gb.frameRunning {
+			gb.startFrame()
+		}
+		if gb.debugger != nil && gb.debugger.shouldStop() {
+			return run, false
+		}
+		before := gb.frameCycles
+		gb.step()
+		run += gb.frameCycles - before
+
+		if gb.frameCycles >= CyclesPerFrame {
+			gb.finishFrame()
+			return run, true
+		}
+	}
+	return run, false
+}
+
+// Prepare to run a new frame
+func (gb *Gameboy) startFrame() {
+	gb.started = true
+	gb.frameRunning = true
+	gb.frameCycles = 0
+	gb.interruptCycles = 0
+
+	gb.movieFrameStarted()
+	gb.applyRAMCheats()
 
 	// Clear screen and restart rendering at dot zero
 	// A bit of a hack to force display timing to match up perfectly with PPU process
 	gb.clearScreen()
 	// Set flag to resume rendering if we recently enabled the LCD
 	gb.displayEnabled = true
+}
 
-	for totalCycles = 0; totalCycles < CyclesPerFrame; {
-		var operationCycles int
-		if gb.halted {
-			// Behavior while halted is approximate, not really tested
-			operationCycles = 4
-		} else {
-			operationCycles = gb.RunNextOpcode()
-		}
+// Run a single instruction, and the rest of the hardware for the time it took
+func (gb *Gameboy) step() {
+	var operationCycles int
+	if gb.halted {
+		// Behavior while halted is approximate, not really tested
+		operationCycles = 4
+	} else {
+		operationCycles = gb.RunNextOpcode()
+	}
+	gb.frameCycles += operationCycles
+
+	// We want to run these processes for the time it took to do the current opcode
+	// plus any time we spent on interrupts the last step
+	cyclesSinceLast := operationCycles + gb.interruptCycles
+
+	gb.RunGraphicsProcess(cyclesSinceLast)
+	gb.RunTimers(cyclesSinceLast)
+	gb.RunSerial(cyclesSinceLast)
+	gb.memory.apu.RunAudioProcess(cyclesSinceLast)
+	if gb.clockedCartridge != nil {
+		gb.clockedCartridge.RunCycles(cyclesSinceLast)
+	}
 
-		totalCycles += operationCycles
+	// Evaulate interrupt state after this round of graphics and timer updates
+	gb.interruptCycles = gb.RunInterrupts()
+	gb.frameCycles += gb.interruptCycles
+}
 
-		// We want to run these processes for the time it took to do the current opcode
-		// plus any time we spent on interrupts the last loop
-		cyclesSinceLast := totalCycles - lastTotalCycles
-		lastTotalCycles = totalCycles
+// Complete the current frame
+func (gb *Gameboy) finishFrame() {
+	gb.frameRunning = false
 
-		gb.RunGraphicsProcess(cyclesSinceLast)
-		gb.RunTimers(cyclesSinceLast)
-		gb.memory.apu.RunAudioProcess(cyclesSinceLast)
+	// Pass this frame's audio on to the sink
+	gb.memory.apu.Flush()
 
-		// Evaulate interrupt state after this round of graphics and timer updates
-		totalCycles += gb.RunInterrupts()
+	gb.movieFrameCompleted()
+	if gb.rewind != nil {
+		gb.rewind.frameCompleted(gb)
 	}
 }
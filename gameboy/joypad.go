@@ -26,17 +26,21 @@ type ButtonState struct {
 
 // SetButtonState sets the state of the Game Boy joypad buttons for the emulator
 // and requests an interrupt if a button just became pressed
+// While a movie is playing the joypad is controlled by the movie and the state is ignored
 func (gb *Gameboy) SetButtonStates(state *ButtonState) {
+	if gb.MoviePlaying() {
+		return
+	}
+	if gb.recording != nil && ^state.pressedMask() != gb.memory.buttonStates {
+		gb.recording.buttonsChanged(*state)
+	}
+	gb.setButtonStates(state)
+}
+
+func (gb *Gameboy) setButtonStates(state *ButtonState) {
 	// Gameboy reads values from register as 1=unpressed, 0=pressed
 	// we invert at the end so bit operations are easier
-	var reg uint8 = 0
-	for index, element := range []bool{state.BtnA, state.BtnB, state.BtnSelect, state.BtnStart,
-		state.BtnRight, state.BtnLeft, state.BtnUp, state.BtnDown} {
-		if element {
-			reg |= 1 << index
-		}
-	}
-	reg = ^reg
+	reg := ^state.pressedMask()
 
 	// Perform an interrupt if any button went from unpressed to pressed
 	var doInterrupt bool = false
@@ -57,6 +61,32 @@ func (gb *Gameboy) SetButtonStates(state *ButtonState) {
 	}
 }
 
+// Return the buttons as a bit mask with 1=pressed, in order A, B, Select, Start, Right, Left, Up, Down from bit 0
+func (state *ButtonState) pressedMask() uint8 {
+	var mask uint8 = 0
+	for index, element := range []bool{state.BtnA, state.BtnB, state.BtnSelect, state.BtnStart,
+		state.BtnRight, state.BtnLeft, state.BtnUp, state.BtnDown} {
+		if element {
+			mask |= 1 << index
+		}
+	}
+	return mask
+}
+
+// Convert a bit mask from pressedMask back into button states
+func buttonStateFromMask(mask uint8) ButtonState {
+	return ButtonState{
+		BtnA:      mask&(1<<0) != 0,
+		BtnB:      mask&(1<<1) != 0,
+		BtnSelect: mask&(1<<2) != 0,
+		BtnStart:  mask&(1<<3) != 0,
+		BtnRight:  mask&(1<<4) != 0,
+		BtnLeft:   mask&(1<<5) != 0,
+		BtnUp:     mask&(1<<6) != 0,
+		BtnDown:   mask&(1<<7) != 0,
+	}
+}
+
 // Given register P1 with select bits set, return value of P1 with button state bits set as well
 func (m *Memory) GetP1Value() uint8 {
 	// Read the current P1 register value
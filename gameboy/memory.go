@@ -25,10 +25,32 @@ type Memory struct {
 	divAccumulator int
 	// Stores the state of each joypad button (down/up/left/right/start/select/B/A)
 	buttonStates uint8
+
+	// Debugger checking accesses against its watchpoints, only set while the CPU runs an instruction
+	watcher *Debugger
+	// Enabled Game Genie codes by address, nil when there are none
+	romPatches map[uint16][]Cheat
 }
 
 // Write a value to memory
 func (m *Memory) set(address uint16, value uint8) {
+	if m.watcher != nil {
+		m.watcher.memoryWritten(address, m.read(address), value)
+	}
+	m.write(address, value)
+}
+
+// Read a value from memory
+func (m *Memory) get(address uint16) uint8 {
+	value := m.read(address)
+	if m.watcher != nil {
+		m.watcher.memoryRead(address, value)
+	}
+	return value
+}
+
+// Write a value to memory without checking watchpoints
+func (m *Memory) write(address uint16, value uint8) {
 	if address == DIV {
 		// Writing any value to the DIV register sets it to 0
 		m.divAccumulator = 0
@@ -50,8 +72,8 @@ func (m *Memory) set(address uint16, value uint8) {
 	}
 }
 
-// Read a value from memory
-func (m *Memory) get(address uint16) uint8 {
+// Read a value from memory without checking watchpoints
+func (m *Memory) read(address uint16) uint8 {
 	// Address space 0-FF is mapped to Boot ROM untill fully booted
 	if (address < 0x100) && (m.memory[BOOT] == 0) {
 		return m.bootrom[address]
@@ -63,6 +85,9 @@ func (m *Memory) get(address uint16) uint8 {
 		if m.cartridge == nil {
 			panic("Attempted to access cartridge before one is loaded")
 		}
+		if m.romPatches != nil {
+			return m.patchROM(address, m.cartridge.ReadFrom(address))
+		}
 		return m.cartridge.ReadFrom(address)
 	}
 
@@ -75,6 +100,11 @@ func (m *Memory) get(address uint16) uint8 {
 		return m.memory[address] | 0b11100000
 	}
 
+	// SC bits 1-6 are unused
+	if address == SC {
+		return m.memory[address] | 0b01111110
+	}
+
 	// STAT bit 7 is unused
 	if address == STAT {
 		return m.memory[address] | 0b10000000
@@ -0,0 +1,365 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/snapshot"
+)
+
+/*
+Input Movies
+
+A movie is the list of joypad changes made with SetButtonStates before each frame, starting either from
+power-on or from a save state embedded in the movie. Since the console only changes state in response
+to joypad input (nothing in RunNextFrame depends on the wall clock) playing the inputs back from the
+same starting point reproduces the original session exactly.
+
+To check this, a hash of the full console state is stored every HashInterval frames while recording
+and compared during playback. The first frame where the hashes differ is reported as the desync frame,
+the actual divergence happened at some point in the HashInterval frames before it.
+
+Movie File Format
+All multi-byte values are little endian
+
+Header
+Offset  Size  Field
+0       8     Magic "GBMOVIE\x00"
+8       2     Format version (MovieVersion)
+10      2     Save state version (SaveStateVersion) of the start state and hashes
+12      16    ROM title from the cartridge header, zero padded
+28      2     ROM global checksum from the cartridge header
+30      4     Frames between state hashes
+34      4     Number of frames
+38      4     Number of hashes
+42      4     Start state length in bytes, 0 when starting from power-on
+
+Body
+- Start state, the output of Gameboy.Snapshot
+- For each frame, the number of joypad changes before it (1 byte) followed by each new button state
+  (1 byte, bits 0-7 set for A, B, Select, Start, Right, Left, Up, Down pressed)
+- Each hash (4 bytes), CRC-32 (IEEE) of the console snapshot after every HashInterval frames, the
+  first being taken at the start of the movie
+*/
+
+// Current version of the movie file format
+const MovieVersion = 1
+
+// Default number of frames between state hashes while recording
+const DefaultMovieHashInterval = 60
+
+var movieMagic = [8]byte{'G', 'B', 'M', 'O', 'V', 'I', 'E', 0}
+
+// Upper limit on movie sizes to avoid allocating huge buffers for corrupt files
+const (
+	maxMovieFrames = 100 * 60 * 60 * 60 // 100 hours
+	maxMovieState  = maxSaveStatePayload
+)
+
+// Movie holds a recording of joypad input which can be played back to reproduce a session
+type Movie struct {
+	// Cartridge the movie was recorded with
+	Title          string
+	GlobalChecksum uint16
+	// Console state the movie starts from, nil if it starts from power-on
+	StartState []byte
+	// Number of frames between state hashes
+	HashInterval int
+	// Changes in button state before each frame in the order they were made, usually none or one
+	Frames [][]ButtonState
+	// Hashes[i] is the hash of the console state after i*HashInterval frames
+	Hashes []uint32
+}
+
+type movieHeader struct {
+	Magic          [8]byte
+	Version        uint16
+	StateVersion   uint16
+	Title          [cartridges.TitleLength]byte
+	GlobalChecksum uint16
+	HashInterval   uint32
+	FrameCount     uint32
+	HashCount      uint32
+	StateLength    uint32
+}
+
+// Records input while a movie is being made
+type movieRecorder struct {
+	movie *Movie
+	// Button states set since the last frame was run
+	pending []ButtonState
+}
+
+// Feeds input to the console while a movie is being played back
+type moviePlayer struct {
+	movie *Movie
+	// Index of the next frame to be played
+	frame int
+	// First frame at which the console state did not match the movie, -1 if it has always matched
+	desyncFrame int
+	// Set while the console runs a frame of the movie
+	inFrame bool
+}
+
+// StartRecording begins recording joypad input into a new movie, stopping any recording or playback in progress.
+// If fromState is set the movie starts from the current console state, which is embedded in the movie.
+// Otherwise the movie starts from power-on, and recording must begin before the first frame has been run.
+// Rewinding while recording removes the rewound frames from the movie, but loading a state is not recorded
+// and playback will desync from that point
+func (gb *Gameboy) StartRecording(fromState bool) error {
+	if !fromState && gb.started {
+		return errors.New("console has already run, a power-on movie must be recorded before the first frame")
+	}
+	movie := &Movie{
+		Title:          gb.memory.cartridge.Title(),
+		GlobalChecksum: gb.memory.cartridge.GlobalChecksum(),
+		HashInterval:   DefaultMovieHashInterval,
+	}
+
+	state, err := gb.snapshotBytes()
+	if err != nil {
+		return err
+	}
+	if fromState {
+		movie.StartState = state
+	}
+	movie.Hashes = append(movie.Hashes, crc32.ChecksumIEEE(state))
+
+	gb.playback = nil
+	gb.recording = &movieRecorder{movie: movie}
+	return nil
+}
+
+// StopRecording ends the recording in progress and returns the movie, or nil if nothing was being recorded
+func (gb *Gameboy) StopRecording() *Movie {
+	if gb.recording == nil {
+		return nil
+	}
+	movie := gb.recording.movie
+	gb.recording = nil
+	return movie
+}
+
+// PlayMovie starts playing back a movie, stopping any recording or playback in progress.
+// Movies starting from a save state restore that state first, movies starting from power-on must be
+// played on a console which has not yet run any frames.
+// While playing, button states passed to SetButtonStates are ignored
+func (gb *Gameboy) PlayMovie(movie *Movie) error {
+	if movie.Title != gb.memory.cartridge.Title() || movie.GlobalChecksum != gb.memory.cartridge.GlobalChecksum() {
+		return fmt.Errorf("movie is for %q (checksum %04X), but %q (checksum %04X) is loaded",
+			movie.Title, movie.GlobalChecksum, gb.memory.cartridge.Title(), gb.memory.cartridge.GlobalChecksum())
+	}
+	if movie.HashInterval < 1 {
+		return fmt.Errorf("invalid movie hash interval %d", movie.HashInterval)
+	}
+	if movie.StartState != nil {
+		if err := gb.Restore(bytes.NewReader(movie.StartState)); err != nil {
+			return fmt.Errorf("unable to restore movie start state: %v", err)
+		}
+	}
+
+	gb.recording = nil
+	gb.playback = &moviePlayer{movie: movie, desyncFrame: -1}
+	// Check the starting point, for power-on movies this catches differences in boot ROM or battery RAM
+	gb.playback.checkHash(gb)
+	return nil
+}
+
+// StopMovie ends movie playback, returning joypad control to SetButtonStates
+func (gb *Gameboy) StopMovie() {
+	gb.playback = nil
+}
+
+// MoviePlaying returns whether a movie is being played back and has frames remaining
+func (gb *Gameboy) MoviePlaying() bool {
+	return gb.playback != nil && gb.playback.frame < len(gb.playback.movie.Frames)
+}
+
+// MovieDesyncFrame returns the first frame of movie playback where the console state did not match the recording,
+// ok is false if no desync has been detected so far
+func (gb *Gameboy) MovieDesyncFrame() (frame int, ok bool) {
+	if gb.playback == nil || gb.playback.desyncFrame < 0 {
+		return 0, false
+	}
+	return gb.playback.desyncFrame, true
+}
+
+// Called by SetButtonStates to record a change in the joypad state
+func (r *movieRecorder) buttonsChanged(state ButtonState) {
+	if len(r.pending) == 0xFF {
+		// The file stores at most 255 changes per frame, only the state at the end of the frame is kept
+		// after that which can differ from the original if more presses happen between frames
+		r.pending[len(r.pending)-1] = state
+		return
+	}
+	r.pending = append(r.pending, state)
+}
+
+// Called at the start of each frame to record or play back input
+func (gb *Gameboy) movieFrameStarted() {
+	if gb.recording != nil {
+		gb.recording.movie.Frames = append(gb.recording.movie.Frames, gb.recording.pending)
+		gb.recording.pending = nil
+	}
+	if gb.MoviePlaying() {
+		for _, state := range gb.playback.movie.Frames[gb.playback.frame] {
+			gb.setButtonStates(&state)
+		}
+		gb.playback.frame++
+		gb.playback.inFrame = true
+	}
+}
+
+// Called at the end of each frame to take or check state hashes
+func (gb *Gameboy) movieFrameCompleted() {
+	if gb.recording != nil {
+		movie := gb.recording.movie
+		if len(movie.Frames)%movie.HashInterval == 0 {
+			state, err := gb.snapshotBytes()
+			if err != nil {
+				// Snapshots are written to memory, so this should never happen
+				panic("unable to take movie state hash: " + err.Error())
+			}
+			movie.Hashes = append(movie.Hashes, crc32.ChecksumIEEE(state))
+		}
+	}
+	if gb.playback != nil && gb.playback.inFrame {
+		gb.playback.inFrame = false
+		if gb.playback.frame%gb.playback.movie.HashInterval == 0 {
+			gb.playback.checkHash(gb)
+		}
+	}
+}
+
+// Called after the console has been rewound by a number of frames
+func (gb *Gameboy) movieRewound(frames int) {
+	if gb.recording != nil {
+		movie := gb.recording.movie
+		length := len(movie.Frames) - frames
+		if length < 0 {
+			// Rewound to before the movie started, it can no longer be played back from its start
+			gb.recording = nil
+			return
+		}
+		movie.Frames = movie.Frames[:length]
+		movie.Hashes = movie.Hashes[:length/movie.HashInterval+1]
+		gb.recording.pending = nil
+	}
+	if gb.playback != nil {
+		gb.playback.frame -= frames
+		if gb.playback.frame < 0 {
+			gb.playback = nil
+		}
+	}
+}
+
+// Compare the console state against the movie's hash for the current frame, if it has one
+func (p *moviePlayer) checkHash(gb *Gameboy) {
+	index := p.frame / p.movie.HashInterval
+	if p.desyncFrame >= 0 || index >= len(p.movie.Hashes) {
+		return
+	}
+	state, err := gb.snapshotBytes()
+	if err != nil {
+		panic("unable to take movie state hash: " + err.Error())
+	}
+	if crc32.ChecksumIEEE(state) != p.movie.Hashes[index] {
+		p.desyncFrame = p.frame
+	}
+}
+
+// Save writes the movie to w in the movie file format
+func (m *Movie) Save(w io.Writer) error {
+	header := movieHeader{
+		Magic:          movieMagic,
+		Version:        MovieVersion,
+		StateVersion:   SaveStateVersion,
+		GlobalChecksum: m.GlobalChecksum,
+		HashInterval:   uint32(m.HashInterval),
+		FrameCount:     uint32(len(m.Frames)),
+		HashCount:      uint32(len(m.Hashes)),
+		StateLength:    uint32(len(m.StartState)),
+	}
+	copy(header.Title[:], m.Title)
+
+	if err := snapshot.Write(w, &header, m.StartState); err != nil {
+		return err
+	}
+	for _, frame := range m.Frames {
+		encoded := []uint8{uint8(len(frame))}
+		for _, state := range frame {
+			encoded = append(encoded, state.pressedMask())
+		}
+		if _, err := w.Write(encoded); err != nil {
+			return err
+		}
+	}
+	return snapshot.Write(w, m.Hashes)
+}
+
+// LoadMovie reads a movie written by Movie.Save
+func LoadMovie(r io.Reader) (*Movie, error) {
+	var header movieHeader
+	if err := snapshot.Read(r, &header); err != nil {
+		return nil, fmt.Errorf("unable to read movie header: %v", err)
+	}
+
+	if header.Magic != movieMagic {
+		return nil, errors.New("not a movie file")
+	}
+	if header.Version != MovieVersion {
+		return nil, fmt.Errorf("movie format version %d is not supported (current version is %d)",
+			header.Version, MovieVersion)
+	}
+	if header.StateVersion != SaveStateVersion {
+		// The start state and hashes depend on the layout of the console snapshot
+		return nil, fmt.Errorf("movie was recorded with save state version %d and cannot be played with version %d",
+			header.StateVersion, SaveStateVersion)
+	}
+	if header.HashInterval == 0 {
+		return nil, errors.New("movie hash interval must be at least 1")
+	}
+	if header.FrameCount > maxMovieFrames || header.HashCount > maxMovieFrames || header.StateLength > maxMovieState {
+		return nil, errors.New("movie is too large")
+	}
+
+	movie := &Movie{
+		Title:          string(bytes.TrimRight(header.Title[:], "\x00")),
+		GlobalChecksum: header.GlobalChecksum,
+		HashInterval:   int(header.HashInterval),
+		Frames:         make([][]ButtonState, header.FrameCount),
+		Hashes:         make([]uint32, header.HashCount),
+	}
+	if header.StateLength > 0 {
+		movie.StartState = make([]byte, header.StateLength)
+		if _, err := io.ReadFull(r, movie.StartState); err != nil {
+			return nil, fmt.Errorf("unable to read movie start state: %v", err)
+		}
+	}
+
+	for i := range movie.Frames {
+		var count [1]uint8
+		if _, err := io.ReadFull(r, count[:]); err != nil {
+			return nil, fmt.Errorf("unable to read movie frame %d: %v", i, err)
+		}
+		if count[0] == 0 {
+			continue
+		}
+		masks := make([]uint8, count[0])
+		if _, err := io.ReadFull(r, masks); err != nil {
+			return nil, fmt.Errorf("unable to read movie frame %d: %v", i, err)
+		}
+		for _, mask := range masks {
+			movie.Frames[i] = append(movie.Frames[i], buttonStateFromMask(mask))
+		}
+	}
+
+	if err := snapshot.Read(r, movie.Hashes); err != nil {
+		return nil, fmt.Errorf("unable to read movie hashes: %v", err)
+	}
+	return movie, nil
+}
@@ -0,0 +1,225 @@
+package gameboy
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/cbott/GoEmulate/cartridges"
+)
+
+// Program which reads the joypad directions and keeps a running total of the values read at C001
+var joypadProgram = []uint8{
+	0x3E, 0x20, // LD A,20
+	0xE0, 0x00, // LDH (P1),A
+	0xF0, 0x00, // LDH A,(P1)
+	0x21, 0x01, 0xC0, // LD HL,C001
+	0x86,       // ADD A,(HL)
+	0x77,       // LD (HL),A
+	0x18, 0xF3, // JR -13
+}
+
+// Press a different direction each few frames
+func testInput(frame int) ButtonState {
+	switch (frame / 7) % 4 {
+	case 0:
+		return ButtonState{BtnRight: true}
+	case 1:
+		return ButtonState{BtnLeft: true, BtnUp: true}
+	case 2:
+		return ButtonState{}
+	default:
+		return ButtonState{BtnDown: true}
+	}
+}
+
+// Record a movie of frames with testInput, returning it along with the final state
+func recordTestMovie(t *testing.T, gb *Gameboy, fromState bool, frames int) (*Movie, []byte) {
+	if err := gb.StartRecording(fromState); err != nil {
+		t.Fatalf("Unable to start recording: %v", err)
+	}
+	for i := 0; i < frames; i++ {
+		buttons := testInput(i)
+		gb.SetButtonStates(&buttons)
+		gb.RunNextFrame()
+	}
+	return gb.StopRecording(), encodedState(t, gb)
+}
+
+// Play a movie to the end, ignoring the given input
+func playTestMovie(t *testing.T, gb *Gameboy, movie *Movie) {
+	if err := gb.PlayMovie(movie); err != nil {
+		t.Fatalf("Unable to play movie: %v", err)
+	}
+	for gb.MoviePlaying() {
+		gb.SetButtonStates(&ButtonState{BtnA: true, BtnUp: true})
+		gb.RunNextFrame()
+	}
+}
+
+func TestMoviePlaybackFromPowerOn(t *testing.T) {
+	movie, final := recordTestMovie(t, newTestGameBoy("JOYPAD", joypadProgram), false, 200)
+	if movie.StartState != nil {
+		t.Fatalf("Expected power-on movie to have no start state")
+	}
+
+	var file bytes.Buffer
+	if err := movie.Save(&file); err != nil {
+		t.Fatalf("Unable to save movie: %v", err)
+	}
+	loaded, err := LoadMovie(&file)
+	if err != nil {
+		t.Fatalf("Unable to load movie: %v", err)
+	}
+
+	gb := newTestGameBoy("JOYPAD", joypadProgram)
+	playTestMovie(t, gb, loaded)
+	if frame, ok := gb.MovieDesyncFrame(); ok {
+		t.Fatalf("Unexpected desync at frame %d", frame)
+	}
+	if !bytes.Equal(encodedState(t, gb), final) {
+		t.Fatalf("State after playback does not match recording")
+	}
+}
+
+func TestMoviePlaybackFromState(t *testing.T) {
+	gb := newTestGameBoy("JOYPAD", joypadProgram)
+	for i := 0; i < 10; i++ {
+		gb.RunNextFrame()
+	}
+	movie, final := recordTestMovie(t, gb, true, 100)
+	if movie.StartState == nil {
+		t.Fatalf("Expected movie to contain the start state")
+	}
+
+	// The start state is restored over whatever the console was doing
+	playTestMovie(t, gb, movie)
+	if frame, ok := gb.MovieDesyncFrame(); ok {
+		t.Fatalf("Unexpected desync at frame %d", frame)
+	}
+	if !bytes.Equal(encodedState(t, gb), final) {
+		t.Fatalf("State after playback does not match recording")
+	}
+}
+
+// Program which keeps latching the HuC3 clock and stores the low nibble of its minute counter at C000
+var huc3ClockProgram = []uint8{
+	0x3E, 0x0B, 0xEA, 0x00, 0x00, // LD A,0B; LD (0000),A  command mode
+	0x3E, 0x60, 0xEA, 0x00, 0xA0, // LD A,60; LD (A000),A  latch the clock
+	0x3E, 0x40, 0xEA, 0x00, 0xA0, // LD A,40; LD (A000),A  address 00
+	0x3E, 0x50, 0xEA, 0x00, 0xA0, // LD A,50; LD (A000),A
+	0x3E, 0x10, 0xEA, 0x00, 0xA0, // LD A,10; LD (A000),A  read
+	0x3E, 0x0C, 0xEA, 0x00, 0x00, // LD A,0C; LD (0000),A  response mode
+	0xFA, 0x00, 0xA0, // LD A,(A000)
+	0xEA, 0x00, 0xC0, // LD (C000),A
+	0x3E, 0x0B, 0xEA, 0x00, 0x00, // LD A,0B; LD (0000),A  command mode
+	0x18, 0xDA, // JR -38
+}
+
+// Create a Game Boy running a HuC3 cartridge with the given program
+func newTestHuC3GameBoy(t *testing.T, program []uint8) (*Gameboy, *cartridges.HuC3Cartridge) {
+	rom := makeTestROM("HUC3", program)
+	rom[cartridges.CartridgeTypeAddress] = 0xFE
+	cartridge := cartridges.NewHuC3Cartridge(filepath.Join(t.TempDir(), "huc3.gb"), rom)
+	gb := NewGameBoy(true, false)
+	gb.LoadCartridge(cartridge)
+	return gb, cartridge
+}
+
+func TestMoviePlaybackWithHuC3Clock(t *testing.T) {
+	gb, cartridge := newTestHuC3GameBoy(t, huc3ClockProgram)
+	// Start half a second before the minute changes, which happens during the movie
+	cartridge.RunCycles(60*CpuSpeed - 30*CyclesPerFrame)
+	gb.RunNextFrame()
+	if value := gb.ReadMemory(0xC000); value != 0x10 {
+		t.Fatalf("Expected minute 0 read from the clock, got %02X", value)
+	}
+	movie, final := recordTestMovie(t, gb, true, 60)
+	if value := gb.ReadMemory(0xC000); value != 0x11 {
+		t.Fatalf("Expected minute 1 read from the clock, got %02X", value)
+	}
+
+	// The clock runs from the start state on a cartridge which has just been powered on
+	fresh, _ := newTestHuC3GameBoy(t, huc3ClockProgram)
+	playTestMovie(t, fresh, movie)
+	if frame, ok := fresh.MovieDesyncFrame(); ok {
+		t.Fatalf("Unexpected desync at frame %d", frame)
+	}
+	if !bytes.Equal(encodedState(t, fresh), final) {
+		t.Fatalf("State after playback does not match recording")
+	}
+}
+
+func TestMovieReportsDesync(t *testing.T) {
+	movie, _ := recordTestMovie(t, newTestGameBoy("JOYPAD", joypadProgram), false, 200)
+
+	// Change the input on frame 70, which should be caught by the hash after frame 120
+	movie.Frames[70] = []ButtonState{{BtnUp: true}}
+	gb := newTestGameBoy("JOYPAD", joypadProgram)
+	playTestMovie(t, gb, movie)
+	frame, ok := gb.MovieDesyncFrame()
+	if !ok || frame != 2*DefaultMovieHashInterval {
+		t.Fatalf("Expected desync at frame %d, got %d (%v)", 2*DefaultMovieHashInterval, frame, ok)
+	}
+
+	// Starting from a different power-on state is caught immediately
+	movie, _ = recordTestMovie(t, newTestGameBoy("JOYPAD", joypadProgram), false, 10)
+	gb = newTestGameBoy("JOYPAD", joypadProgram)
+	gb.RunNextFrame()
+	playTestMovie(t, gb, movie)
+	if frame, ok := gb.MovieDesyncFrame(); !ok || frame != 0 {
+		t.Fatalf("Expected desync at frame 0, got %d (%v)", frame, ok)
+	}
+}
+
+func TestMovieRecordingWithRewind(t *testing.T) {
+	gb := newTestGameBoy("JOYPAD", joypadProgram)
+	gb.EnableRewind(5)
+	if err := gb.StartRecording(false); err != nil {
+		t.Fatalf("Unable to start recording: %v", err)
+	}
+	for i := 0; i < 150; i++ {
+		buttons := testInput(i)
+		gb.SetButtonStates(&buttons)
+		gb.RunNextFrame()
+	}
+	// Rewind and play a different route
+	gb.Rewind(50)
+	for i := 0; i < 80; i++ {
+		buttons := testInput(i * 3)
+		gb.SetButtonStates(&buttons)
+		gb.RunNextFrame()
+	}
+	movie := gb.StopRecording()
+	final := encodedState(t, gb)
+	if len(movie.Frames) != 180 {
+		t.Fatalf("Expected 180 frames in movie, got %d", len(movie.Frames))
+	}
+
+	fresh := newTestGameBoy("JOYPAD", joypadProgram)
+	playTestMovie(t, fresh, movie)
+	if frame, ok := fresh.MovieDesyncFrame(); ok {
+		t.Fatalf("Unexpected desync at frame %d", frame)
+	}
+	if !bytes.Equal(encodedState(t, fresh), final) {
+		t.Fatalf("State after playback does not match recording")
+	}
+}
+
+func TestPowerOnRecordingAfterStart(t *testing.T) {
+	gb := newTestGameBoy("JOYPAD", joypadProgram)
+	gb.RunNextFrame()
+	if err := gb.StartRecording(false); err == nil {
+		t.Fatalf("Expected power-on recording to be rejected after running a frame")
+	}
+	if err := gb.StartRecording(true); err != nil {
+		t.Fatalf("Unable to start recording from the current state: %v", err)
+	}
+}
+
+func TestLoadMovieRejectsOtherGame(t *testing.T) {
+	movie, _ := recordTestMovie(t, newTestGameBoy("JOYPAD", joypadProgram), false, 10)
+	if err := newTestGameBoy("OTHER", joypadProgram).PlayMovie(movie); err == nil {
+		t.Fatalf("Expected movie for another game to be rejected")
+	}
+}
@@ -0,0 +1,270 @@
+package gameboy
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+)
+
+/*
+OAM Sprite Entries
+
+Each of the 40 entries in OAM (0xFE00-0xFE9F) is 4 bytes:
+  0   Y position + 16
+  1   X position + 8
+  2   Tile number, always from the 0x8000 tile data
+  3   Flags (SpriteFlag*)
+        7   priority (0=above background, 1=behind background colors 1-3)
+        6   Y flip
+        5   X flip
+        4   palette (0=OBP0, 1=OBP1)
+
+Sprites are 8x8, or 8x16 using two consecutive tiles (LCDC_obj_size). Only the first MaxSpritesPerLine
+entries in OAM order which cover a line are drawn on it, the rest are dropped.
+*/
+
+// Size in bytes of an OAM entry
+const OAMEntrySize = 4
+
+// Sprite is a decoded OAM entry
+type Sprite struct {
+	// Position in OAM, 0-39, and the address of the entry
+	Index   int    `json:"index"`
+	Address uint16 `json:"address"`
+	// Values as stored in OAM
+	Y     uint8 `json:"y"`
+	X     uint8 `json:"x"`
+	Tile  uint8 `json:"tile"`
+	Flags uint8 `json:"flags"`
+	// Screen position of the top left corner, which is off screen for negative values
+	ScreenX int `json:"screenX"`
+	ScreenY int `json:"screenY"`
+	// Height of the sprite in pixels, 8 or 16
+	Height int `json:"height"`
+	// Set if the sprite is drawn behind background colors 1-3
+	BehindBackground bool        `json:"behindBackground"`
+	FlipX            bool        `json:"flipX"`
+	FlipY            bool        `json:"flipY"`
+	Palette          TilePalette `json:"palette"`
+}
+
+func (s Sprite) String() string {
+	flags := []byte("----")
+	for i, set := range []bool{s.BehindBackground, s.FlipY, s.FlipX} {
+		if set {
+			flags[i] = "PYX"[i]
+		}
+	}
+	flags[3] = '0'
+	if s.Palette == TilePaletteOBP1 {
+		flags[3] = '1'
+	}
+	return fmt.Sprintf("sprite %02d at %04X: X=%02X Y=%02X (%d,%d) tile %02X flags %02X [%s]",
+		s.Index, s.Address, s.X, s.Y, s.ScreenX, s.ScreenY, s.Tile, s.Flags, flags)
+}
+
+// CoversLine returns whether any row of the sprite is on a screen line
+func (s Sprite) CoversLine(line int) bool {
+	return line >= s.ScreenY && line < s.ScreenY+s.Height
+}
+
+// Current sprite height from LCDC
+func (gb *Gameboy) spriteHeight() uint8 {
+	if gb.memory.get(LCDC)&LCDC_obj_size != 0 {
+		return 16 // sprites are 8x16
+	}
+	return 8 // sprites are 8x8
+}
+
+// Sprites returns every OAM entry in OAM order
+func (gb *Gameboy) Sprites() []Sprite {
+	height := int(gb.spriteHeight())
+	sprites := make([]Sprite, MaxSprites)
+	for i := range sprites {
+		address := OAMRamAddressStart + uint16(i)*OAMEntrySize
+		s := Sprite{
+			Index:   i,
+			Address: address,
+			Y:       gb.memory.read(address),
+			X:       gb.memory.read(address + 1),
+			Tile:    gb.memory.read(address + 2),
+			Flags:   gb.memory.read(address + 3),
+			Height:  height,
+			Palette: TilePaletteOBP0,
+		}
+		s.ScreenX = int(s.X) - 8
+		s.ScreenY = int(s.Y) - 16
+		s.BehindBackground = s.Flags&SpriteFlagPriority != 0
+		s.FlipX = s.Flags&SpriteFlagFlipX != 0
+		s.FlipY = s.Flags&SpriteFlagFlipY != 0
+		if s.Flags&SpriteFlagPalette != 0 {
+			s.Palette = TilePaletteOBP1
+		}
+		sprites[i] = s
+	}
+	return sprites
+}
+
+// SpriteLine lists the sprites covering a screen line, by OAM index
+type SpriteLine struct {
+	Line int `json:"line"`
+	// The first MaxSpritesPerLine sprites on the line, which are drawn
+	Drawn []int `json:"drawn"`
+	// Any further sprites, which are not drawn on this line
+	Dropped []int `json:"dropped"`
+}
+
+// SpritesOnLine returns which sprites cover a screen line and which of those are dropped by the
+// MaxSpritesPerLine limit
+func (gb *Gameboy) SpritesOnLine(line int) SpriteLine {
+	return spritesOnLine(gb.Sprites(), line)
+}
+
+// Select the sprites covering a line from decoded OAM, used by the PPU so the viewer always matches what is drawn
+func spritesOnLine(sprites []Sprite, line int) SpriteLine {
+	result := SpriteLine{Line: line, Drawn: []int{}, Dropped: []int{}}
+	for _, s := range sprites {
+		if !s.CoversLine(line) {
+			continue
+		}
+		if len(result.Drawn) < MaxSpritesPerLine {
+			result.Drawn = append(result.Drawn, s.Index)
+		} else {
+			result.Dropped = append(result.Dropped, s.Index)
+		}
+	}
+	return result
+}
+
+// SpriteImage draws a sprite with its flips and palette applied, color 0 is transparent
+func (gb *Gameboy) SpriteImage(s Sprite) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 8, s.Height))
+	gb.drawSprite(img, s, 0, 0)
+	return img
+}
+
+// Tile data for a row of a sprite, counted down from its top on screen, with Y flip applied
+func (gb *Gameboy) spriteRow(s Sprite, row int) (lineLSB uint8, lineMSB uint8) {
+	if s.FlipY {
+		row = s.Height - row - 1
+	}
+	address := TileDataAddressLow + uint16(s.Tile)*TileBytes + uint16(row)*2
+	return gb.memory.get(address), gb.memory.get(address + 1)
+}
+
+// Color index 0-3 of a pixel in a row of the sprite from spriteRow, with X flip applied
+func (s Sprite) pixel(lineLSB uint8, lineMSB uint8, column int) uint8 {
+	if s.FlipX {
+		column = 7 - column
+	}
+	return tilePixel(lineLSB, lineMSB, uint8(column))
+}
+
+// Draw a sprite with its top left corner at x, y, leaving transparent pixels unchanged
+func (gb *Gameboy) drawSprite(img *image.RGBA, s Sprite, x int, y int) {
+	palette := gb.memory.read(uint16(s.Palette))
+	for row := 0; row < s.Height; row++ {
+		lineLSB, lineMSB := gb.spriteRow(s, row)
+		for column := 0; column < 8; column++ {
+			pixelColor := s.pixel(lineLSB, lineMSB, column)
+			if pixelColor == 0 {
+				continue
+			}
+			red, green, blue := getColorFromPalette(pixelColor, palette)
+			img.SetRGBA(x+column, y+row, color.RGBA{R: red, G: green, B: blue, A: 0xFF})
+		}
+	}
+}
+
+// Layout of the OAM sheet, each sprite is drawn in a cell with a border showing whether it is on the selected line
+const (
+	OAMSheetColumns    = 8
+	OAMSheetRows       = MaxSprites / OAMSheetColumns
+	OAMSheetCellWidth  = 16
+	OAMSheetCellHeight = 24
+	OAMSheetWidth      = OAMSheetColumns * OAMSheetCellWidth
+	OAMSheetHeight     = OAMSheetRows * OAMSheetCellHeight
+)
+
+// Colors used in the OAM sheet
+var (
+	OAMSheetBackground   = color.RGBA{R: 0x40, G: 0x40, B: 0x60, A: 0xFF}
+	OAMSheetDrawnColor   = color.RGBA{R: 0x00, G: 0xC0, B: 0x00, A: 0xFF}
+	OAMSheetDroppedColor = color.RGBA{R: 0xFF, G: 0x00, B: 0x00, A: 0xFF}
+)
+
+// OAMSheet draws every sprite in OAM order, 8 to a row. Sprites drawn on the given screen line are outlined in
+// OAMSheetDrawnColor and those dropped from it by the MaxSpritesPerLine limit in OAMSheetDroppedColor
+func (gb *Gameboy) OAMSheet(line int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, OAMSheetWidth, OAMSheetHeight))
+	for x := 0; x < OAMSheetWidth; x++ {
+		for y := 0; y < OAMSheetHeight; y++ {
+			img.SetRGBA(x, y, OAMSheetBackground)
+		}
+	}
+
+	sprites := gb.Sprites()
+	onLine := spritesOnLine(sprites, line)
+	outline := func(index int, c color.RGBA) {
+		left := (index % OAMSheetColumns) * OAMSheetCellWidth
+		top := (index / OAMSheetColumns) * OAMSheetCellHeight
+		for x := left; x < left+OAMSheetCellWidth; x++ {
+			img.SetRGBA(x, top, c)
+			img.SetRGBA(x, top+OAMSheetCellHeight-1, c)
+		}
+		for y := top; y < top+OAMSheetCellHeight; y++ {
+			img.SetRGBA(left, y, c)
+			img.SetRGBA(left+OAMSheetCellWidth-1, y, c)
+		}
+	}
+	for _, index := range onLine.Drawn {
+		outline(index, OAMSheetDrawnColor)
+	}
+	for _, index := range onLine.Dropped {
+		outline(index, OAMSheetDroppedColor)
+	}
+
+	for _, s := range sprites {
+		// Center the sprite in its cell
+		x := (s.Index%OAMSheetColumns)*OAMSheetCellWidth + (OAMSheetCellWidth-8)/2
+		y := (s.Index/OAMSheetColumns)*OAMSheetCellHeight + (OAMSheetCellHeight-s.Height)/2
+		gb.drawSprite(img, s, x, y)
+	}
+	return img
+}
+
+// OAMSheetSpriteAt returns the OAM index of the sprite drawn under a pixel of the OAM sheet,
+// false if the pixel is outside the sheet
+func OAMSheetSpriteAt(x, y int) (int, bool) {
+	if x < 0 || y < 0 || x >= OAMSheetWidth || y >= OAMSheetHeight {
+		return 0, false
+	}
+	return (y/OAMSheetCellHeight)*OAMSheetColumns + x/OAMSheetCellWidth, true
+}
+
+// OAMDump holds every OAM entry along with the screen lines where sprites are dropped
+type OAMDump struct {
+	Sprites []Sprite `json:"sprites"`
+	// Screen lines covered by more than MaxSpritesPerLine sprites
+	Overflows []SpriteLine `json:"overflows"`
+}
+
+// OAMDump decodes OAM, finding every line with too many sprites
+func (gb *Gameboy) OAMDump() OAMDump {
+	dump := OAMDump{Sprites: gb.Sprites(), Overflows: []SpriteLine{}}
+	for line := 0; line < ScreenHeight; line++ {
+		if onLine := spritesOnLine(dump.Sprites, line); len(onLine.Dropped) > 0 {
+			dump.Overflows = append(dump.Overflows, onLine)
+		}
+	}
+	return dump
+}
+
+// WriteOAMJSON writes the OAM dump as indented JSON
+func (gb *Gameboy) WriteOAMJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(gb.OAMDump())
+}
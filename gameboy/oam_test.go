@@ -0,0 +1,126 @@
+package gameboy
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// Set an OAM entry
+func writeSprite(gb *Gameboy, index int, x uint8, y uint8, tile uint8, flags uint8) {
+	address := OAMRamAddressStart + uint16(index)*OAMEntrySize
+	gb.memory.write(address, y)
+	gb.memory.write(address+1, x)
+	gb.memory.write(address+2, tile)
+	gb.memory.write(address+3, flags)
+}
+
+func TestSprites(t *testing.T) {
+	gb := newTestGameBoy("OAM", nil)
+	writeSprite(gb, 3, 8, 16, 0x42, SpriteFlagPriority|SpriteFlagFlipX|SpriteFlagPalette)
+
+	s := gb.Sprites()[3]
+	expected := Sprite{
+		Index: 3, Address: 0xFE0C, Y: 16, X: 8, Tile: 0x42, Flags: 0xB0, ScreenX: 0, ScreenY: 0, Height: 8,
+		BehindBackground: true, FlipX: true, Palette: TilePaletteOBP1,
+	}
+	if s != expected {
+		t.Errorf("Decoded %+v, expected %+v", s, expected)
+	}
+	if text := s.String(); text != "sprite 03 at FE0C: X=08 Y=10 (0,0) tile 42 flags B0 [P-X1]" {
+		t.Errorf("Unexpected sprite description %q", text)
+	}
+
+	gb.memory.write(LCDC, LCDC_display_enable|LCDC_obj_size)
+	if s := gb.Sprites()[3]; s.Height != 16 || !s.CoversLine(15) || s.CoversLine(16) {
+		t.Errorf("Expected an 8x16 sprite covering lines 0-15, got %+v", s)
+	}
+}
+
+func TestSpritesOnLine(t *testing.T) {
+	gb := newTestGameBoy("OAM", nil)
+	// Sprites 0-11 cover lines 20-27 except sprite 5 which is lower, leaving 11 on line 20
+	for i := 0; i < 12; i++ {
+		writeSprite(gb, i, uint8(8+i*8), 16+20, 0, 0)
+	}
+	writeSprite(gb, 5, 8, 16+40, 0, 0)
+
+	onLine := gb.SpritesOnLine(20)
+	if !reflect.DeepEqual(onLine.Drawn, []int{0, 1, 2, 3, 4, 6, 7, 8, 9, 10}) || !reflect.DeepEqual(onLine.Dropped, []int{11}) {
+		t.Errorf("Line 20 has drawn %v dropped %v", onLine.Drawn, onLine.Dropped)
+	}
+	onLine = gb.SpritesOnLine(40)
+	if !reflect.DeepEqual(onLine.Drawn, []int{5}) || len(onLine.Dropped) != 0 {
+		t.Errorf("Line 40 has drawn %v dropped %v", onLine.Drawn, onLine.Dropped)
+	}
+
+	sheet := gb.OAMSheet(20)
+	if got := sheet.RGBAAt(3*OAMSheetCellWidth, OAMSheetCellHeight); got != OAMSheetDroppedColor {
+		t.Errorf("Dropped sprite 11 not outlined, got %v", got)
+	}
+	if got := sheet.RGBAAt(0, 0); got != OAMSheetDrawnColor {
+		t.Errorf("Drawn sprite 0 not outlined, got %v", got)
+	}
+	if got := sheet.RGBAAt(5*OAMSheetCellWidth, 0); got != OAMSheetBackground {
+		t.Errorf("Sprite 5 outlined although it is not on the line, got %v", got)
+	}
+	if index, ok := OAMSheetSpriteAt(2*OAMSheetCellWidth+3, OAMSheetCellHeight+5); !ok || index != 10 {
+		t.Errorf("OAMSheetSpriteAt returned %d, %v, expected sprite 10", index, ok)
+	}
+
+	// Only lines 20-27 have too many sprites
+	var buf bytes.Buffer
+	if err := gb.WriteOAMJSON(&buf); err != nil {
+		t.Fatalf("Unable to write JSON: %v", err)
+	}
+	var dump struct {
+		Sprites []struct {
+			Index   int    `json:"index"`
+			Palette string `json:"palette"`
+		} `json:"sprites"`
+		Overflows []SpriteLine `json:"overflows"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &dump); err != nil {
+		t.Fatalf("Unable to read JSON: %v", err)
+	}
+	if len(dump.Sprites) != MaxSprites || dump.Sprites[39].Index != 39 || dump.Sprites[0].Palette != "OBP0" {
+		t.Errorf("Unexpected sprites in dump: %+v", dump.Sprites)
+	}
+	if len(dump.Overflows) != 8 || dump.Overflows[0].Line != 20 || !reflect.DeepEqual(dump.Overflows[7].Dropped, []int{11}) {
+		t.Errorf("Unexpected overflows in dump: %+v", dump.Overflows)
+	}
+}
+
+func TestSpriteImage(t *testing.T) {
+	gb := newTestGameBoy("OAM", nil)
+	// Tile 2 has color 3 in its top left pixel and color 1 in its bottom right, all else transparent
+	gb.memory.write(0x8020, 0x80)
+	gb.memory.write(0x8021, 0x80)
+	gb.memory.write(0x802E, 0x01)
+	gb.memory.write(OBP0, 0b11100100)
+
+	testcases := []struct {
+		flags          uint8
+		darkX, darkY   int
+		lightX, lightY int
+	}{
+		{0, 0, 0, 7, 7},
+		{SpriteFlagFlipX, 7, 0, 0, 7},
+		{SpriteFlagFlipY, 0, 7, 7, 0},
+		{SpriteFlagFlipX | SpriteFlagFlipY, 7, 7, 0, 0},
+	}
+	for _, tc := range testcases {
+		writeSprite(gb, 0, 8, 16, 2, tc.flags)
+		img := gb.SpriteImage(gb.Sprites()[0])
+		if got := img.RGBAAt(tc.darkX, tc.darkY); got.R != 0 || got.A != 0xFF {
+			t.Errorf("Flags %02X: expected black at %d,%d, got %v", tc.flags, tc.darkX, tc.darkY, got)
+		}
+		if got := img.RGBAAt(tc.lightX, tc.lightY); got.R != 170 || got.A != 0xFF {
+			t.Errorf("Flags %02X: expected light gray at %d,%d, got %v", tc.flags, tc.lightX, tc.lightY, got)
+		}
+		if got := img.RGBAAt(3, 3); got.A != 0 {
+			t.Errorf("Flags %02X: expected color 0 to be transparent, got %v", tc.flags, got)
+		}
+	}
+}
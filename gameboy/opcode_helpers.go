@@ -2,15 +2,22 @@ package gameboy
 
 // Returns the number of clock cycles to complete (4MHz cycles)
 func (gb *Gameboy) RunNextOpcode() int {
+	if gb.tracer != nil {
+		gb.tracer.trace(gb)
+	}
+	if gb.debugger != nil && len(gb.debugger.watchpoints) > 0 {
+		return gb.debugger.runWatchedOpcode()
+	}
 	opcode := gb.popPC()
 	return gb.Opcode(opcode) * 4
 }
 
 // Return the 8 bit value in memory at address (PC) and then increment PC
+// Instruction fetches are not data accesses, so do not trigger watchpoints
 func (gb *Gameboy) popPC() uint8 {
 	pc := gb.cpu.getRegister16(regPC)
 	gb.cpu.setRegister16(regPC, pc+1)
-	return gb.memory.get(pc)
+	return gb.memory.read(pc)
 }
 
 // Read the 16 bit value in memory at address (PC, PC+1) and increment PC twice
@@ -128,6 +128,12 @@ const (
 	OAMRamAddressStart  = 0xFE00
 )
 
+// The background and window each use one of two 32x32 tile maps (LCDC_bg_map_select, LCDC_window_map_select)
+const (
+	TileMapAddressLow  = 0x9800
+	TileMapAddressHigh = 0x9C00
+)
+
 const (
 	DisplayModeHBlank        = 0b00
 	DisplayModeVBlank        = 0b01
@@ -240,6 +246,57 @@ func (gb *Gameboy) renderLine(lineNumber uint8) {
 	}
 }
 
+// Start of the tile data used by the background and window
+func tileDataAddress(control uint8) uint16 {
+	if (control & LCDC_tile_data_select) == 0 {
+		// The first half of the BG/Window tiles overlap with the last half of the Sprite tiles
+		return TileDataAddressHigh
+	}
+	// BG/Window tiles and Sprite tiles fully share the same address space
+	return TileDataAddressLow
+}
+
+// Start location in memory for Window tiles
+func windowTileMapAddress(control uint8) uint16 {
+	if (control & LCDC_window_map_select) == 0 {
+		return TileMapAddressLow
+	}
+	return TileMapAddressHigh
+}
+
+// Start location in memory for Background tiles
+func bgTileMapAddress(control uint8) uint16 {
+	if (control & LCDC_bg_map_select) == 0 {
+		return TileMapAddressLow
+	}
+	return TileMapAddressHigh
+}
+
+// Address of a background or window tile, given the tile number from a tile map
+func bgTileAddress(tileDataStartAddress uint16, tileNumber uint8) uint16 {
+	if tileDataStartAddress == TileDataAddressLow {
+		// If the data table is 0x8000-0x8FFF then tile number is 0-255 offset from 0x8000
+		// each tile occupies 16 bytes, 2 bytes per line
+		return tileDataStartAddress + uint16(tileNumber)*16
+	}
+	// If the data table is 0x8800-0x97FF then tile number is -128-127 offset from 0x9000
+	return tileDataStartAddress + uint16((int16(int8(tileNumber))+128)*16)
+}
+
+// Color index 0-3 of the pixel at x, y in the 256x256 pixel area covered by a tile map
+func (gb *Gameboy) tileMapPixel(tileMapStartAddress uint16, tileDataStartAddress uint16, x uint8, y uint8) uint8 {
+	// Find the map entry for this tile in the 32x32 grid to see where in tile data to look
+	tileNumber := gb.memory.get(tileMapStartAddress + uint16(y/8)*32 + uint16(x/8))
+	tileAddress := bgTileAddress(tileDataStartAddress, tileNumber)
+
+	// Each line in the tile is defined by 2 bytes, first byte holds the least significant bit of each pixel,
+	// second byte hold the most significant bit, bit 7 being leftmost, bit 0 rightmost
+	rowInTile := y % 8
+	lineLSB := gb.memory.get(tileAddress + uint16(rowInTile)*2)
+	lineMSB := gb.memory.get(tileAddress + uint16(rowInTile)*2 + 1)
+	return tilePixel(lineLSB, lineMSB, x%8)
+}
+
 func (gb *Gameboy) renderLineTiles(lineNumber uint8) [ScreenWidth]bool {
 	// Render the background and window tiles in a single line
 	// Returns an array with an element for each pixel indicating if Sprites can draw over it
@@ -253,30 +310,9 @@ func (gb *Gameboy) renderLineTiles(lineNumber uint8) [ScreenWidth]bool {
 	// and we are on or below the starting row of the window
 	drawWindow := (control&LCDC_window_enable) != 0 && (lineNumber >= windowY)
 
-	var tileDataStartAddress uint16
-	if (control & LCDC_tile_data_select) == 0 {
-		// The first half of the BG/Window tiles overlap with the last half of the Sprite tiles
-		tileDataStartAddress = TileDataAddressHigh
-	} else {
-		// BG/Window tiles and Sprite tiles fully share the same address space
-		tileDataStartAddress = TileDataAddressLow
-	}
-
-	// Start location in memory for Window tiles
-	var windowTileMapStartAddress uint16
-	if (control & LCDC_window_map_select) == 0 {
-		windowTileMapStartAddress = 0x9800
-	} else {
-		windowTileMapStartAddress = 0x9C00
-	}
-
-	// Start location in memory for Background tiles
-	var bgTileMapStartAddress uint16
-	if (control & LCDC_bg_map_select) == 0 {
-		bgTileMapStartAddress = 0x9800
-	} else {
-		bgTileMapStartAddress = 0x9C00
-	}
+	tileDataStartAddress := tileDataAddress(control)
+	windowTileMapStartAddress := windowTileMapAddress(control)
+	bgTileMapStartAddress := bgTileMapAddress(control)
 
 	var relativeY uint8
 	if drawWindow {
@@ -287,9 +323,6 @@ func (gb *Gameboy) renderLineTiles(lineNumber uint8) [ScreenWidth]bool {
 		relativeY = lineNumber + scrollY
 	}
 
-	// Determine which row of the 32x32 grid this tile is in
-	tileRow := relativeY / 8
-
 	palette := gb.memory.get(BGP)
 
 	// Array with each value representing whether or not the corresponding pixel
@@ -315,37 +348,8 @@ func (gb *Gameboy) renderLineTiles(lineNumber uint8) [ScreenWidth]bool {
 			relativeX = absoluteX + scrollX
 		}
 
-		// Determine which column of the 32x32 grid this tile is in
-		tileCol := relativeX / 8
-
-		// Find the BG or Window map entry for this tile to see where in tile data to look
-		var tileNumber uint8 = gb.memory.get(tileMapForColumn + uint16(tileRow)*32 + uint16(tileCol))
-
-		var tileAddress uint16
-		if tileDataStartAddress == TileDataAddressLow {
-			// If the data table is 0x8000-0x8FFF then tile number is 0-255 offset from 0x8000
-			// each tile occupies 16 bytes, 2 bytes per line
-			tileAddress = tileDataStartAddress + uint16(tileNumber)*16
-		} else {
-			// If the data table is 0x8800-0x97FF then tile number is -128-127 offset from 0x9000
-			tileAddress = tileDataStartAddress + uint16((int16(int8(tileNumber))+128)*16)
-		}
-
-		// Each line in the tile is defined by 2 bytes, first byte holds the least significant bit of each pixel,
-		// second byte hold the most significant bit, bit 7 being leftmost, bit 0 rightmost
-		rowInTile := relativeY % 8
-		lineLSB := gb.memory.get(tileAddress + uint16(rowInTile)*2)
-		lineMSB := gb.memory.get(tileAddress + uint16(rowInTile)*2 + 1)
-
-		columnInTile := relativeX % 8
 		// pixelColor is the 2-bit value that we use to index into palette to get the displayed color
-		var pixelColor uint8 = 0b00
-		if lineLSB&(0b10000000>>columnInTile) != 0 {
-			pixelColor |= 0b01
-		}
-		if lineMSB&(0b10000000>>columnInTile) != 0 {
-			pixelColor |= 0b10
-		}
+		pixelColor := gb.tileMapPixel(tileMapForColumn, tileDataStartAddress, relativeX, relativeY)
 
 		// Keep track of which pixels in this row used palette color 0, as these will be drawn
 		// over by sprites with priority 1
@@ -397,76 +401,30 @@ func getColorFromPalette(colorIndex uint8, palette uint8) (uint8, uint8, uint8)
 }
 
 func (gb *Gameboy) renderLineSprites(lineNumber uint8, bgPriority [ScreenWidth]bool) {
-	// Check sprite sizing
-	var spriteHeight uint8 = 8 // sprites are 8x8
-	if gb.memory.get(LCDC)&LCDC_obj_size != 0 {
-		spriteHeight = 16 // sprites are 8x16
-	}
+	sprites := gb.Sprites()
 
 	// Array to track drawn sprites to ensure those with the lowest X value are drawn on top
-	xCoordsAlreadyDrawn := [ScreenWidth]int16{}
+	xCoordsAlreadyDrawn := [ScreenWidth]int{}
 	for i := 0; i < ScreenWidth; i++ {
 		xCoordsAlreadyDrawn[i] = 255 // arbitrarilly set to something off-screen
 	}
 
-	// Iterate through all sprites in OAM RAM
-	var spritesOnLine uint8
-
-	var spriteNum uint8
-	for spriteNum = 0; spriteNum < MaxSprites; spriteNum++ {
-		if spritesOnLine >= MaxSpritesPerLine {
-			break
-		}
-
-		// Index into OAM RAM
-		var index uint16 = uint16(spriteNum) * 4
-		// Y is offset by 16 (value of 16 puts the sprite fully on the screen)
-		yPos := int16(gb.memory.get(OAMRamAddressStart+index)) - 16
-		if (int16(lineNumber) < yPos) || (int16(lineNumber) >= (yPos + int16(spriteHeight))) {
-			// No part of this sprite is on the current line
-			continue
-		}
-
-		spritesOnLine++
-		// X is offset by 8 (value of 8 puts the sprite fully on the screen)
-		xPos := int16(gb.memory.get(OAMRamAddressStart+index+1)) - 8
-		tileNumber := gb.memory.get(OAMRamAddressStart + index + 2)
-		flags := gb.memory.get(OAMRamAddressStart + index + 3)
-
-		// If the sprite is flipped we need to draw starting with the bottom row instead
-		rowInTile := uint8(int16(lineNumber) - yPos)
-		if flags&SpriteFlagFlipY != 0 {
-			rowInTile = spriteHeight - rowInTile - 1
-		}
-
-		tileAddress := TileDataAddressLow + uint16(tileNumber)*16
-		lineLSB := gb.memory.get(tileAddress + uint16(rowInTile)*2)
-		lineMSB := gb.memory.get(tileAddress + uint16(rowInTile)*2 + 1)
+	// Only the first sprites in OAM order which cover this line are drawn
+	for _, index := range spritesOnLine(sprites, int(lineNumber)).Drawn {
+		s := sprites[index]
+		lineLSB, lineMSB := gb.spriteRow(s, int(lineNumber)-s.ScreenY)
+		palette := gb.memory.get(uint16(s.Palette))
 
 		// Draw pixels to the screen buffer
-		var columnInTile uint8
-
-		for columnInTile = 0; columnInTile < 8; columnInTile++ {
-			pixelX := xPos + int16(columnInTile)
+		for column := 0; column < 8; column++ {
+			pixelX := s.ScreenX + column
 			// If the pixel is off the screen, skip
 			if pixelX < 0 || pixelX >= ScreenWidth {
 				continue
 			}
 
-			// Flip X if applicable
-			columnWithFlip := columnInTile
-			if flags&SpriteFlagFlipX != 0 {
-				columnWithFlip = 7 - columnWithFlip
-			}
-
 			// Find pixel color palette index
-			var pixelColor uint8 = 0b00
-			if lineLSB&(0b10000000>>columnWithFlip) != 0 {
-				pixelColor |= 0b01
-			}
-			if lineMSB&(0b10000000>>columnWithFlip) != 0 {
-				pixelColor |= 0b10
-			}
+			pixelColor := s.pixel(lineLSB, lineMSB, column)
 
 			// Pixel color of 0 is transparent, skip drawing
 			if pixelColor == 0 {
@@ -475,21 +433,14 @@ func (gb *Gameboy) renderLineSprites(lineNumber uint8, bgPriority [ScreenWidth]b
 
 			// Check if this pixel has already been drawn by a sprite with an equal or lower X position
 			// if so, we have lower priority so do not re-draw
-			if xPos >= xCoordsAlreadyDrawn[pixelX] {
+			if s.ScreenX >= xCoordsAlreadyDrawn[pixelX] {
 				continue
 			}
-			xCoordsAlreadyDrawn[pixelX] = xPos
-
-			var palette uint8
-			if flags&SpriteFlagPalette == 0 {
-				palette = gb.memory.get(OBP0)
-			} else {
-				palette = gb.memory.get(OBP1)
-			}
+			xCoordsAlreadyDrawn[pixelX] = s.ScreenX
 
 			// If sprite priority = 0 we always draw over top of the background
 			// if priority = 1 we can only draw over background pixels which used palette entry 0
-			if (flags&SpriteFlagPriority == 0) || !bgPriority[pixelX] {
+			if !s.BehindBackground || !bgPriority[pixelX] {
 				// Set the appropriate pixel of the screen buffer
 				red, green, blue := getColorFromPalette(pixelColor, palette)
 
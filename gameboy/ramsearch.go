@@ -0,0 +1,216 @@
+package gameboy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+/*
+RAM Search
+
+A search starts with every address in cartridge RAM (A000-BFFF, the bank currently mapped), work RAM (C000-DFFF)
+and high RAM (FF80-FFFE) as a candidate, remembering the value at each one. Each filter keeps only the candidates
+whose current value compares as requested against either the value remembered for it or a given value, then
+remembers the current values for the next filter. Running the game between filters narrows the candidates down
+to the address holding a counter, which can then be frozen with GameShark codes.
+
+16-bit values are little endian, read from the candidate address and the one after it.
+*/
+
+// Ranges of addresses searched, inclusive
+var searchRegions = [...]struct {
+	start uint16
+	end   uint16
+}{
+	{0xA000, 0xBFFF},
+	{0xC000, 0xDFFF},
+	{0xFF80, 0xFFFE},
+}
+
+// SearchComparison is how a filter compares a candidate's current value
+type SearchComparison int
+
+const (
+	SearchEqual SearchComparison = iota
+	SearchNotEqual
+	SearchGreater
+	SearchLess
+	SearchGreaterOrEqual
+	SearchLessOrEqual
+)
+
+var searchComparisonSymbols = map[SearchComparison]string{
+	SearchEqual:          "=",
+	SearchNotEqual:       "!=",
+	SearchGreater:        ">",
+	SearchLess:           "<",
+	SearchGreaterOrEqual: ">=",
+	SearchLessOrEqual:    "<=",
+}
+
+func (c SearchComparison) String() string {
+	if symbol, ok := searchComparisonSymbols[c]; ok {
+		return symbol
+	}
+	return fmt.Sprintf("SearchComparison(%d)", int(c))
+}
+
+// ParseSearchComparison reads a comparison written as =, !=, >, <, >= or <=
+func ParseSearchComparison(text string) (SearchComparison, error) {
+	for comparison, symbol := range searchComparisonSymbols {
+		if text == symbol {
+			return comparison, nil
+		}
+	}
+	return SearchEqual, fmt.Errorf("invalid comparison %q, expected =, !=, >, <, >= or <=", text)
+}
+
+func (c SearchComparison) matches(current int, reference int) bool {
+	switch c {
+	case SearchEqual:
+		return current == reference
+	case SearchNotEqual:
+		return current != reference
+	case SearchGreater:
+		return current > reference
+	case SearchLess:
+		return current < reference
+	case SearchGreaterOrEqual:
+		return current >= reference
+	case SearchLessOrEqual:
+		return current <= reference
+	}
+	panic(fmt.Sprintf("Unknown search comparison %d", int(c)))
+}
+
+// ParseSearchValue reads a value to search for, in decimal or in hex with a $ or 0x prefix
+func ParseSearchValue(text string) (int, error) {
+	var value int64
+	var err error
+	if hex := strings.TrimPrefix(strings.TrimPrefix(text, "$"), "0x"); hex != text {
+		value, err = strconv.ParseInt(hex, 16, 32)
+	} else {
+		value, err = strconv.ParseInt(text, 10, 32)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("invalid search value %q", text)
+	}
+	return int(value), nil
+}
+
+// SearchResult is a candidate remaining in a RAM search
+type SearchResult struct {
+	Address uint16
+	// Value now and when the last filter ran, or the search started
+	Value    int
+	Previous int
+}
+
+// RAMSearch narrows down the RAM addresses which could hold a value
+type RAMSearch struct {
+	gb *Gameboy
+	// Size of the values searched in bytes, 1 or 2
+	size   int
+	signed bool
+	// Addresses still matching every filter, in order, and the value each had after the last filter
+	candidates []uint16
+	previous   []int
+}
+
+// StartRAMSearch begins a search for 8 or 16 bit values, signed or unsigned
+func (gb *Gameboy) StartRAMSearch(bits int, signed bool) (*RAMSearch, error) {
+	if bits != 8 && bits != 16 {
+		return nil, fmt.Errorf("search values must be 8 or 16 bits, not %d", bits)
+	}
+	s := &RAMSearch{gb: gb, size: bits / 8, signed: signed}
+	for _, region := range searchRegions {
+		for address := int(region.start); address+s.size-1 <= int(region.end); address++ {
+			s.candidates = append(s.candidates, uint16(address))
+			s.previous = append(s.previous, s.valueAt(uint16(address)))
+		}
+	}
+	return s, nil
+}
+
+// Current value at an address, with the search's size and signedness
+func (s *RAMSearch) valueAt(address uint16) int {
+	if s.size == 1 {
+		value := s.gb.memory.read(address)
+		if s.signed {
+			return int(int8(value))
+		}
+		return int(value)
+	}
+	value := uint16(s.gb.memory.read(address+1))<<8 | uint16(s.gb.memory.read(address))
+	if s.signed {
+		return int(int16(value))
+	}
+	return int(value)
+}
+
+// Keep the candidates whose current value compares with reference(i), then remember their current values
+func (s *RAMSearch) filter(comparison SearchComparison, reference func(i int) int) int {
+	remaining := 0
+	for i, address := range s.candidates {
+		current := s.valueAt(address)
+		if comparison.matches(current, reference(i)) {
+			s.candidates[remaining] = address
+			s.previous[remaining] = current
+			remaining++
+		}
+	}
+	s.candidates = s.candidates[:remaining]
+	s.previous = s.previous[:remaining]
+	return remaining
+}
+
+// FilterPrevious keeps the candidates whose value compares with the value they had after the last filter,
+// returning how many remain
+func (s *RAMSearch) FilterPrevious(comparison SearchComparison) int {
+	return s.filter(comparison, func(i int) int { return s.previous[i] })
+}
+
+// FilterValue keeps the candidates whose value compares with a given value, returning how many remain
+func (s *RAMSearch) FilterValue(comparison SearchComparison, value int) int {
+	return s.filter(comparison, func(int) int { return value })
+}
+
+// Count returns the number of candidates remaining
+func (s *RAMSearch) Count() int {
+	return len(s.candidates)
+}
+
+// Results returns up to limit of the remaining candidates in address order, or all of them if limit is 0
+func (s *RAMSearch) Results(limit int) []SearchResult {
+	count := len(s.candidates)
+	if limit > 0 && limit < count {
+		count = limit
+	}
+	results := make([]SearchResult, count)
+	for i := range results {
+		address := s.candidates[i]
+		results[i] = SearchResult{Address: address, Value: s.valueAt(address), Previous: s.previous[i]}
+	}
+	return results
+}
+
+// FreezeCheats returns GameShark codes which hold an address at its current value each frame, one per byte of
+// the search's value size
+func (s *RAMSearch) FreezeCheats(address uint16, description string) ([]Cheat, error) {
+	var cheats []Cheat
+	for i := uint16(0); i < uint16(s.size); i++ {
+		cheat, err := GameSharkCheat(address+i, s.gb.memory.read(address+i))
+		if err != nil {
+			return nil, err
+		}
+		cheat.Description = description
+		cheats = append(cheats, cheat)
+	}
+	return cheats, nil
+}
+
+// GameSharkCheat returns an enabled GameShark code writing a value to an address every frame
+func GameSharkCheat(address uint16, value uint8) (Cheat, error) {
+	return ParseCheat(fmt.Sprintf("01%02X%02X%02X", value, address&0xFF, address>>8))
+}
@@ -0,0 +1,96 @@
+package gameboy
+
+import (
+	"testing"
+)
+
+func TestRAMSearch(t *testing.T) {
+	gb := newTestGameBoy("SEARCH", nil)
+	gb.memory.write(0xC123, 5)
+
+	search, err := gb.StartRAMSearch(8, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := search.Count(); count != 0x2000+0x2000+0x7F {
+		t.Fatalf("Search started with %d candidates", count)
+	}
+
+	// The counter goes down while another value goes up
+	gb.memory.write(0xC123, 4)
+	gb.memory.write(0xD000, 9)
+	if count := search.FilterPrevious(SearchLess); count != 1 {
+		t.Fatalf("%d candidates decreased, expected 1", count)
+	}
+	results := search.Results(0)
+	if results[0] != (SearchResult{Address: 0xC123, Value: 4, Previous: 4}) {
+		t.Errorf("Unexpected result %+v", results[0])
+	}
+
+	gb.memory.write(0xC123, 3)
+	if results := search.Results(10); results[0].Value != 3 || results[0].Previous != 4 {
+		t.Errorf("Expected the value to change from 4 to 3, got %+v", results[0])
+	}
+	if count := search.FilterValue(SearchEqual, 2); count != 0 {
+		t.Errorf("%d candidates equal 2, expected none", count)
+	}
+}
+
+func TestRAMSearchSigned16(t *testing.T) {
+	gb := newTestGameBoy("SEARCH", nil)
+	search, err := gb.StartRAMSearch(16, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := search.Count(); count != 0x1FFF+0x1FFF+0x7E {
+		t.Fatalf("Search started with %d candidates", count)
+	}
+
+	gb.memory.write(0xC200, 0xFE)
+	gb.memory.write(0xC201, 0xFF)
+	gb.memory.write(0xFFFD, 0x80)
+	gb.memory.write(0xFFFE, 0x00)
+	if count := search.FilterValue(SearchEqual, -2); count != 1 || search.Results(0)[0].Address != 0xC200 {
+		t.Errorf("Expected only C200 to hold -2, got %+v", search.Results(0))
+	}
+
+	cheats, err := search.FreezeCheats(0xC200, "Health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cheats) != 2 || cheats[0].Code != "01FE00C2" || cheats[1].Code != "01FF01C2" || cheats[1].Description != "Health" {
+		t.Errorf("Unexpected freeze cheats %+v", cheats)
+	}
+	for _, cheat := range cheats {
+		gb.AddCheat(cheat)
+	}
+	gb.memory.write(0xC200, 0)
+	gb.applyRAMCheats()
+	if value := gb.ReadMemory(0xC200); value != 0xFE {
+		t.Errorf("Frozen address reads %02X, expected FE", value)
+	}
+
+	if _, err := gb.StartRAMSearch(32, false); err == nil {
+		t.Error("Expected an error starting a 32-bit search")
+	}
+}
+
+func TestParseSearch(t *testing.T) {
+	for text, expected := range map[string]SearchComparison{"=": SearchEqual, "!=": SearchNotEqual, ">=": SearchGreaterOrEqual} {
+		if comparison, err := ParseSearchComparison(text); err != nil || comparison != expected {
+			t.Errorf("ParseSearchComparison(%q) = %v, %v", text, comparison, err)
+		}
+	}
+	if _, err := ParseSearchComparison("=="); err == nil {
+		t.Error("Expected an error for an unknown comparison")
+	}
+
+	for text, expected := range map[string]int{"12": 12, "-3": -3, "$1F": 31, "0x100": 256} {
+		if value, err := ParseSearchValue(text); err != nil || value != expected {
+			t.Errorf("ParseSearchValue(%q) = %d, %v", text, value, err)
+		}
+	}
+	if _, err := ParseSearchValue("lives"); err == nil {
+		t.Error("Expected an error for a value which is not a number")
+	}
+}
@@ -0,0 +1,196 @@
+package gameboy
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"math"
+)
+
+/*
+Rewind History
+
+A snapshot of the console is taken every `interval` frames. Only the most recent snapshot is kept in full,
+each older snapshot is stored as the XOR difference from the snapshot taken after it, compressed with flate.
+Consecutive frames differ in very few bytes so these differences compress down to a tiny fraction of a
+full snapshot. Stepping backwards applies the newest difference to the latest snapshot, so the oldest
+entries can be dropped from the ring buffer without breaking the chain.
+*/
+
+// Default number of frames between rewind snapshots
+const DefaultRewindInterval = 1
+
+type rewindHistory struct {
+	// Length of history to keep
+	seconds int
+	// Number of frames between snapshots
+	interval int
+	// Frames run since the last snapshot was taken
+	framesSinceSnapshot int
+
+	// Most recent snapshot, uncompressed
+	latest []byte
+	// Ring buffer of compressed differences, each turns a snapshot into the one taken before it
+	deltas [][]byte
+	// Index of the oldest entry in deltas, and number of entries in use
+	start int
+	count int
+
+	// Reused buffers to avoid allocating for every snapshot
+	snapshotBuffer bytes.Buffer
+	compressed     bytes.Buffer
+	compressor     *flate.Writer
+}
+
+// EnableRewind starts keeping a history of up to the given number of seconds, which Rewind can step back through.
+// Any existing history is discarded
+func (gb *Gameboy) EnableRewind(seconds int) {
+	interval := DefaultRewindInterval
+	if gb.rewind != nil {
+		interval = gb.rewind.interval
+	}
+	gb.rewind = newRewindHistory(seconds, interval)
+}
+
+// SetRewindInterval sets the number of frames between rewind snapshots, a larger interval uses less memory and CPU
+// but can only rewind in steps of that many frames. Any existing history is discarded
+func (gb *Gameboy) SetRewindInterval(frames int) {
+	if gb.rewind == nil {
+		return
+	}
+	gb.rewind = newRewindHistory(gb.rewind.seconds, frames)
+}
+
+// DisableRewind stops keeping rewind history and frees the memory it used
+func (gb *Gameboy) DisableRewind() {
+	gb.rewind = nil
+}
+
+// RewindFramesAvailable returns how many frames back the console can currently be rewound
+func (gb *Gameboy) RewindFramesAvailable() int {
+	if gb.rewind == nil || gb.rewind.latest == nil {
+		return 0
+	}
+	return gb.rewind.count*gb.rewind.interval + gb.rewind.framesSinceSnapshot
+}
+
+// Rewind steps the console back by up to the given number of frames, and returns the number of frames it went back.
+// With a rewind interval above 1 the console is rewound in whole intervals, so may go back slightly further.
+// Nothing is rewound while a link cable peer is attached, as the peer would not go back with the console
+func (gb *Gameboy) Rewind(frames int) int {
+	if gb.rewind == nil || gb.rewind.latest == nil || frames <= 0 || gb.serial.peer != nil {
+		return 0
+	}
+	history := gb.rewind
+
+	rewound := 0
+	if history.framesSinceSnapshot > 0 {
+		// Going back to the latest snapshot covers the frames run since it was taken
+		rewound = history.framesSinceSnapshot
+		history.framesSinceSnapshot = 0
+	}
+	for rewound < frames && history.stepBack() {
+		rewound += history.interval
+	}
+
+	if rewound > 0 {
+		if err := gb.Restore(bytes.NewReader(history.latest)); err != nil {
+			// Snapshots all come from this console, so this should never happen
+			panic("unable to restore rewind snapshot: " + err.Error())
+		}
+		gb.movieRewound(rewound)
+	}
+	return rewound
+}
+
+func newRewindHistory(seconds int, interval int) *rewindHistory {
+	if interval < 1 {
+		interval = 1
+	}
+	length := int(math.Ceil(float64(seconds) * FramesPerSecond / float64(interval)))
+	if length < 1 {
+		length = 1
+	}
+	compressor, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return &rewindHistory{
+		seconds:    seconds,
+		interval:   interval,
+		deltas:     make([][]byte, length),
+		compressor: compressor,
+	}
+}
+
+// Called at the end of each frame to take a snapshot when due
+func (h *rewindHistory) frameCompleted(gb *Gameboy) {
+	h.framesSinceSnapshot++
+	if h.framesSinceSnapshot < h.interval && h.latest != nil {
+		return
+	}
+	h.framesSinceSnapshot = 0
+
+	h.snapshotBuffer.Reset()
+	if err := gb.Snapshot(&h.snapshotBuffer); err != nil {
+		// Snapshots are written to memory, so this should never happen
+		panic("unable to take rewind snapshot: " + err.Error())
+	}
+	current := h.snapshotBuffer.Bytes()
+
+	if len(current) != len(h.latest) {
+		// Either this is the first snapshot, or the state size changed and no difference can be taken
+		h.count = 0
+		h.latest = append([]byte{}, current...)
+		return
+	}
+
+	// Store the difference which turns the new snapshot back into the previous one
+	xorBytes(h.latest, current)
+	h.push(h.compress(h.latest))
+	copy(h.latest, current)
+}
+
+// Add a difference to the ring buffer, replacing the oldest entry if full
+func (h *rewindHistory) push(delta []byte) {
+	if h.count == len(h.deltas) {
+		h.start = (h.start + 1) % len(h.deltas)
+		h.count--
+	}
+	h.deltas[(h.start+h.count)%len(h.deltas)] = delta
+	h.count++
+}
+
+// Turn the latest snapshot into the one before it, returns false if there is no older snapshot
+func (h *rewindHistory) stepBack() bool {
+	if h.count == 0 {
+		return false
+	}
+	h.count--
+	index := (h.start + h.count) % len(h.deltas)
+	delta := h.decompress(h.deltas[index])
+	h.deltas[index] = nil
+	xorBytes(h.latest, delta)
+	return true
+}
+
+func (h *rewindHistory) compress(data []byte) []byte {
+	h.compressed.Reset()
+	h.compressor.Reset(&h.compressed)
+	// Writes to a bytes.Buffer cannot fail
+	h.compressor.Write(data)
+	h.compressor.Close()
+	return append([]byte{}, h.compressed.Bytes()...)
+}
+
+func (h *rewindHistory) decompress(data []byte) []byte {
+	decompressed, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(data)))
+	if err != nil {
+		panic("unable to decompress rewind snapshot: " + err.Error())
+	}
+	return decompressed
+}
+
+// XOR each byte of src into dst, slices must be the same length
+func xorBytes(dst []byte, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
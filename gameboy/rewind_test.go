@@ -0,0 +1,95 @@
+package gameboy
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Run frames, returning the snapshot taken after each one
+func snapshotFrames(t *testing.T, gb *Gameboy, frames int) [][]byte {
+	snapshots := make([][]byte, frames)
+	for i := range snapshots {
+		gb.RunNextFrame()
+		snapshots[i] = encodedState(t, gb)
+	}
+	return snapshots
+}
+
+func TestRewindReturnsToEarlierFrames(t *testing.T) {
+	gb, _ := newBusyGameBoy(t)
+	gb.EnableRewind(1)
+	history := snapshotFrames(t, gb, 40)
+
+	if rewound := gb.Rewind(10); rewound != 10 {
+		t.Fatalf("Expected to rewind 10 frames, rewound %d", rewound)
+	}
+	if !bytes.Equal(encodedState(t, gb), history[29]) {
+		t.Fatalf("State after rewinding 10 frames does not match frame 29")
+	}
+
+	// Running forward again from a rewound state should keep the history consistent
+	history = append(history[:30], snapshotFrames(t, gb, 5)...)
+	if rewound := gb.Rewind(20); rewound != 20 {
+		t.Fatalf("Expected to rewind 20 frames, rewound %d", rewound)
+	}
+	if !bytes.Equal(encodedState(t, gb), history[14]) {
+		t.Fatalf("State after rewinding 20 frames does not match frame 14")
+	}
+}
+
+func TestRewindLimitedByHistoryLength(t *testing.T) {
+	gb, _ := newBusyGameBoy(t)
+	gb.EnableRewind(1)
+	history := snapshotFrames(t, gb, 100)
+
+	// One second of history holds 60 snapshots
+	available := gb.RewindFramesAvailable()
+	if available != 60 {
+		t.Fatalf("Expected 60 frames of history, got %d", available)
+	}
+	if rewound := gb.Rewind(1000); rewound != available {
+		t.Fatalf("Expected to rewind %d frames, rewound %d", available, rewound)
+	}
+	if !bytes.Equal(encodedState(t, gb), history[99-available]) {
+		t.Fatalf("State after rewinding does not match oldest frame in history")
+	}
+	if rewound := gb.Rewind(1); rewound != 0 {
+		t.Fatalf("Expected no more history, rewound %d", rewound)
+	}
+}
+
+func TestRewindInterval(t *testing.T) {
+	gb, _ := newBusyGameBoy(t)
+	gb.EnableRewind(1)
+	gb.SetRewindInterval(4)
+	history := snapshotFrames(t, gb, 22)
+
+	// Snapshots are taken after frames 0, 4, 8 ... 20, so one frame back goes to the snapshot after frame 20
+	if rewound := gb.Rewind(1); rewound != 1 {
+		t.Fatalf("Expected to rewind 1 frame, rewound %d", rewound)
+	}
+	if !bytes.Equal(encodedState(t, gb), history[20]) {
+		t.Fatalf("State does not match frame 20")
+	}
+	// Then further rewinds move a whole interval at a time
+	if rewound := gb.Rewind(3); rewound != 4 {
+		t.Fatalf("Expected to rewind 4 frames, rewound %d", rewound)
+	}
+	if !bytes.Equal(encodedState(t, gb), history[16]) {
+		t.Fatalf("State does not match frame 16")
+	}
+}
+
+func TestRewindBlockedByLinkPeer(t *testing.T) {
+	gb, _ := newBusyGameBoy(t)
+	gb.EnableRewind(1)
+	gb.SetLinkPeer(&testLinkPeer{})
+	history := snapshotFrames(t, gb, 10)
+
+	if rewound := gb.Rewind(5); rewound != 0 {
+		t.Fatalf("Expected no rewind with a link peer attached, rewound %d", rewound)
+	}
+	if !bytes.Equal(encodedState(t, gb), history[9]) {
+		t.Fatalf("Expected state to be unchanged")
+	}
+}
@@ -1,35 +1,132 @@
 package gameboy
 
 import (
-	"github.com/cbott/GoEmulate/cartridges"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/cbott/GoEmulate/snapshot"
 )
 
 // Number of distinct save states we will allow storing, arbitrary limit, more would just make the gameboy object larger
 const NumSaveStates = 3
 
-// SaveState holds a snapshot of the current Game Boy state, allowing it to be returned to later
-type SaveState struct {
-	cpu                    CpuRegisters
-	currentScanCycles      int
-	timerAccumulator       int
-	halted                 bool
-	interruptMasterEnable  bool
-	pendingInterruptEnable bool
-	screenCleared          bool
-	displayEnabled         bool
-
-	memory         [0x10000]uint8
-	divAccumulator int
-	ButtonStates   uint8
-
-	// Cartridge state
-	ram        [][cartridges.RAMBankSize]uint8
-	ramBank    uint8
-	ramEnabled bool
-	romBank    uint16
+// Snapshot writes the complete state of the console and every component within it:
+// CPU and console state, then memory, the APU, and finally the cartridge
+func (gb *Gameboy) Snapshot(w io.Writer) error {
+	err := snapshot.Write(w,
+		gb.cpu,
+		gb.currentScanCycles,
+		gb.timerAccumulator,
+		gb.halted,
+		gb.interruptMasterEnable,
+		gb.pendingInterruptEnable,
+		gb.screenCleared,
+		gb.displayEnabled,
+		gb.frameCycles,
+		gb.frameRunning,
+		gb.interruptCycles,
+		gb.serial.active,
+		gb.serial.cycles,
+		gb.serial.bits,
+		gb.serial.received,
+		// The screen buffer is included as lines are not redrawn while the background is disabled
+		&gb.ScreenData,
+	)
+	if err != nil {
+		return err
+	}
+
+	components := []snapshot.Snapshotter{gb.memory, gb.memory.apu, gb.memory.cartridge}
+	for _, component := range components {
+		if err := component.Snapshot(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Restore replaces the state of the console with one written by Snapshot
+// If an error occurs the console is left in its previous state, or if even that cannot be restored
+// the console is stopped until a later Restore succeeds
+func (gb *Gameboy) Restore(r io.Reader) error {
+	// Keep a copy of the current state to go back to if the new one is invalid
+	var backup bytes.Buffer
+	if err := gb.Snapshot(&backup); err != nil {
+		return err
+	}
+
+	if err := gb.restore(r); err != nil {
+		if backupErr := gb.restore(&backup); backupErr != nil {
+			gb.stateLost = true
+			return fmt.Errorf("%w, and the previous state could not be restored either (%v), console stopped",
+				err, backupErr)
+		}
+		return err
+	}
+	gb.stateLost = false
+	gb.started = true
+	return nil
+}
+
+// StateLost returns whether a failed restore left the console stopped in an inconsistent state
+func (gb *Gameboy) StateLost() bool {
+	return gb.stateLost
+}
+
+// Read state written by Snapshot into each component in turn
+func (gb *Gameboy) restore(r io.Reader) error {
+	err := snapshot.Read(r,
+		gb.cpu,
+		&gb.currentScanCycles,
+		&gb.timerAccumulator,
+		&gb.halted,
+		&gb.interruptMasterEnable,
+		&gb.pendingInterruptEnable,
+		&gb.screenCleared,
+		&gb.displayEnabled,
+		&gb.frameCycles,
+		&gb.frameRunning,
+		&gb.interruptCycles,
+		&gb.serial.active,
+		&gb.serial.cycles,
+		&gb.serial.bits,
+		&gb.serial.received,
+		&gb.ScreenData,
+	)
+	if err != nil {
+		return err
+	}
+
+	components := []snapshot.Snapshotter{gb.memory, gb.memory.apu, gb.memory.cartridge}
+	for _, component := range components {
+		if err := component.Restore(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return the snapshot of the current console state as a byte slice
+func (gb *Gameboy) snapshotBytes() ([]byte, error) {
+	var state bytes.Buffer
+	if err := gb.Snapshot(&state); err != nil {
+		return nil, err
+	}
+	return state.Bytes(), nil
 }
 
-// StoreState saves the current memory and CPU state to the internal storage array at index i
+// Snapshot writes the contents of memory along with the DIV timer and joypad state
+func (m *Memory) Snapshot(w io.Writer) error {
+	return snapshot.Write(w, &m.memory, m.divAccumulator, m.buttonStates)
+}
+
+// Restore reads state written by Snapshot
+func (m *Memory) Restore(r io.Reader) error {
+	return snapshot.Read(r, &m.memory, &m.divAccumulator, &m.buttonStates)
+}
+
+// StoreState saves the current console state to the internal storage array at index i
 // if index falls outside the range 0 <= i < NumSaveStates the operation will be ignored
 // Returns whether the state was successfully stored
 func (gb *Gameboy) StoreState(i int) bool {
@@ -37,28 +134,15 @@ func (gb *Gameboy) StoreState(i int) bool {
 		return false
 	}
 
-	save := SaveState{}
-
-	save.memory = gb.memory.memory
-	save.divAccumulator = gb.memory.divAccumulator
-	save.ButtonStates = gb.memory.buttonStates
-
-	save.cpu = *gb.cpu
-	save.currentScanCycles = gb.currentScanCycles
-	save.timerAccumulator = gb.timerAccumulator
-	save.halted = gb.halted
-	save.interruptMasterEnable = gb.interruptMasterEnable
-	save.pendingInterruptEnable = gb.pendingInterruptEnable
-	save.screenCleared = gb.screenCleared
-	save.displayEnabled = gb.displayEnabled
-
-	save.ram, save.ramBank, save.ramEnabled, save.romBank = gb.memory.cartridge.GetState()
-
-	gb.savestates[i] = &save
+	state, err := gb.snapshotBytes()
+	if err != nil {
+		return false
+	}
+	gb.savestates[i] = state
 	return true
 }
 
-// RecallState overwrites the current memory and CPU state with values previously stored at index i with StoreState
+// RecallState overwrites the current console state with values previously stored at index i with StoreState
 // if index has no previously saved state, the operation will be ignored
 // Returns whether the state was successfully restored
 func (gb *Gameboy) RecallState(i int) bool {
@@ -69,22 +153,5 @@ func (gb *Gameboy) RecallState(i int) bool {
 		return false
 	}
 
-	var state *SaveState = gb.savestates[i]
-
-	gb.memory.memory = state.memory
-	gb.memory.divAccumulator = state.divAccumulator
-	gb.memory.buttonStates = state.ButtonStates
-
-	gb.cpu = &state.cpu
-	gb.currentScanCycles = state.currentScanCycles
-	gb.timerAccumulator = state.timerAccumulator
-	gb.halted = state.halted
-	gb.interruptMasterEnable = state.interruptMasterEnable
-	gb.pendingInterruptEnable = state.pendingInterruptEnable
-	gb.screenCleared = state.screenCleared
-	gb.displayEnabled = state.displayEnabled
-
-	gb.memory.cartridge.SetState(state.ram, state.ramBank, state.ramEnabled, state.romBank)
-
-	return true
+	return gb.Restore(bytes.NewReader(gb.savestates[i])) == nil
 }
@@ -0,0 +1,132 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/snapshot"
+)
+
+/*
+Save State File Format
+All multi-byte values are little endian
+
+Header
+Offset  Size  Field
+0       8     Magic "GBSTATE\x00"
+8       2     Format version (SaveStateVersion)
+10      16    ROM title from the cartridge header, zero padded
+26      2     ROM global checksum from the cartridge header
+28      4     Payload length in bytes
+32      4     CRC-32 (IEEE) of the payload
+
+Payload
+The payload is the output of Gameboy.Snapshot, which is the snapshot of each component in turn
+(see the Snapshot method of each for its layout)
+- CPU registers, console state, frame progress, serial transfer progress and screen buffer (Gameboy)
+- Memory, DIV timer and joypad (Memory)
+- Audio processing unit and each of its sound channels (sound.APU)
+- Cartridge banking registers, RAM and any mapper specific state such as the MBC3 clock (cartridges.Cartridge)
+
+Version history
+1  CPU, memory and generic cartridge state only
+2  Complete snapshot of every component
+3  Progress through the current frame, so states can be saved while the debugger is stopped mid-frame
+4  Serial transfer progress
+*/
+
+// Current version of the save state file format, increased any time the payload layout changes
+const SaveStateVersion = 4
+
+var saveStateMagic = [8]byte{'G', 'B', 'S', 'T', 'A', 'T', 'E', 0}
+
+// Upper limit on payload size to avoid allocating huge buffers for corrupt files
+const maxSaveStatePayload = 16 * 1024 * 1024
+
+type saveStateHeader struct {
+	Magic          [8]byte
+	Version        uint16
+	Title          [cartridges.TitleLength]byte
+	GlobalChecksum uint16
+	PayloadLength  uint32
+	PayloadCRC     uint32
+}
+
+// Build the header identifying the currently loaded cartridge
+func (gb *Gameboy) saveStateHeader() saveStateHeader {
+	header := saveStateHeader{
+		Magic:          saveStateMagic,
+		Version:        SaveStateVersion,
+		GlobalChecksum: gb.memory.cartridge.GlobalChecksum(),
+	}
+	copy(header.Title[:], gb.memory.cartridge.Title())
+	return header
+}
+
+// SaveStateTo writes the current console state to w in the save state file format
+func (gb *Gameboy) SaveStateTo(w io.Writer) error {
+	var payload bytes.Buffer
+	if err := gb.Snapshot(&payload); err != nil {
+		return err
+	}
+
+	header := gb.saveStateHeader()
+	header.PayloadLength = uint32(payload.Len())
+	header.PayloadCRC = crc32.ChecksumIEEE(payload.Bytes())
+
+	if err := snapshot.Write(w, &header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+// LoadStateFrom reads a state written by SaveStateTo and applies it to the console.
+// The state is rejected if it was saved from a different game, from an unsupported format version, or is corrupt.
+// The console is not modified if an error is returned
+func (gb *Gameboy) LoadStateFrom(r io.Reader) error {
+	var header saveStateHeader
+	if err := snapshot.Read(r, &header); err != nil {
+		return fmt.Errorf("unable to read save state header: %v", err)
+	}
+
+	if header.Magic != saveStateMagic {
+		return errors.New("not a save state file")
+	}
+	if header.Version > SaveStateVersion {
+		return fmt.Errorf("save state format version %d is newer than the supported version %d",
+			header.Version, SaveStateVersion)
+	}
+	if header.Version < SaveStateVersion {
+		// Older versions do not contain enough information to rebuild the full console state
+		return fmt.Errorf("save state format version %d is no longer supported (current version is %d), "+
+			"the state must be saved again", header.Version, SaveStateVersion)
+	}
+
+	expected := gb.saveStateHeader()
+	if header.Title != expected.Title || header.GlobalChecksum != expected.GlobalChecksum {
+		return fmt.Errorf("save state is for %q (checksum %04X), but %q (checksum %04X) is loaded",
+			bytes.TrimRight(header.Title[:], "\x00"), header.GlobalChecksum,
+			bytes.TrimRight(expected.Title[:], "\x00"), expected.GlobalChecksum)
+	}
+
+	if header.PayloadLength > maxSaveStatePayload {
+		return fmt.Errorf("save state payload size %d is too large", header.PayloadLength)
+	}
+	payload := make([]byte, header.PayloadLength)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return fmt.Errorf("unable to read save state payload: %v", err)
+	}
+	if crc32.ChecksumIEEE(payload) != header.PayloadCRC {
+		return errors.New("save state is corrupt (checksum mismatch)")
+	}
+
+	if err := gb.Restore(bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("unable to restore save state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package gameboy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/cbott/GoEmulate/cartridges"
+)
+
+// Program which counts up in register A and stores the count at C000 forever
+var counterProgram = []uint8{
+	0x3C,             // INC A
+	0xEA, 0x00, 0xC0, // LD (C000),A
+	0x18, 0xFA, // JR -6
+}
+
+// Build a 32KiB ROM only cartridge image which jumps to program at 0x150
+func makeTestROM(title string, program []uint8) []uint8 {
+	rom := make([]uint8, 2*cartridges.ROMBankSize)
+	copy(rom[0x100:], []uint8{0x00, 0xC3, 0x50, 0x01}) // NOP; JP 0150
+	copy(rom[cartridges.TitleAddress:cartridges.TitleAddress+cartridges.TitleLength], title)
+	copy(rom[0x150:], program)
+	return rom
+}
+
+// Create a Game Boy running a ROM only cartridge with the given program
+func newTestGameBoy(title string, program []uint8) *Gameboy {
+	gb := NewGameBoy(true, false)
+	gb.LoadCartridge(cartridges.NewROMOnlyCartridge(makeTestROM(title, program)))
+	return gb
+}
+
+// Return the serialized form of the console's current state for comparison
+func encodedState(t *testing.T, gb *Gameboy) []byte {
+	var buf bytes.Buffer
+	if err := gb.Snapshot(&buf); err != nil {
+		t.Fatalf("Unable to encode state: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestSaveStateRoundTrip(t *testing.T) {
+	gb := newTestGameBoy("COUNTER", counterProgram)
+	for i := 0; i < 5; i++ {
+		gb.RunNextFrame()
+	}
+
+	var file bytes.Buffer
+	if err := gb.SaveStateTo(&file); err != nil {
+		t.Fatalf("Unable to save state: %v", err)
+	}
+	saved := encodedState(t, gb)
+
+	gb.RunNextFrame()
+	if bytes.Equal(encodedState(t, gb), saved) {
+		t.Fatalf("Expected state to change after running a frame")
+	}
+
+	if err := gb.LoadStateFrom(&file); err != nil {
+		t.Fatalf("Unable to load state: %v", err)
+	}
+	if !bytes.Equal(encodedState(t, gb), saved) {
+		t.Fatalf("Loaded state does not match saved state")
+	}
+}
+
+func TestLoadStateRejectsOtherGame(t *testing.T) {
+	var file bytes.Buffer
+	if err := newTestGameBoy("GAME ONE", counterProgram).SaveStateTo(&file); err != nil {
+		t.Fatalf("Unable to save state: %v", err)
+	}
+
+	err := newTestGameBoy("GAME TWO", counterProgram).LoadStateFrom(&file)
+	if err == nil || !strings.Contains(err.Error(), "GAME ONE") {
+		t.Fatalf("Expected error naming the other game, got %v", err)
+	}
+}
+
+func TestLoadStateRejectsBadFiles(t *testing.T) {
+	gb := newTestGameBoy("COUNTER", counterProgram)
+	var file bytes.Buffer
+	if err := gb.SaveStateTo(&file); err != nil {
+		t.Fatalf("Unable to save state: %v", err)
+	}
+	valid := file.Bytes()
+
+	corrupt := append([]byte{}, valid...)
+	corrupt[len(corrupt)-100] ^= 0xFF
+
+	newer := append([]byte{}, valid...)
+	newer[8] = SaveStateVersion + 1
+
+	testcases := map[string][]byte{
+		"corrupt":   corrupt,
+		"newer":     newer,
+		"truncated": valid[:len(valid)-1],
+		"not state": []byte("this is not a save state file at all, just some text"),
+	}
+	for name, data := range testcases {
+		if err := gb.LoadStateFrom(bytes.NewReader(data)); err == nil {
+			t.Errorf("Expected %s save state to be rejected", name)
+		}
+	}
+}
+
+// Cartridge whose state can no longer be restored once broken
+type brokenRestoreCartridge struct {
+	cartridges.Cartridge
+	broken bool
+}
+
+func (c *brokenRestoreCartridge) Restore(r io.Reader) error {
+	if c.broken {
+		return errors.New("cartridge restore failed")
+	}
+	return c.Cartridge.Restore(r)
+}
+
+func TestFailedRestoreStopsConsole(t *testing.T) {
+	gb := NewGameBoy(true, false)
+	cartridge := &brokenRestoreCartridge{Cartridge: cartridges.NewROMOnlyCartridge(makeTestROM("COUNTER", counterProgram))}
+	gb.LoadCartridge(cartridge)
+	gb.RunNextFrame()
+	state := encodedState(t, gb)
+
+	// Neither the new state nor the backup of the current one can be restored
+	cartridge.broken = true
+	err := gb.Restore(bytes.NewReader(state))
+	if err == nil || !strings.Contains(err.Error(), "cartridge restore failed") {
+		t.Fatalf("Expected restore error, got %v", err)
+	}
+	if !gb.StateLost() {
+		t.Fatalf("Expected console to be stopped after an unrecoverable restore")
+	}
+	stopped := encodedState(t, gb)
+	gb.RunNextFrame()
+	if !bytes.Equal(encodedState(t, gb), stopped) {
+		t.Fatalf("Expected stopped console not to run")
+	}
+
+	// A successful restore starts it again
+	cartridge.broken = false
+	if err := gb.Restore(bytes.NewReader(state)); err != nil {
+		t.Fatalf("Unable to restore state: %v", err)
+	}
+	if gb.StateLost() {
+		t.Fatalf("Expected console to run again after a successful restore")
+	}
+	gb.RunNextFrame()
+	if bytes.Equal(encodedState(t, gb), state) {
+		t.Fatalf("Expected console to run after a successful restore")
+	}
+}
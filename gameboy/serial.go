@@ -0,0 +1,124 @@
+package gameboy
+
+/*
+Serial Port
+
+SB holds the byte being transferred, which is shifted out most significant bit first while the byte from the other
+end of the link cable is shifted in at the bottom. Writing SC with bit 7 set starts a transfer:
+
+SC  Bit 7 - Transfer start (1 = requested or in progress, cleared when the transfer completes)
+    Bit 0 - Clock select (0 = external clock from the peer, 1 = internal clock)
+    Bits 1-6 are unused and read as 1
+
+With the internal clock this console shifts one bit every 512 cycles (8192 Hz), so a byte takes 4096 cycles.
+The peer's byte is collected when the transfer starts and shifted in a bit at a time, reading 0xFF when no peer
+is attached. With the external clock the transfer waits, forever if need be, until the peer clocks a byte in.
+The serial interrupt is requested when the 8th bit has been shifted.
+*/
+
+const (
+	SB                = 0xFF01 // Serial transfer data
+	SC                = 0xFF02 // Serial transfer control
+	SC_transfer_start = 1 << 7
+	SC_internal_clock = 1 << 0
+
+	// Cycles taken to shift each bit with the internal clock (8192 Hz)
+	SerialCyclesPerBit = CpuSpeed / 8192
+)
+
+// LinkPeer is the device at the other end of the link cable
+type LinkPeer interface {
+	// Exchange is called when this console starts a transfer with its internal clock, with the byte in SB.
+	// It returns the byte shifted back by the peer, 0xFF if nothing is listening
+	Exchange(out uint8) uint8
+	// Poll is called after every instruction so a peer with its own clock can transfer a byte. out is the byte
+	// in SB and ready is true while this console waits for an external clock transfer. If the peer clocks a byte
+	// in while ready it returns the byte and true. A peer clocking a byte while the console is not ready should
+	// receive 0xFF and return false, leaving SB unchanged
+	Poll(out uint8, ready bool) (in uint8, ok bool)
+}
+
+// AsyncLinkPeer is a LinkPeer whose replies take real time to arrive, such as over a network. Its transfers overlap
+// with the console shifting the byte, so the console only waits if the reply has not arrived by the 8th bit.
+// The bits shifted into SB before then read as 1, and the reply replaces them once the transfer completes
+type AsyncLinkPeer interface {
+	LinkPeer
+	// StartExchange is called in place of Exchange when a transfer with the internal clock starts
+	StartExchange(out uint8)
+	// FinishExchange returns the byte shifted back for the transfer started last, 0xFF if there is none
+	FinishExchange() uint8
+}
+
+// Progress of a serial transfer with the internal clock
+type serialPort struct {
+	peer LinkPeer
+	// Whether a transfer with the internal clock is underway
+	active bool
+	// Cycles towards the next bit, and bits shifted so far
+	cycles int
+	bits   int
+	// Byte being shifted in from the peer
+	received uint8
+}
+
+// SetLinkPeer attaches a device to the link cable port, or disconnects the cable if peer is nil
+func (gb *Gameboy) SetLinkPeer(peer LinkPeer) {
+	gb.serial.peer = peer
+}
+
+// LinkPeer returns the device attached to the link cable port, nil if none
+func (gb *Gameboy) LinkPeer() LinkPeer {
+	return gb.serial.peer
+}
+
+// Advance the serial port by the specified number of machine cycles (4MHz)
+func (gb *Gameboy) RunSerial(cycles int) {
+	control := gb.memory.get(SC)
+	ready := control&(SC_transfer_start|SC_internal_clock) == SC_transfer_start
+	if gb.serial.peer != nil {
+		if in, ok := gb.serial.peer.Poll(gb.memory.get(SB), ready); ok && ready {
+			gb.completeSerialTransfer(in)
+			return
+		}
+	}
+
+	if control&SC_transfer_start == 0 || control&SC_internal_clock == 0 {
+		// Nothing to do until a transfer is requested, or the peer clocks one
+		gb.serial.active = false
+		return
+	}
+
+	if !gb.serial.active {
+		gb.serial.active = true
+		gb.serial.cycles = 0
+		gb.serial.bits = 0
+		gb.serial.received = 0xFF
+		if async, ok := gb.serial.peer.(AsyncLinkPeer); ok {
+			async.StartExchange(gb.memory.get(SB))
+		} else if gb.serial.peer != nil {
+			gb.serial.received = gb.serial.peer.Exchange(gb.memory.get(SB))
+		}
+	}
+
+	gb.serial.cycles += cycles
+	for gb.serial.active && gb.serial.cycles >= SerialCyclesPerBit {
+		gb.serial.cycles -= SerialCyclesPerBit
+		in := (gb.serial.received >> (7 - gb.serial.bits)) & 1
+		gb.memory.set(SB, gb.memory.get(SB)<<1|in)
+		gb.serial.bits++
+		if gb.serial.bits == 8 {
+			if async, ok := gb.serial.peer.(AsyncLinkPeer); ok {
+				gb.serial.received = async.FinishExchange()
+			}
+			gb.completeSerialTransfer(gb.serial.received)
+		}
+	}
+}
+
+// Finish a transfer with the byte received in SB, and request the serial interrupt
+func (gb *Gameboy) completeSerialTransfer(in uint8) {
+	gb.serial.active = false
+	gb.memory.set(SB, in)
+	gb.memory.set(SC, gb.memory.get(SC)&^SC_transfer_start)
+	gb.SetInterruptRequestFlag(Interrupt_serial)
+}
@@ -0,0 +1,173 @@
+package gameboy
+
+import (
+	"testing"
+)
+
+// Link peer answering every transfer with a fixed byte, which can also clock a byte in itself
+type testLinkPeer struct {
+	reply uint8
+	sent  []uint8
+	// Byte to clock into the console on the next poll, if set
+	clock    bool
+	clockIn  uint8
+	notReady int
+}
+
+func (p *testLinkPeer) Exchange(out uint8) uint8 {
+	p.sent = append(p.sent, out)
+	return p.reply
+}
+
+func (p *testLinkPeer) Poll(out uint8, ready bool) (uint8, bool) {
+	if !p.clock {
+		return 0, false
+	}
+	if !ready {
+		p.notReady++
+		return 0, false
+	}
+	p.clock = false
+	p.sent = append(p.sent, out)
+	return p.clockIn, true
+}
+
+// Link peer whose reply is only collected once the console has shifted the whole byte
+type testAsyncLinkPeer struct {
+	testLinkPeer
+	started  bool
+	finished int
+}
+
+func (p *testAsyncLinkPeer) StartExchange(out uint8) {
+	p.sent = append(p.sent, out)
+	p.started = true
+}
+
+func (p *testAsyncLinkPeer) FinishExchange() uint8 {
+	if !p.started {
+		return 0xFF
+	}
+	p.started = false
+	p.finished++
+	return p.reply
+}
+
+func serialInterruptRequested(gb *Gameboy) bool {
+	return gb.memory.read(IF)&Interrupt_serial != 0
+}
+
+func TestSerialNoPeer(t *testing.T) {
+	gb := newTestGameBoy("SERIAL", nil)
+	gb.memory.write(SB, 0x42)
+	gb.memory.write(SC, SC_transfer_start|SC_internal_clock)
+
+	// Three bits in, the top of 0x42 has been shifted out and 1s shifted in
+	gb.RunSerial(3 * SerialCyclesPerBit)
+	if value := gb.memory.read(SB); value != 0x17 {
+		t.Errorf("SB is %02X after 3 bits, expected 17", value)
+	}
+	if serialInterruptRequested(gb) {
+		t.Error("Serial interrupt requested before the transfer completed")
+	}
+
+	gb.RunSerial(5*SerialCyclesPerBit - 4)
+	if gb.memory.read(SC)&SC_transfer_start == 0 {
+		t.Error("Transfer completed early")
+	}
+	gb.RunSerial(4)
+	if value := gb.memory.read(SB); value != 0xFF {
+		t.Errorf("SB is %02X with no peer, expected FF", value)
+	}
+	if value := gb.memory.read(SC); value != 0x7F {
+		t.Errorf("SC is %02X after the transfer, expected 7F", value)
+	}
+	if !serialInterruptRequested(gb) {
+		t.Error("Serial interrupt not requested")
+	}
+}
+
+func TestSerialInternalClock(t *testing.T) {
+	gb := newTestGameBoy("SERIAL", nil)
+	peer := &testLinkPeer{reply: 0x5A}
+	gb.SetLinkPeer(peer)
+	gb.memory.write(SB, 0x81)
+	gb.memory.write(SC, SC_transfer_start|SC_internal_clock)
+
+	for i := 0; i < 8; i++ {
+		gb.RunSerial(SerialCyclesPerBit)
+	}
+	if len(peer.sent) != 1 || peer.sent[0] != 0x81 {
+		t.Errorf("Peer was sent %X, expected 81", peer.sent)
+	}
+	if value := gb.memory.read(SB); value != 0x5A {
+		t.Errorf("SB is %02X, expected 5A from the peer", value)
+	}
+	if !serialInterruptRequested(gb) {
+		t.Error("Serial interrupt not requested")
+	}
+
+	// Nothing more is sent until the next transfer starts
+	gb.RunSerial(8 * SerialCyclesPerBit)
+	if len(peer.sent) != 1 {
+		t.Errorf("Peer was sent %X without a transfer", peer.sent)
+	}
+}
+
+func TestSerialExternalClock(t *testing.T) {
+	gb := newTestGameBoy("SERIAL", nil)
+	gb.memory.write(SB, 0x33)
+	gb.memory.write(SC, SC_transfer_start)
+
+	// With no peer to drive the clock the transfer never completes
+	gb.RunSerial(100 * SerialCyclesPerBit)
+	if gb.memory.read(SC)&SC_transfer_start == 0 || serialInterruptRequested(gb) {
+		t.Fatal("External clock transfer completed without a peer")
+	}
+
+	peer := &testLinkPeer{clock: true, clockIn: 0xC4}
+	gb.SetLinkPeer(peer)
+	gb.RunSerial(4)
+	if value := gb.memory.read(SB); value != 0xC4 {
+		t.Errorf("SB is %02X, expected C4 from the peer", value)
+	}
+	if len(peer.sent) != 1 || peer.sent[0] != 0x33 {
+		t.Errorf("Peer was sent %X, expected 33", peer.sent)
+	}
+	if gb.memory.read(SC)&SC_transfer_start != 0 || !serialInterruptRequested(gb) {
+		t.Error("External clock transfer did not complete")
+	}
+
+	// A byte clocked in while the console is not waiting for one is not received
+	peer.clock = true
+	gb.RunSerial(4)
+	if value := gb.memory.read(SB); value != 0xC4 || peer.notReady != 1 {
+		t.Errorf("SB changed to %02X while not ready", value)
+	}
+}
+
+func TestSerialAsyncPeer(t *testing.T) {
+	gb := newTestGameBoy("SERIAL", nil)
+	peer := &testAsyncLinkPeer{testLinkPeer: testLinkPeer{reply: 0x5A}}
+	gb.SetLinkPeer(peer)
+	gb.memory.write(SB, 0x81)
+	gb.memory.write(SC, SC_transfer_start|SC_internal_clock)
+
+	// The reply is not needed until the last bit has been shifted
+	for i := 0; i < 7; i++ {
+		gb.RunSerial(SerialCyclesPerBit)
+	}
+	if len(peer.sent) != 1 || peer.sent[0] != 0x81 || peer.finished != 0 {
+		t.Fatalf("Peer was sent %X and finished %d exchanges, expected 81 and none", peer.sent, peer.finished)
+	}
+	gb.RunSerial(SerialCyclesPerBit)
+	if peer.finished != 1 {
+		t.Errorf("Expected the exchange to finish with the transfer, finished %d", peer.finished)
+	}
+	if value := gb.memory.read(SB); value != 0x5A {
+		t.Errorf("SB is %02X, expected 5A from the peer", value)
+	}
+	if !serialInterruptRequested(gb) {
+		t.Error("Serial interrupt not requested")
+	}
+}
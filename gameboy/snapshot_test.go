@@ -0,0 +1,168 @@
+package gameboy
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/sound"
+)
+
+// Program for an MBC1 cartridge which keeps graphics, sound, timers, interrupts and cartridge RAM busy
+var busyProgram = map[uint16][]uint8{
+	// VBlank interrupt: scroll the background one pixel to the right
+	0x0040: {
+		0xF5,       // PUSH AF
+		0xF0, 0x43, // LDH A,(SCX)
+		0x3C,       // INC A
+		0xE0, 0x43, // LDH (SCX),A
+		0xF1, // POP AF
+		0xD9, // RETI
+	},
+	// Timer interrupt: count in cartridge RAM and use the count as the channel 2 frequency
+	0x0050: {
+		0xF5,             // PUSH AF
+		0xFA, 0x00, 0xA0, // LD A,(A000)
+		0x3C,             // INC A
+		0xEA, 0x00, 0xA0, // LD (A000),A
+		0xE0, 0x18, // LDH (NR23),A
+		0xF1, // POP AF
+		0xD9, // RETI
+	},
+	0x0150: {
+		0x3E, 0x0A, 0xEA, 0x00, 0x00, // Enable cartridge RAM
+		0x3E, 0x01, 0xEA, 0x00, 0x60, // Select RAM banking mode
+		0x3E, 0x80, 0xE0, 0x26, // NR52: APU on
+		0x3E, 0x77, 0xE0, 0x24, // NR50: full volume
+		0x3E, 0xFF, 0xE0, 0x25, // NR51: all channels left and right
+		0x3E, 0x80, 0xE0, 0x16, // NR21: 50% duty
+		0x3E, 0xF0, 0xE0, 0x17, // NR22: full volume
+		0x3E, 0x87, 0xE0, 0x19, // NR24: trigger
+		0x3E, 0x05, 0xE0, 0x07, // TAC: timer enabled at 262144Hz
+		0x3E, 0x05, 0xE0, 0xFF, // IE: VBlank and timer
+		0xFB,             // EI
+		0x21, 0x00, 0x80, // loop: LD HL,8000
+		0xF0, 0x04, // inner: LDH A,(DIV)
+		0x22,       // LD (HL+),A
+		0x7C,       // LD A,H
+		0xFE, 0x88, // CP 88
+		0x20, 0xF8, // JR NZ,inner
+		0x18, 0xF3, // JR loop
+	},
+}
+
+// Create a Game Boy with an MBC1+RAM+BATTERY cartridge running busyProgram, recording audio to the returned sink
+func newBusyGameBoy(t *testing.T) (*Gameboy, *sound.BufferSink) {
+	rom := makeTestROM("BUSY", nil)
+	for address, code := range busyProgram {
+		copy(rom[address:], code)
+	}
+	rom[cartridges.CartridgeTypeAddress] = 0x03
+	rom[cartridges.RAMSizeAddress] = 0x02
+
+	gb := NewGameBoy(true, false)
+	gb.LoadCartridge(cartridges.NewMBC1Cartridge(filepath.Join(t.TempDir(), "busy.gb"), rom))
+	sink := &sound.BufferSink{}
+	gb.SetAudioSink(sink)
+	return gb, sink
+}
+
+// Output produced by the console during a single frame
+type frameOutput struct {
+	screen [ScreenWidth][ScreenHeight][3]uint8
+	audio  [][2]uint8
+}
+
+// Run a number of frames and return the screen and audio output of each
+func recordFrames(gb *Gameboy, sink *sound.BufferSink, frames int) []frameOutput {
+	outputs := make([]frameOutput, frames)
+	for i := range outputs {
+		sink.Reset()
+		gb.RunNextFrame()
+		outputs[i].screen = gb.ScreenData
+		outputs[i].audio = append([][2]uint8{}, sink.Samples...)
+	}
+	return outputs
+}
+
+// Check that two recordings are identical
+func compareFrames(t *testing.T, name string, expected []frameOutput, actual []frameOutput) {
+	for i := range expected {
+		if expected[i].screen != actual[i].screen {
+			t.Fatalf("%s: screen differs on frame %d", name, i)
+		}
+		if !bytes.Equal(flattenAudio(expected[i].audio), flattenAudio(actual[i].audio)) {
+			t.Fatalf("%s: audio differs on frame %d", name, i)
+		}
+	}
+}
+
+func flattenAudio(samples [][2]uint8) []byte {
+	flat := make([]byte, 0, len(samples)*2)
+	for _, sample := range samples {
+		flat = append(flat, sample[0], sample[1])
+	}
+	return flat
+}
+
+func TestSnapshotRestoresExactState(t *testing.T) {
+	gb, sink := newBusyGameBoy(t)
+	recordFrames(gb, sink, 30)
+
+	var state bytes.Buffer
+	if err := gb.Snapshot(&state); err != nil {
+		t.Fatalf("Unable to snapshot state: %v", err)
+	}
+	saved := state.Bytes()
+	reference := recordFrames(gb, sink, 60)
+
+	// Make sure the program is actually exercising the hardware
+	if reference[0].screen == reference[59].screen {
+		t.Fatalf("Expected screen to change while running")
+	}
+	if len(bytes.Trim(flattenAudio(reference[0].audio), "\x00")) == 0 {
+		t.Fatalf("Expected audio output while running")
+	}
+
+	// Restore the same console and run again
+	if err := gb.Restore(bytes.NewReader(saved)); err != nil {
+		t.Fatalf("Unable to restore state: %v", err)
+	}
+	compareFrames(t, "same console", reference, recordFrames(gb, sink, 60))
+
+	// Restore into a brand new console
+	fresh, freshSink := newBusyGameBoy(t)
+	if err := fresh.Restore(bytes.NewReader(saved)); err != nil {
+		t.Fatalf("Unable to restore state into new console: %v", err)
+	}
+	compareFrames(t, "new console", reference, recordFrames(fresh, freshSink, 60))
+}
+
+func TestFailedRestoreLeavesStateUnchanged(t *testing.T) {
+	gb, _ := newBusyGameBoy(t)
+	gb.RunNextFrame()
+	var state bytes.Buffer
+	if err := gb.Snapshot(&state); err != nil {
+		t.Fatalf("Unable to snapshot state: %v", err)
+	}
+	before := state.Bytes()
+
+	// A state from a cartridge without RAM cannot be restored into one with RAM
+	other := newTestGameBoy("COUNTER", counterProgram)
+	var otherState bytes.Buffer
+	if err := other.Snapshot(&otherState); err != nil {
+		t.Fatalf("Unable to snapshot state: %v", err)
+	}
+	if err := gb.Restore(&otherState); err == nil {
+		t.Fatalf("Expected restore to fail with mismatched cartridge RAM")
+	}
+
+	var after bytes.Buffer
+	if err := gb.Snapshot(&after); err != nil {
+		t.Fatalf("Unable to snapshot state: %v", err)
+	}
+	if !bytes.Equal(before, after.Bytes()) {
+		t.Fatalf("State changed after failed restore")
+	}
+}
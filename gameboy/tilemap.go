@@ -0,0 +1,147 @@
+package gameboy
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Size in pixels of the area covered by a tile map
+const TileMapSize = 256
+
+// Colors used to outline the visible parts of a tile map
+var (
+	ViewportOverlayColor = color.RGBA{R: 0xFF, G: 0x00, B: 0x00, A: 0xFF}
+	WindowOverlayColor   = color.RGBA{R: 0x00, G: 0xC0, B: 0x00, A: 0xFF}
+)
+
+// ParseTileMapAddress reads the hex address of a tile map, 9800 or 9C00
+func ParseTileMapAddress(text string) (uint16, error) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(text, "$"), 16, 16)
+	if err != nil || (value != TileMapAddressLow && value != TileMapAddressHigh) {
+		return 0, fmt.Errorf("invalid tile map %q, expected 9800 or 9C00", text)
+	}
+	return uint16(value), nil
+}
+
+// TileMapView describes how the background and window currently use a tile map
+type TileMapView struct {
+	// Start of the tile map, TileMapAddressLow or TileMapAddressHigh
+	Address uint16
+	// Start of the tile data selected by LCDC
+	TileData uint16
+	// Set when the background is drawn from this map, SCX and SCY give the top left of the screen within it
+	Background       bool
+	ScrollX, ScrollY uint8
+	// Set when the window is enabled and drawn from this map, WindowX and WindowY give the screen position of
+	// the window's top left corner (WX-7 and WY)
+	Window           bool
+	WindowX, WindowY int
+}
+
+// TileMapView returns how a tile map is used by the current LCDC and scroll registers
+func (gb *Gameboy) TileMapView(address uint16) TileMapView {
+	control := gb.memory.read(LCDC)
+	// Turning off the background also hides the window
+	enabled := control&LCDC_bg_enable != 0
+	return TileMapView{
+		Address:    address,
+		TileData:   tileDataAddress(control),
+		Background: enabled && bgTileMapAddress(control) == address,
+		ScrollX:    gb.memory.read(SCX),
+		ScrollY:    gb.memory.read(SCY),
+		Window:     enabled && control&LCDC_window_enable != 0 && windowTileMapAddress(control) == address,
+		WindowX:    int(gb.memory.read(WX)) - 7,
+		WindowY:    int(gb.memory.read(WY)),
+	}
+}
+
+// VisibleWindow returns the part of the map shown by the window, which is empty when the window is off screen
+func (v TileMapView) VisibleWindow() image.Rectangle {
+	if !v.Window {
+		return image.Rectangle{}
+	}
+	// The window always starts from the top left of its map, any part left of the screen is cut off
+	visible := image.Rect(-v.WindowX, -v.WindowY, ScreenWidth-v.WindowX, ScreenHeight-v.WindowY)
+	return visible.Intersect(image.Rect(0, 0, TileMapSize, TileMapSize))
+}
+
+// TileMapEntry describes one of the 32x32 entries of a tile map
+type TileMapEntry struct {
+	// Column and row in the map
+	Column, Row int
+	// Address of the map entry and the tile number stored there
+	Address    uint16
+	TileNumber uint8
+	// Tile drawn for this entry with the current tile data selection
+	Tile TileInfo
+}
+
+func (e TileMapEntry) String() string {
+	return fmt.Sprintf("%d,%d at %04X = %02X, %v", e.Column, e.Row, e.Address, e.TileNumber, e.Tile)
+}
+
+// TileMapEntryAt returns the map entry covering a pixel of the map, false if the pixel is outside the map
+func (gb *Gameboy) TileMapEntryAt(address uint16, x, y int) (TileMapEntry, bool) {
+	if x < 0 || y < 0 || x >= TileMapSize || y >= TileMapSize {
+		return TileMapEntry{}, false
+	}
+	entry := TileMapEntry{Column: x / 8, Row: y / 8}
+	entry.Address = address + uint16(entry.Row)*32 + uint16(entry.Column)
+	entry.TileNumber = gb.memory.read(entry.Address)
+	tileAddress := bgTileAddress(tileDataAddress(gb.memory.read(LCDC)), entry.TileNumber)
+	entry.Tile = TileInfoForIndex(int(tileAddress-TileDataAddressLow) / TileBytes)
+	return entry, true
+}
+
+// TileMap draws the full 256x256 area covered by a tile map, using the tile data selected by LCDC and colored
+// with BGP. With overlay set, the area of the map visible through the background is outlined in
+// ViewportOverlayColor (wrapping around the edges as the screen does) and the area visible through the window in
+// WindowOverlayColor
+func (gb *Gameboy) TileMap(address uint16, overlay bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, TileMapSize, TileMapSize))
+	view := gb.TileMapView(address)
+	palette := gb.memory.read(BGP)
+	for x := 0; x < TileMapSize; x++ {
+		for y := 0; y < TileMapSize; y++ {
+			red, green, blue := getColorFromPalette(gb.tileMapPixel(address, view.TileData, uint8(x), uint8(y)), palette)
+			img.SetRGBA(x, y, color.RGBA{R: red, G: green, B: blue, A: 0xFF})
+		}
+	}
+	if !overlay {
+		return img
+	}
+
+	if view.Background {
+		// The viewport wraps, so draw each edge a pixel at a time with coordinates taken modulo the map size
+		left, top := int(view.ScrollX), int(view.ScrollY)
+		for x := 0; x < ScreenWidth; x++ {
+			img.SetRGBA((left+x)%TileMapSize, top, ViewportOverlayColor)
+			img.SetRGBA((left+x)%TileMapSize, (top+ScreenHeight-1)%TileMapSize, ViewportOverlayColor)
+		}
+		for y := 0; y < ScreenHeight; y++ {
+			img.SetRGBA(left, (top+y)%TileMapSize, ViewportOverlayColor)
+			img.SetRGBA((left+ScreenWidth-1)%TileMapSize, (top+y)%TileMapSize, ViewportOverlayColor)
+		}
+	}
+	if window := view.VisibleWindow(); !window.Empty() {
+		for x := window.Min.X; x < window.Max.X; x++ {
+			img.SetRGBA(x, window.Min.Y, WindowOverlayColor)
+			img.SetRGBA(x, window.Max.Y-1, WindowOverlayColor)
+		}
+		for y := window.Min.Y; y < window.Max.Y; y++ {
+			img.SetRGBA(window.Min.X, y, WindowOverlayColor)
+			img.SetRGBA(window.Max.X-1, y, WindowOverlayColor)
+		}
+	}
+	return img
+}
+
+// WriteTileMapPNG writes a tile map drawn by TileMap as a PNG image
+func (gb *Gameboy) WriteTileMapPNG(w io.Writer, address uint16, overlay bool) error {
+	return png.Encode(w, gb.TileMap(address, overlay))
+}
@@ -0,0 +1,100 @@
+package gameboy
+
+import (
+	"image"
+	"testing"
+)
+
+func TestTileMap(t *testing.T) {
+	gb := newTestGameBoy("TILEMAP", nil)
+	// Tile 1 at 8010 is solid color 3, tile 1 at 9010 is solid color 1
+	for row := uint16(0); row < 8; row++ {
+		gb.memory.write(0x8010+row*2, 0xFF)
+		gb.memory.write(0x8010+row*2+1, 0xFF)
+		gb.memory.write(0x9010+row*2, 0xFF)
+		gb.memory.write(0x9010+row*2+1, 0x00)
+	}
+	for address := uint16(TileMapAddressLow); address < TileMapAddressHigh+0x400; address++ {
+		gb.memory.write(address, 0)
+	}
+	// Column 2, row 3 of the low map uses tile 1
+	gb.memory.write(TileMapAddressLow+3*32+2, 1)
+	gb.memory.write(BGP, 0b11100100)
+	gb.memory.write(SCX, 0)
+	gb.memory.write(SCY, 0)
+
+	for _, tc := range []struct {
+		control uint8
+		shade   uint8
+	}{
+		{LCDC_display_enable | LCDC_bg_enable | LCDC_tile_data_select, 0},
+		{LCDC_display_enable | LCDC_bg_enable, 170},
+	} {
+		gb.memory.write(LCDC, tc.control)
+		img := gb.TileMap(TileMapAddressLow, false)
+		if got := img.RGBAAt(2*8+3, 3*8+5); got.R != tc.shade {
+			t.Errorf("LCDC %08b: tile pixel has red %d, expected %d", tc.control, got.R, tc.shade)
+		}
+		if got := img.RGBAAt(2*8+3, 4*8+5); got.R != 255 {
+			t.Errorf("LCDC %08b: empty tile pixel has red %d, expected 255", tc.control, got.R)
+		}
+	}
+
+	entry, ok := gb.TileMapEntryAt(TileMapAddressLow, 2*8+3, 3*8+5)
+	if !ok || entry.Column != 2 || entry.Row != 3 || entry.Address != 0x9862 || entry.TileNumber != 1 ||
+		entry.Tile.Address != 0x9010 {
+		t.Errorf("Unexpected map entry %+v, %v", entry, ok)
+	}
+	if _, ok := gb.TileMapEntryAt(TileMapAddressLow, 256, 0); ok {
+		t.Error("Expected no entry outside the map")
+	}
+}
+
+func TestTileMapOverlay(t *testing.T) {
+	gb := newTestGameBoy("TILEMAP", nil)
+	// Background from the low map scrolled so the viewport wraps, window from the high map
+	gb.memory.write(LCDC, LCDC_display_enable|LCDC_bg_enable|LCDC_window_enable|LCDC_window_map_select)
+	gb.memory.write(SCX, 200)
+	gb.memory.write(SCY, 150)
+	gb.memory.write(WX, 7+60)
+	gb.memory.write(WY, 100)
+
+	bg := gb.TileMap(TileMapAddressLow, true)
+	for _, point := range []image.Point{{200, 150}, {255, 150}, {0, 150}, {103, 150}, {200, 37}, {103, 37}, {200, 0}} {
+		if got := bg.RGBAAt(point.X, point.Y); got != ViewportOverlayColor {
+			t.Errorf("Viewport outline missing at %v, got %v", point, got)
+		}
+	}
+	if got := bg.RGBAAt(104, 150); got == ViewportOverlayColor {
+		t.Error("Viewport outline drawn past the right edge of the screen")
+	}
+
+	view := gb.TileMapView(TileMapAddressHigh)
+	if view.Background || !view.Window {
+		t.Errorf("Unexpected view of the window map %+v", view)
+	}
+	if window := view.VisibleWindow(); window != image.Rect(0, 0, 100, 44) {
+		t.Errorf("Visible window is %v, expected (0,0)-(100,44)", window)
+	}
+	win := gb.TileMap(TileMapAddressHigh, true)
+	if got := win.RGBAAt(99, 43); got != WindowOverlayColor {
+		t.Errorf("Window outline missing at the bottom right corner, got %v", got)
+	}
+
+	// Window is hidden when off the right of the screen
+	gb.memory.write(WX, 7+ScreenWidth)
+	if window := gb.TileMapView(TileMapAddressHigh).VisibleWindow(); !window.Empty() {
+		t.Errorf("Expected no visible window, got %v", window)
+	}
+}
+
+func TestParseTileMapAddress(t *testing.T) {
+	for text, expected := range map[string]uint16{"9800": 0x9800, "9c00": 0x9C00, "$9C00": 0x9C00} {
+		if address, err := ParseTileMapAddress(text); err != nil || address != expected {
+			t.Errorf("ParseTileMapAddress(%q) = %04X, %v", text, address, err)
+		}
+	}
+	if _, err := ParseTileMapAddress("9900"); err == nil {
+		t.Error("Expected an error for an address which is not a tile map")
+	}
+}
@@ -0,0 +1,157 @@
+package gameboy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+/*
+Instruction Trace
+
+While tracing, one line is written before each instruction run by RunNextOpcode in the format used by
+gameboy-doctor and the logs of several other emulators, so traces can be compared line by line:
+
+A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,13,02
+
+PCMEM holds the four bytes starting at PC. Nothing is written while the CPU is halted.
+*/
+
+// TraceOptions limits which instructions are written to a trace
+type TraceOptions struct {
+	// Only trace instructions with a PC in this range, inclusive
+	StartPC uint16
+	EndPC   uint16
+	// Only trace instructions running from this ROM bank, or AnyBank to trace everything
+	Bank int
+	// Stop tracing after this many instructions have been written, 0 for no limit
+	Limit int
+	// Start tracing once this instruction is reached, nil to start immediately
+	After *Breakpoint
+}
+
+// DefaultTraceOptions returns options which trace every instruction
+func DefaultTraceOptions() TraceOptions {
+	return TraceOptions{StartPC: 0x0000, EndPC: 0xFFFF, Bank: AnyBank}
+}
+
+// ParseAddressRange reads an inclusive range of hex addresses written as START-END, or a single address
+func ParseAddressRange(text string) (uint16, uint16, error) {
+	first, last, isRange := strings.Cut(text, "-")
+	start, err := strconv.ParseUint(strings.TrimPrefix(first, "$"), 16, 16)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid address %q in range %q", first, text)
+	}
+	end := start
+	if isRange {
+		if end, err = strconv.ParseUint(strings.TrimPrefix(last, "$"), 16, 16); err != nil {
+			return 0, 0, fmt.Errorf("invalid address %q in range %q", last, text)
+		}
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("address range %q is backwards", text)
+	}
+	return uint16(start), uint16(end), nil
+}
+
+// ParseTraceOptions builds trace options from their text forms, as given on the command line.
+// pcRange is an address range for ParseAddressRange, bank is a hex ROM bank and after is a breakpoint
+// for ParseBreakpoint. Empty strings leave that filter off
+func ParseTraceOptions(pcRange string, bank string, limit int, after string) (TraceOptions, error) {
+	options := DefaultTraceOptions()
+	options.Limit = limit
+	var err error
+	if pcRange != "" {
+		if options.StartPC, options.EndPC, err = ParseAddressRange(pcRange); err != nil {
+			return options, err
+		}
+	}
+	if bank != "" {
+		value, err := strconv.ParseUint(bank, 16, 16)
+		if err != nil {
+			return options, fmt.Errorf("invalid trace bank %q", bank)
+		}
+		options.Bank = int(value)
+	}
+	if after != "" {
+		bp, err := ParseBreakpoint(after)
+		if err != nil {
+			return options, err
+		}
+		options.After = &bp
+	}
+	return options, nil
+}
+
+type tracer struct {
+	w       *bufio.Writer
+	options TraceOptions
+	// Number of lines written so far
+	count int
+	// Set once the After instruction has been reached
+	triggered bool
+}
+
+// StartTrace writes a line to w before each instruction matching the options, until StopTrace is called.
+// Any trace already running is stopped first
+func (gb *Gameboy) StartTrace(w io.Writer, options TraceOptions) {
+	gb.StopTrace()
+	gb.tracer = &tracer{
+		w:         bufio.NewWriter(w),
+		options:   options,
+		triggered: options.After == nil,
+	}
+}
+
+// StopTrace stops tracing, flushing any lines not yet written and returning the first write error
+func (gb *Gameboy) StopTrace() error {
+	if gb.tracer == nil {
+		return nil
+	}
+	err := gb.tracer.w.Flush()
+	gb.tracer = nil
+	return err
+}
+
+// TracedInstructions returns the number of lines written by the current trace
+func (gb *Gameboy) TracedInstructions() int {
+	if gb.tracer == nil {
+		return 0
+	}
+	return gb.tracer.count
+}
+
+// Called before each instruction while tracing
+func (t *tracer) trace(gb *Gameboy) {
+	options := &t.options
+	if options.Limit > 0 && t.count >= options.Limit {
+		return
+	}
+
+	pc := gb.cpu.PC
+	if !t.triggered {
+		if !options.After.matches(gb, pc) {
+			return
+		}
+		t.triggered = true
+	}
+	if pc < options.StartPC || pc > options.EndPC {
+		return
+	}
+	if options.Bank != AnyBank && options.Bank != gb.romBank(pc) {
+		return
+	}
+
+	r := gb.cpu
+	fmt.Fprintf(t.w, "A:%02X F:%02X B:%02X C:%02X D:%02X E:%02X H:%02X L:%02X SP:%04X PC:%04X PCMEM:%02X,%02X,%02X,%02X\n",
+		r.A, r.F, r.B, r.C, r.D, r.E, r.H, r.L, r.SP, pc,
+		gb.memory.read(pc), gb.memory.read(pc+1), gb.memory.read(pc+2), gb.memory.read(pc+3))
+	t.count++
+
+	if t.count == options.Limit {
+		// Nothing more will be written, so make sure the file is complete without waiting for StopTrace
+		t.w.Flush()
+	}
+}
@@ -0,0 +1,88 @@
+package gameboy
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Run the console for a number of instructions while tracing, returning the lines written
+func traceLines(t *testing.T, gb *Gameboy, options TraceOptions, instructions int) []string {
+	var buf bytes.Buffer
+	gb.StartTrace(&buf, options)
+	for i := 0; i < instructions; i++ {
+		gb.stepInstruction()
+	}
+	if err := gb.StopTrace(); err != nil {
+		t.Fatalf("Unable to write trace: %v", err)
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+}
+
+func TestTraceFormat(t *testing.T) {
+	gb := newTestGameBoy("COUNTER", counterProgram)
+	lines := traceLines(t, gb, DefaultTraceOptions(), 4)
+
+	expected := []string{
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0100 PCMEM:00,C3,50,01",
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0101 PCMEM:C3,50,01,00",
+		"A:01 F:B0 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0150 PCMEM:3C,EA,00,C0",
+		"A:02 F:10 B:00 C:13 D:00 E:D8 H:01 L:4D SP:FFFE PC:0151 PCMEM:EA,00,C0,18",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected trace:\n%s\nexpected:\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestTraceFilters(t *testing.T) {
+	// Only the CALL in the loop
+	options := DefaultTraceOptions()
+	options.StartPC, options.EndPC = 0x0155, 0x0157
+	for _, line := range traceLines(t, newBankedGameBoy(), options, 100) {
+		if !strings.Contains(line, "PC:0155") {
+			t.Errorf("Traced instruction outside of PC range: %s", line)
+		}
+	}
+
+	// Bank 2 holds the subroutine which is called, bank 1 is never run
+	options = DefaultTraceOptions()
+	options.Bank = 2
+	lines := traceLines(t, newBankedGameBoy(), options, 100)
+	if len(lines) == 0 || !strings.Contains(lines[0], "PC:4000 PCMEM:04,C5,C1,C9") {
+		t.Errorf("Expected trace to start at the bank 2 subroutine, got %q", lines)
+	}
+	options.Bank = 1
+	if lines := traceLines(t, newBankedGameBoy(), options, 100); len(lines) != 0 {
+		t.Errorf("Expected nothing to be traced from bank 1, got %d lines", len(lines))
+	}
+
+	// Start at the first return from the subroutine, then stop after 3 instructions
+	options = DefaultTraceOptions()
+	options.After = &Breakpoint{Bank: 2, Address: 0x4003}
+	options.Limit = 3
+	lines = traceLines(t, newBankedGameBoy(), options, 100)
+	if len(lines) != 3 || !strings.Contains(lines[0], "PC:4003") || !strings.Contains(lines[1], "PC:0158") {
+		t.Errorf("Unexpected trace after trigger: %q", lines)
+	}
+	options.After = &Breakpoint{Bank: 1, Address: 0x4000}
+	if lines := traceLines(t, newBankedGameBoy(), options, 100); len(lines) != 0 {
+		t.Errorf("Expected trigger in bank 1 to never be reached, got %d lines", len(lines))
+	}
+}
+
+func TestParseAddressRange(t *testing.T) {
+	if start, end, err := ParseAddressRange("0150-$7FFF"); err != nil || start != 0x0150 || end != 0x7FFF {
+		t.Errorf("Unexpected range %04X-%04X (%v)", start, end, err)
+	}
+	if start, end, err := ParseAddressRange("C000"); err != nil || start != 0xC000 || end != 0xC000 {
+		t.Errorf("Unexpected range %04X-%04X (%v)", start, end, err)
+	}
+	for _, bad := range []string{"", "8000-4000", "0150-", "10000"} {
+		if _, _, err := ParseAddressRange(bad); err == nil {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+}
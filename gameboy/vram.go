@@ -0,0 +1,159 @@
+package gameboy
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+)
+
+/*
+VRAM Tiles
+
+Tile data fills 0x8000-0x97FF, 384 tiles of 8x8 pixels at 16 bytes each.
+Sprites always use tiles 0-255 from 0x8000, the background and window use either the same tiles
+or tiles 128-383 with signed tile numbers (LCDC_tile_data_select).
+
+Each row of a tile is 2 bytes, the first holds the least significant bit of each pixel's color index
+and the second the most significant bit, with bit 7 being the leftmost pixel.
+
+The tile sheet shows every tile in address order, 16 tiles per row.
+*/
+
+// Tile data layout
+const (
+	TileCount        = 384
+	TileBytes        = 16
+	TileSheetColumns = 16
+	TileSheetRows    = TileCount / TileSheetColumns
+	TileSheetWidth   = TileSheetColumns * 8
+	TileSheetHeight  = TileSheetRows * 8
+)
+
+// TilePalette selects the palette register used to color tiles in the viewers
+type TilePalette uint16
+
+const (
+	TilePaletteBGP  TilePalette = BGP
+	TilePaletteOBP0 TilePalette = OBP0
+	TilePaletteOBP1 TilePalette = OBP1
+)
+
+// TilePalettes lists the palettes in the order the viewers cycle through them
+var TilePalettes = [...]TilePalette{TilePaletteBGP, TilePaletteOBP0, TilePaletteOBP1}
+
+func (p TilePalette) String() string {
+	switch p {
+	case TilePaletteBGP:
+		return "BGP"
+	case TilePaletteOBP0:
+		return "OBP0"
+	case TilePaletteOBP1:
+		return "OBP1"
+	}
+	return fmt.Sprintf("TilePalette(%04X)", uint16(p))
+}
+
+// MarshalText writes the palette by name, so it reads naturally in JSON
+func (p TilePalette) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// Next returns the palette after this one, wrapping back to BGP
+func (p TilePalette) Next() TilePalette {
+	for i, palette := range TilePalettes {
+		if palette == p {
+			return TilePalettes[(i+1)%len(TilePalettes)]
+		}
+	}
+	return TilePaletteBGP
+}
+
+// ParseTilePalette reads a palette name, one of bgp, obp0 or obp1
+func ParseTilePalette(name string) (TilePalette, error) {
+	for _, palette := range TilePalettes {
+		if strings.EqualFold(name, palette.String()) {
+			return palette, nil
+		}
+	}
+	return TilePaletteBGP, fmt.Errorf("unknown palette %q, expected bgp, obp0 or obp1", name)
+}
+
+// TileInfo identifies a tile in VRAM
+type TileInfo struct {
+	// Position in the tile sheet, 0-383
+	Index int
+	// Address of the tile's first byte
+	Address uint16
+}
+
+// TileInfoForIndex returns the details of tile 0-383
+func TileInfoForIndex(index int) TileInfo {
+	return TileInfo{Index: index, Address: TileDataAddressLow + uint16(index)*TileBytes}
+}
+
+func (t TileInfo) String() string {
+	text := fmt.Sprintf("tile %03X at %04X", t.Index, t.Address)
+	// Describe the tile numbers which select this tile from the background and window maps
+	switch {
+	case t.Index < 128:
+		text += fmt.Sprintf(" (BG %02X with 8000 data)", t.Index)
+	case t.Index < 256:
+		text += fmt.Sprintf(" (BG %02X with either data)", t.Index)
+	default:
+		text += fmt.Sprintf(" (BG %02X with 8800 data)", t.Index-256)
+	}
+	return text
+}
+
+// TileSheetTileAt returns the tile under a pixel of the tile sheet, false if the pixel is outside the sheet
+func TileSheetTileAt(x, y int) (TileInfo, bool) {
+	if x < 0 || y < 0 || x >= TileSheetWidth || y >= TileSheetHeight {
+		return TileInfo{}, false
+	}
+	return TileInfoForIndex((y/8)*TileSheetColumns + x/8), true
+}
+
+// Color index 0-3 of a pixel in a tile row, column 0 being the leftmost pixel
+func tilePixel(lineLSB uint8, lineMSB uint8, column uint8) uint8 {
+	var pixelColor uint8 = 0b00
+	if lineLSB&(0b10000000>>column) != 0 {
+		pixelColor |= 0b01
+	}
+	if lineMSB&(0b10000000>>column) != 0 {
+		pixelColor |= 0b10
+	}
+	return pixelColor
+}
+
+// Draw an 8x8 tile with its top left corner at x, y
+func (gb *Gameboy) drawTile(img *image.RGBA, tileAddress uint16, palette uint8, x int, y int) {
+	var row, column uint8
+	for row = 0; row < 8; row++ {
+		lineLSB := gb.memory.read(tileAddress + uint16(row)*2)
+		lineMSB := gb.memory.read(tileAddress + uint16(row)*2 + 1)
+		for column = 0; column < 8; column++ {
+			red, green, blue := getColorFromPalette(tilePixel(lineLSB, lineMSB, column), palette)
+			img.SetRGBA(x+int(column), y+int(row), color.RGBA{R: red, G: green, B: blue, A: 0xFF})
+		}
+	}
+}
+
+// TileSheet draws every tile in VRAM, colored with the current value of a palette register
+func (gb *Gameboy) TileSheet(palette TilePalette) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, TileSheetWidth, TileSheetHeight))
+	colors := gb.memory.read(uint16(palette))
+	for index := 0; index < TileCount; index++ {
+		x := (index % TileSheetColumns) * 8
+		y := (index / TileSheetColumns) * 8
+		gb.drawTile(img, TileInfoForIndex(index).Address, colors, x, y)
+	}
+	return img
+}
+
+// WriteTileSheetPNG writes the tile sheet as a PNG image
+func (gb *Gameboy) WriteTileSheetPNG(w io.Writer, palette TilePalette) error {
+	return png.Encode(w, gb.TileSheet(palette))
+}
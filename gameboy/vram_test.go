@@ -0,0 +1,106 @@
+package gameboy
+
+import (
+	"bytes"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// Write a tile whose rows each use color indexes 0, 1, 2 and 3 in pairs of columns
+func writeStripedTile(gb *Gameboy, address uint16) {
+	for row := uint16(0); row < 8; row++ {
+		gb.memory.write(address+row*2, 0b00110011)
+		gb.memory.write(address+row*2+1, 0b00001111)
+	}
+}
+
+func TestTileSheet(t *testing.T) {
+	gb := newTestGameBoy("TILES", nil)
+	// Tile 17E is the second to last tile in the last row of the sheet
+	writeStripedTile(gb, 0x97E0)
+	gb.memory.write(BGP, 0b11100100)
+	gb.memory.write(OBP1, 0b00011011)
+
+	sheet := gb.TileSheet(TilePaletteBGP)
+	if size := sheet.Bounds().Size(); size.X != TileSheetWidth || size.Y != TileSheetHeight {
+		t.Fatalf("Tile sheet is %dx%d, expected %dx%d", size.X, size.Y, TileSheetWidth, TileSheetHeight)
+	}
+	x, y := 14*8, 23*8
+	expected := []uint8{255, 170, 85, 0}
+	for column, shade := range []uint8{0, 0, 1, 1, 2, 2, 3, 3} {
+		for row := 0; row < 8; row++ {
+			got := sheet.RGBAAt(x+column, y+row)
+			if got != (color.RGBA{R: expected[shade], G: expected[shade], B: expected[shade], A: 0xFF}) {
+				t.Fatalf("Pixel %d,%d is %v, expected shade %d", column, row, got, shade)
+			}
+		}
+	}
+
+	// The same tile with the inverted palette
+	inverted := gb.TileSheet(TilePaletteOBP1)
+	if got := inverted.RGBAAt(x, y); got.R != 0 {
+		t.Errorf("Color 0 with OBP1 is %v, expected black", got)
+	}
+	if got := inverted.RGBAAt(x+7, y); got.R != 255 {
+		t.Errorf("Color 3 with OBP1 is %v, expected white", got)
+	}
+
+	var buf bytes.Buffer
+	if err := gb.WriteTileSheetPNG(&buf, TilePaletteBGP); err != nil {
+		t.Fatalf("Unable to write PNG: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Unable to read PNG: %v", err)
+	}
+	if r, _, _, _ := img.At(x+4, y).RGBA(); r>>8 != 85 {
+		t.Errorf("PNG pixel has red %d, expected 85", r>>8)
+	}
+}
+
+func TestTileSheetTileAt(t *testing.T) {
+	testcases := []struct {
+		x, y    int
+		ok      bool
+		index   int
+		address uint16
+	}{
+		{0, 0, true, 0, 0x8000},
+		{7, 7, true, 0, 0x8000},
+		{8, 0, true, 1, 0x8010},
+		{0, 8, true, 16, 0x8100},
+		{127, 191, true, 383, 0x97F0},
+		{128, 0, false, 0, 0},
+		{0, -1, false, 0, 0},
+	}
+	for _, tc := range testcases {
+		info, ok := TileSheetTileAt(tc.x, tc.y)
+		if ok != tc.ok || info.Index != tc.index || info.Address != tc.address {
+			t.Errorf("TileSheetTileAt(%d, %d) = %+v, %v, expected index %d at %04X, %v",
+				tc.x, tc.y, info, ok, tc.index, tc.address, tc.ok)
+		}
+	}
+
+	if text := TileInfoForIndex(0x101).String(); text != "tile 101 at 9010 (BG 01 with 8800 data)" {
+		t.Errorf("Unexpected tile description %q", text)
+	}
+}
+
+func TestParseTilePalette(t *testing.T) {
+	for _, palette := range TilePalettes {
+		parsed, err := ParseTilePalette(palette.String())
+		if err != nil || parsed != palette {
+			t.Errorf("ParseTilePalette(%q) = %v, %v", palette.String(), parsed, err)
+		}
+	}
+	if palette, err := ParseTilePalette("obp0"); err != nil || palette != TilePaletteOBP0 {
+		t.Errorf("Lowercase palette name not accepted: %v, %v", palette, err)
+	}
+	if _, err := ParseTilePalette("cgb"); err == nil {
+		t.Error("Expected an error for an unknown palette")
+	}
+	if TilePaletteOBP1.Next() != TilePaletteBGP {
+		t.Error("Palettes should cycle back to BGP")
+	}
+}
@@ -0,0 +1,178 @@
+package gameboy
+
+import (
+	"fmt"
+	"log"
+)
+
+// WatchKind selects which memory accesses trigger a watchpoint
+type WatchKind int
+
+const (
+	// Any read of the address by an instruction
+	WatchRead WatchKind = iota
+	// Any write to the address by an instruction
+	WatchWrite
+	// A write which changes the value stored at the address
+	WatchChange
+)
+
+func (k WatchKind) String() string {
+	switch k {
+	case WatchRead:
+		return "read"
+	case WatchWrite:
+		return "write"
+	case WatchChange:
+		return "change"
+	}
+	return fmt.Sprintf("WatchKind(%d)", int(k))
+}
+
+// WatchAction is what happens when a watchpoint triggers
+type WatchAction int
+
+const (
+	// Stop execution before the next instruction
+	WatchBreak WatchAction = iota
+	// Report the access to the watch handler and keep running
+	WatchLog
+)
+
+// Watchpoint triggers on accesses to a range of addresses made by the instructions the CPU runs.
+// Instruction fetches and accesses made by the rest of the hardware (PPU, timers, interrupts) are not watched
+type Watchpoint struct {
+	// Inclusive range of addresses to watch
+	Start uint16
+	End   uint16
+	Kind  WatchKind
+	// Only trigger when the value read or written is Value
+	HasValue bool
+	Value    uint8
+	Action   WatchAction
+}
+
+func (wp Watchpoint) String() string {
+	text := fmt.Sprintf("%s %04X", wp.Kind, wp.Start)
+	if wp.End != wp.Start {
+		text += fmt.Sprintf("-%04X", wp.End)
+	}
+	if wp.HasValue {
+		text += fmt.Sprintf(" =%02X", wp.Value)
+	}
+	if wp.Action == WatchLog {
+		text += " (log)"
+	}
+	return text
+}
+
+// WatchHit describes an access which triggered a watchpoint
+type WatchHit struct {
+	Watchpoint Watchpoint
+	// Location of the instruction which made the access
+	Location Breakpoint
+	Address  uint16
+	// Value read or written, and for writes the value stored before
+	Value    uint8
+	OldValue uint8
+}
+
+func (hit WatchHit) String() string {
+	if hit.Watchpoint.Kind == WatchRead {
+		return fmt.Sprintf("read %04X=%02X at %v", hit.Address, hit.Value, hit.Location)
+	}
+	return fmt.Sprintf("write %04X=%02X (was %02X) at %v", hit.Address, hit.Value, hit.OldValue, hit.Location)
+}
+
+// AddWatchpoint starts watching memory accesses
+func (d *Debugger) AddWatchpoint(wp Watchpoint) {
+	if wp.End < wp.Start {
+		wp.Start, wp.End = wp.End, wp.Start
+	}
+	d.watchpoints = append(d.watchpoints, wp)
+}
+
+// RemoveWatchpoint removes a watchpoint, returning false if it was not set
+func (d *Debugger) RemoveWatchpoint(wp Watchpoint) bool {
+	for i := range d.watchpoints {
+		if d.watchpoints[i] == wp {
+			d.watchpoints = append(d.watchpoints[:i], d.watchpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ClearWatchpoints removes all watchpoints
+func (d *Debugger) ClearWatchpoints() {
+	d.watchpoints = nil
+}
+
+// Watchpoints returns every watchpoint in the order they were added
+func (d *Debugger) Watchpoints() []Watchpoint {
+	return append([]Watchpoint{}, d.watchpoints...)
+}
+
+// SetWatchHandler sets a function to be called for each access triggering a WatchLog watchpoint.
+// By default accesses are written to the standard logger
+func (d *Debugger) SetWatchHandler(handler func(hit WatchHit)) {
+	if handler == nil {
+		handler = logWatchHit
+	}
+	d.onWatch = handler
+}
+
+func logWatchHit(hit WatchHit) {
+	log.Printf("Watchpoint: %v", hit)
+}
+
+// LastWatchHit returns the access which most recently stopped execution with StopWatchpoint
+func (d *Debugger) LastWatchHit() WatchHit {
+	return d.lastWatchHit
+}
+
+// Run one instruction with memory accesses checked against the watchpoints
+func (d *Debugger) runWatchedOpcode() int {
+	gb := d.gb
+	d.watchLocation = Breakpoint{Bank: gb.romBank(gb.cpu.PC), Address: gb.cpu.PC}
+	gb.memory.watcher = d
+	opcode := gb.popPC()
+	cycles := gb.Opcode(opcode) * 4
+	gb.memory.watcher = nil
+	return cycles
+}
+
+// Called by Memory for each read made by the instruction being run
+func (d *Debugger) memoryRead(address uint16, value uint8) {
+	for _, wp := range d.watchpoints {
+		if wp.Kind == WatchRead && wp.triggeredBy(address, value) {
+			d.watchTriggered(WatchHit{Watchpoint: wp, Location: d.watchLocation, Address: address, Value: value})
+		}
+	}
+}
+
+// Called by Memory for each write made by the instruction being run
+func (d *Debugger) memoryWritten(address uint16, old uint8, value uint8) {
+	for _, wp := range d.watchpoints {
+		if wp.Kind == WatchRead || (wp.Kind == WatchChange && old == value) || !wp.triggeredBy(address, value) {
+			continue
+		}
+		d.watchTriggered(WatchHit{Watchpoint: wp, Location: d.watchLocation, Address: address, Value: value, OldValue: old})
+	}
+}
+
+func (wp *Watchpoint) triggeredBy(address uint16, value uint8) bool {
+	return address >= wp.Start && address <= wp.End && (!wp.HasValue || value == wp.Value)
+}
+
+func (d *Debugger) watchTriggered(hit WatchHit) {
+	if hit.Watchpoint.Action == WatchLog {
+		d.onWatch(hit)
+		return
+	}
+	// Execution can only stop between instructions, so stop before the next one
+	if !d.watchStop {
+		d.watchStop = true
+		d.lastWatchHit = hit
+	}
+}
@@ -0,0 +1,89 @@
+package gameboy
+
+import (
+	"testing"
+)
+
+// Program which keeps writing the same value to C000 and reading C001
+var watchProgram = []uint8{
+	0x3E, 0x07, // loop: LD A,7
+	0xEA, 0x00, 0xC0, // LD (C000),A
+	0xFA, 0x01, 0xC0, // LD A,(C001)
+	0x18, 0xF6, // JR loop
+}
+
+// Run a frame counting the accesses reported for a single WatchLog watchpoint
+func countWatchHits(t *testing.T, wp Watchpoint) []WatchHit {
+	gb := newTestGameBoy("WATCH", watchProgram)
+	d := gb.AttachDebugger()
+	var hits []WatchHit
+	d.SetWatchHandler(func(hit WatchHit) { hits = append(hits, hit) })
+	wp.Action = WatchLog
+	d.AddWatchpoint(wp)
+	gb.RunNextFrame()
+	if d.Paused() {
+		t.Errorf("Logging watchpoint %v stopped execution", wp)
+	}
+	return hits
+}
+
+func TestWatchpointKinds(t *testing.T) {
+	writes := countWatchHits(t, Watchpoint{Start: 0xC000, End: 0xC000, Kind: WatchWrite})
+	if len(writes) < 100 {
+		t.Errorf("Expected a write on every loop, got %d", len(writes))
+	}
+	hit := writes[0]
+	if hit.Location != (Breakpoint{Bank: 0, Address: 0x0152}) || hit.Address != 0xC000 || hit.Value != 0x07 || hit.OldValue != 0x00 {
+		t.Errorf("Unexpected first write: %+v", hit)
+	}
+
+	if changes := countWatchHits(t, Watchpoint{Start: 0xC000, End: 0xC000, Kind: WatchChange}); len(changes) != 1 {
+		t.Errorf("Expected only the first write to change the value, got %d changes", len(changes))
+	}
+
+	reads := countWatchHits(t, Watchpoint{Start: 0xC000, End: 0xC0FF, Kind: WatchRead})
+	if len(reads) != len(writes) || reads[0].Address != 0xC001 || reads[0].Location.Address != 0x0155 {
+		t.Errorf("Expected one read of C001 per loop, got %d reads starting with %+v", len(reads), reads[0])
+	}
+
+	if fetches := countWatchHits(t, Watchpoint{Start: 0x0150, End: 0x015F, Kind: WatchRead}); len(fetches) != 0 {
+		t.Errorf("Expected instruction fetches not to trigger read watchpoints, got %d", len(fetches))
+	}
+	if matched := countWatchHits(t, Watchpoint{Start: 0xC000, End: 0xC000, Kind: WatchWrite, HasValue: true, Value: 0x08}); len(matched) != 0 {
+		t.Errorf("Expected value condition to never match, got %d hits", len(matched))
+	}
+}
+
+func TestWatchpointStopsExecution(t *testing.T) {
+	gb := newTestGameBoy("COUNTER", counterProgram)
+	d := gb.AttachDebugger()
+	var reasons []StopReason
+	d.SetStopHandler(func(reason StopReason) { reasons = append(reasons, reason) })
+
+	// Counter reaches 5 on the 4th loop, A starts at 1
+	d.AddWatchpoint(Watchpoint{Start: 0xC000, End: 0xC000, Kind: WatchWrite, HasValue: true, Value: 0x05})
+	gb.RunNextFrame()
+	if !d.Paused() || len(reasons) != 1 || reasons[0] != StopWatchpoint {
+		t.Fatalf("Expected execution to stop at the watchpoint, got %v", reasons)
+	}
+	// Stopped right after the write, before the jump back
+	if pc := d.Registers().PC; pc != 0x0154 {
+		t.Errorf("Expected to stop after the write at 0154, stopped at %04X", pc)
+	}
+	if hit := d.LastWatchHit(); hit.Location.Address != 0x0151 || hit.Value != 0x05 || hit.OldValue != 0x04 {
+		t.Errorf("Unexpected watchpoint hit: %v", hit)
+	}
+
+	// Stepping over the write reports the watchpoint rather than the step
+	d.RemoveWatchpoint(Watchpoint{Start: 0xC000, End: 0xC000, Kind: WatchWrite, HasValue: true, Value: 0x05})
+	d.AddWatchpoint(Watchpoint{Start: 0xC000, End: 0xC000, Kind: WatchChange})
+	d.StepInto()
+	d.StepInto()
+	d.StepInto()
+	if reasons[len(reasons)-1] != StopWatchpoint || d.Registers().PC != 0x0154 {
+		t.Errorf("Expected step onto the write to report the watchpoint, got %v at %04X", reasons, d.Registers().PC)
+	}
+	if watchpoints := d.Watchpoints(); len(watchpoints) != 1 || watchpoints[0].Kind != WatchChange {
+		t.Errorf("Unexpected watchpoints %v", watchpoints)
+	}
+}
@@ -0,0 +1,124 @@
+package link
+
+import (
+	"github.com/cbott/GoEmulate/gameboy"
+)
+
+/*
+Local Link Cable
+
+Two consoles in the same process are connected by a pair of cable ends, each attached to one console as its link
+peer. Both consoles are run from the same goroutine by a Lockstep scheduler, which always runs whichever console is
+behind for a short slice of cycles, so neither gets more than a slice (plus an instruction) ahead of the other.
+
+Each end works like the TCP peer without the connection: the console publishes whether it is waiting for an
+external clock transfer and the byte in SB every time it polls, and a master's data byte is answered straight away
+from what the other console last published. Since the scheduler decides exactly when each console runs, every run
+from the same starting state transfers the same bytes at the same time.
+*/
+
+// DefaultLockstepCycles is how many machine cycles each console runs for before the scheduler switches to the other
+// one. Shorter slices keep the consoles closer together, a byte transfer takes 4096 cycles
+const DefaultLockstepCycles = 64
+
+// CableEnd is one end of a link cable between two consoles in the same process
+type CableEnd struct {
+	other *CableEnd
+	// State published by the console at its last poll
+	ready bool
+	out   uint8
+	// Byte clocked in by the other console but not yet handed over
+	pending  bool
+	received uint8
+}
+
+// NewCable returns the two ends of a link cable, to be attached to consoles with SetLinkPeer
+func NewCable() (*CableEnd, *CableEnd) {
+	a := &CableEnd{out: 0xFF}
+	b := &CableEnd{out: 0xFF, other: a}
+	a.other = b
+	return a, b
+}
+
+// Exchange sends a byte clocked out by this end's console, returning the other console's byte if it was waiting
+// for a transfer, 0xFF otherwise
+func (e *CableEnd) Exchange(out uint8) uint8 {
+	other := e.other
+	if !other.ready {
+		return 0xFF
+	}
+	// The other console is not ready again until it has received this byte at its next poll
+	other.ready = false
+	other.pending = true
+	other.received = out
+	return other.out
+}
+
+// Poll publishes the console's state and hands over a byte clocked in by the other console since the last poll
+func (e *CableEnd) Poll(out uint8, ready bool) (uint8, bool) {
+	e.out = out
+	e.ready = ready
+	if !e.pending {
+		return 0, false
+	}
+	e.pending = false
+	e.ready = false
+	return e.received, true
+}
+
+// Lockstep runs two consoles connected by a local link cable, keeping them in step with each other
+type Lockstep struct {
+	consoles [2]*gameboy.Gameboy
+	// Total cycles run by each console since they were connected
+	cycles [2]int
+	slice  int
+}
+
+// Connect attaches the consoles to either end of a new local link cable, and returns a scheduler to run them
+func Connect(a, b *gameboy.Gameboy) *Lockstep {
+	endA, endB := NewCable()
+	a.SetLinkPeer(endA)
+	b.SetLinkPeer(endB)
+	return &Lockstep{
+		consoles: [2]*gameboy.Gameboy{a, b},
+		slice:    DefaultLockstepCycles,
+	}
+}
+
+// Consoles returns the two linked consoles, in the order they were connected
+func (l *Lockstep) Consoles() (*gameboy.Gameboy, *gameboy.Gameboy) {
+	return l.consoles[0], l.consoles[1]
+}
+
+// SetSliceCycles sets how many cycles each console runs for at a time, 1 runs a single instruction per slice
+func (l *Lockstep) SetSliceCycles(cycles int) {
+	if cycles < 1 {
+		cycles = 1
+	}
+	l.slice = cycles
+}
+
+// RunNextFrame runs both consoles until each has completed a frame to be displayed.
+// While either console is stopped in its debugger both are held, and the frame is finished by later calls
+func (l *Lockstep) RunNextFrame() {
+	var done [2]bool
+	for !done[0] || !done[1] {
+		// Run whichever console is behind, unless it has already completed its frame
+		i := 0
+		if done[0] || (!done[1] && l.cycles[1] < l.cycles[0]) {
+			i = 1
+		}
+		run, frameDone := l.consoles[i].RunCycles(l.slice)
+		if run == 0 && !frameDone {
+			return
+		}
+		l.cycles[i] += run
+		done[i] = frameDone
+	}
+}
+
+// Disconnect unplugs the cable from both consoles
+func (l *Lockstep) Disconnect() {
+	l.consoles[0].SetLinkPeer(nil)
+	l.consoles[1].SetLinkPeer(nil)
+}
@@ -0,0 +1,123 @@
+package link
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/gameboy"
+)
+
+// Waits a while for the slave to get ready, then sends 0x11 with the internal clock and stores the reply at C000
+var masterProgram = []uint8{
+	0x06, 0x00, // LD B,0
+	0x05,       // DEC B
+	0x20, 0xFD, // JR NZ,-3
+	0x3E, 0x11, // LD A,11
+	0xE0, 0x01, // LDH (SB),A
+	0x3E, 0x81, // LD A,81
+	0xE0, 0x02, // LDH (SC),A
+	0xF0, 0x02, // LDH A,(SC)
+	0xCB, 0x7F, // BIT 7,A
+	0x20, 0xFA, // JR NZ,-6
+	0xF0, 0x01, // LDH A,(SB)
+	0xEA, 0x00, 0xC0, // LD (C000),A
+	0x18, 0xFE, // JR -2
+}
+
+// Waits for an external clock transfer of 0x22 and stores the byte received at C000
+var slaveProgram = []uint8{
+	0x3E, 0x22, // LD A,22
+	0xE0, 0x01, // LDH (SB),A
+	0x3E, 0x80, // LD A,80
+	0xE0, 0x02, // LDH (SC),A
+	0xF0, 0x02, // LDH A,(SC)
+	0xCB, 0x7F, // BIT 7,A
+	0x20, 0xFA, // JR NZ,-6
+	0xF0, 0x01, // LDH A,(SB)
+	0xEA, 0x00, 0xC0, // LD (C000),A
+	0x18, 0xFE, // JR -2
+}
+
+// Create a Game Boy running a ROM only cartridge which jumps to program at 0x150
+func newTestGameBoy(title string, program []uint8) *gameboy.Gameboy {
+	rom := make([]uint8, 2*cartridges.ROMBankSize)
+	copy(rom[0x100:], []uint8{0x00, 0xC3, 0x50, 0x01}) // NOP; JP 0150
+	copy(rom[cartridges.TitleAddress:cartridges.TitleAddress+cartridges.TitleLength], title)
+	copy(rom[0x150:], program)
+
+	gb := gameboy.NewGameBoy(true, false)
+	gb.LoadCartridge(cartridges.NewROMOnlyCartridge(rom))
+	return gb
+}
+
+func TestCableTransfer(t *testing.T) {
+	master, slave := NewCable()
+
+	slave.Poll(0x22, false)
+	if in := master.Exchange(0x11); in != 0xFF {
+		t.Errorf("Master received %02X from a slave which was not ready, expected FF", in)
+	}
+
+	slave.Poll(0x22, true)
+	if in := master.Exchange(0x33); in != 0x22 {
+		t.Errorf("Master received %02X, expected 22", in)
+	}
+	if in := master.Exchange(0x44); in != 0xFF {
+		t.Errorf("Master received %02X before the slave took the last byte, expected FF", in)
+	}
+	if in, ok := slave.Poll(0x22, true); !ok || in != 0x33 {
+		t.Errorf("Slave received %02X, %v, expected 33", in, ok)
+	}
+	if _, ok := slave.Poll(0x33, true); ok {
+		t.Error("Slave received the same byte twice")
+	}
+}
+
+func TestLockstepTransfer(t *testing.T) {
+	master := newTestGameBoy("MASTER", masterProgram)
+	slave := newTestGameBoy("SLAVE", slaveProgram)
+	linked := Connect(master, slave)
+	linked.RunNextFrame()
+
+	if value := master.ReadMemory(0xC000); value != 0x22 {
+		t.Errorf("Master received %02X, expected 22", value)
+	}
+	if value := slave.ReadMemory(0xC000); value != 0x11 {
+		t.Errorf("Slave received %02X, expected 11", value)
+	}
+	for _, gb := range []*gameboy.Gameboy{master, slave} {
+		if gb.ReadMemory(gameboy.IF)&gameboy.Interrupt_serial == 0 {
+			t.Error("Serial interrupt not requested")
+		}
+	}
+
+	linked.Disconnect()
+	if master.LinkPeer() != nil || slave.LinkPeer() != nil {
+		t.Error("Consoles still have a link peer after disconnecting")
+	}
+}
+
+func TestLockstepDeterministic(t *testing.T) {
+	var states [2][]byte
+	for run := range states {
+		master := newTestGameBoy("MASTER", masterProgram)
+		slave := newTestGameBoy("SLAVE", slaveProgram)
+		linked := Connect(master, slave)
+		linked.SetSliceCycles(1)
+		for i := 0; i < 3; i++ {
+			linked.RunNextFrame()
+		}
+
+		var buf bytes.Buffer
+		for _, gb := range []*gameboy.Gameboy{master, slave} {
+			if err := gb.Snapshot(&buf); err != nil {
+				t.Fatalf("Unable to encode state: %v", err)
+			}
+		}
+		states[run] = buf.Bytes()
+	}
+	if !bytes.Equal(states[0], states[1]) {
+		t.Error("Linked consoles ended in different states from the same start")
+	}
+}
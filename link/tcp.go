@@ -0,0 +1,294 @@
+// Package link connects the serial ports of consoles running in separate processes, so two players can trade or
+// play versus modes over a TCP connection standing in for the link cable
+package link
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+Protocol
+
+Both ends first send the handshake "GBLINK" followed by the protocol version byte, and drop the connection if the
+other end's handshake differs. After that every message is 2 bytes, a type and a value:
+
+'D' data   the sender's console clocked out a byte with its internal clock (it is the master for this transfer)
+'R' reply  the byte shifted back in answer to the last data message, 0xFF if the console was not ready
+
+The master's console keeps running while it shifts the byte out (4096 cycles, about 1ms) and only stops if the reply
+has not arrived by then, waiting at most the reply timeout. Once a reply times out the other end is treated as
+stalled, and transfers read 0xFF straight away until it answers again, so a paused or frozen peer costs the console
+one timeout rather than one per byte.
+
+This one byte handshake is the only synchronization between the consoles. Each runs at its own frame rate and
+their clocks drift apart freely between transfers, which suits games where the slave waits on an external clock
+for each byte, but not ones which expect the other console to have got through a set amount of work in between.
+So the reply does not wait for the other console's emulation loop to come round, each end publishes whether its
+console is waiting for an external clock transfer and the byte in SB every time the console polls the link.
+Data messages are answered straight away from that, and the byte received is handed to the console at its next
+poll. Once a console is waiting for an external clock it leaves SB alone until the transfer completes, so the
+published byte is still current when the reply is sent.
+*/
+
+const (
+	msgData  = 'D'
+	msgReply = 'R'
+
+	protocolVersion = 1
+
+	// How long to wait for the other end's handshake
+	handshakeTimeout = 10 * time.Second
+)
+
+// DefaultReplyTimeout is how long a transfer waits for a reply by default before reading 0xFF
+const DefaultReplyTimeout = 250 * time.Millisecond
+
+var handshake = []byte{'G', 'B', 'L', 'I', 'N', 'K', protocolVersion}
+
+// TCPPeer is a link cable peer talking to a console in another process
+type TCPPeer struct {
+	conn net.Conn
+	// Serializes writes from the console and the connection reader
+	writeMutex sync.Mutex
+	// Replies to data messages sent by this end
+	replies chan uint8
+	// Only used by the console: how long to wait for a reply, whether a data message is awaiting its reply,
+	// and whether the other end failed to reply in time and has not answered since
+	replyTimeout time.Duration
+	exchanging   bool
+	stalled      bool
+	// Closed once the connection has been lost
+	closed chan struct{}
+	err    error
+
+	// State published by the console at its last poll, and a byte clocked in by the other end but not yet handed over
+	stateMutex sync.Mutex
+	ready      bool
+	out        uint8
+	pending    bool
+	received   uint8
+}
+
+// Listen waits for another emulator to connect on a TCP address such as ":5000", then returns the link to it
+func Listen(address string) (*TCPPeer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	conn, err := listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewTCPPeer(conn)
+}
+
+// Dial connects to another emulator waiting with Listen
+func Dial(address string) (*TCPPeer, error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewTCPPeer(conn)
+}
+
+// NewTCPPeer performs the handshake over an established connection and starts serving the other end's transfers.
+// The connection is closed if the handshake fails
+func NewTCPPeer(conn net.Conn) (*TCPPeer, error) {
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		// Messages are tiny and each one is waited on
+		tcp.SetNoDelay(true)
+	}
+	if err := exchangeHandshake(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	p := &TCPPeer{
+		conn:         conn,
+		replies:      make(chan uint8, 1),
+		closed:       make(chan struct{}),
+		replyTimeout: DefaultReplyTimeout,
+	}
+	go p.readMessages()
+	return p, nil
+}
+
+// Send our handshake and check the other end's
+func exchangeHandshake(conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write(handshake); err != nil {
+		return err
+	}
+	received := make([]byte, len(handshake))
+	if _, err := io.ReadFull(conn, received); err != nil {
+		return fmt.Errorf("no link cable handshake from %v: %v", conn.RemoteAddr(), err)
+	}
+	if !bytes.Equal(received[:len(received)-1], handshake[:len(handshake)-1]) {
+		return fmt.Errorf("%v is not a link cable peer", conn.RemoteAddr())
+	}
+	if version := received[len(received)-1]; version != protocolVersion {
+		return fmt.Errorf("%v uses link protocol version %d, expected %d", conn.RemoteAddr(), version, protocolVersion)
+	}
+	return nil
+}
+
+// RemoteAddr returns the address of the other emulator
+func (p *TCPPeer) RemoteAddr() net.Addr {
+	return p.conn.RemoteAddr()
+}
+
+// Closed returns a channel which is closed once the connection has been lost
+func (p *TCPPeer) Closed() <-chan struct{} {
+	return p.closed
+}
+
+// Err returns why the connection was lost, nil while it is open
+func (p *TCPPeer) Err() error {
+	select {
+	case <-p.closed:
+		return p.err
+	default:
+		return nil
+	}
+}
+
+// Close disconnects from the other emulator
+func (p *TCPPeer) Close() error {
+	return p.conn.Close()
+}
+
+// SetReplyTimeout sets how long a transfer waits for the other end's reply before reading 0xFF, which should allow
+// for the round trip time of the connection. It must not be called while the console is running
+func (p *TCPPeer) SetReplyTimeout(timeout time.Duration) {
+	p.replyTimeout = timeout
+}
+
+// Exchange sends a byte clocked by this console and waits for the other console's byte
+func (p *TCPPeer) Exchange(out uint8) uint8 {
+	p.StartExchange(out)
+	return p.FinishExchange()
+}
+
+// StartExchange sends a byte clocked by this console, the reply is collected by FinishExchange
+func (p *TCPPeer) StartExchange(out uint8) {
+	// Discard a reply which arrived after an earlier exchange gave up on it, the other end is answering again
+	select {
+	case <-p.replies:
+		p.stalled = false
+	default:
+	}
+	p.exchanging = p.send(msgData, out) == nil
+}
+
+// FinishExchange returns the other console's byte for the last StartExchange, waiting for it unless the other
+// end is stalled
+func (p *TCPPeer) FinishExchange() uint8 {
+	if !p.exchanging {
+		return 0xFF
+	}
+	p.exchanging = false
+
+	if p.stalled {
+		select {
+		case in := <-p.replies:
+			p.stalled = false
+			return in
+		default:
+			return 0xFF
+		}
+	}
+
+	timeout := time.NewTimer(p.replyTimeout)
+	defer timeout.Stop()
+	select {
+	case in := <-p.replies:
+		return in
+	case <-p.closed:
+	case <-timeout.C:
+		log.Printf("Link cable: no reply within %v, not waiting for replies until the other emulator answers", p.replyTimeout)
+		p.stalled = true
+	}
+	return 0xFF
+}
+
+// Poll publishes the console's serial state for answering the other end, and hands over a byte it clocked in
+func (p *TCPPeer) Poll(out uint8, ready bool) (uint8, bool) {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if p.pending {
+		p.pending = false
+		return p.received, true
+	}
+	p.ready = ready
+	p.out = out
+	return 0, false
+}
+
+// Handle messages from the other end until the connection is lost
+func (p *TCPPeer) readMessages() {
+	var err error
+	defer func() {
+		p.err = err
+		close(p.closed)
+		p.conn.Close()
+	}()
+
+	var message [2]byte
+	for {
+		if _, err = io.ReadFull(p.conn, message[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = errors.New("the other emulator disconnected")
+			}
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Link cable disconnected: %v", err)
+			}
+			return
+		}
+		switch message[0] {
+		case msgData:
+			if err = p.send(msgReply, p.clockIn(message[1])); err != nil {
+				return
+			}
+		case msgReply:
+			select {
+			case p.replies <- message[1]:
+			default:
+				// Nothing is waiting for more than one reply
+			}
+		default:
+			err = fmt.Errorf("unknown link message type %02X", message[0])
+			log.Printf("Link cable disconnected: %v", err)
+			return
+		}
+	}
+}
+
+// Take a byte clocked in by the other end if the console is ready for it, returning the byte shifted back
+func (p *TCPPeer) clockIn(in uint8) uint8 {
+	p.stateMutex.Lock()
+	defer p.stateMutex.Unlock()
+	if !p.ready || p.pending {
+		return 0xFF
+	}
+	// The console is no longer ready once it has the byte, the next poll hands it over
+	p.ready = false
+	p.pending = true
+	p.received = in
+	return p.out
+}
+
+func (p *TCPPeer) send(messageType uint8, value uint8) error {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	_, err := p.conn.Write([]byte{messageType, value})
+	return err
+}
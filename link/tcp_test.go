@@ -0,0 +1,188 @@
+package link
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Connect two peers over localhost
+func connectedPeers(t *testing.T) (*TCPPeer, *TCPPeer) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan *TCPPeer)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			accepted <- nil
+			return
+		}
+		peer, err := NewTCPPeer(conn)
+		if err != nil {
+			t.Error(err)
+		}
+		accepted <- peer
+	}()
+
+	dialed, err := Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listening := <-accepted
+	if listening == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		dialed.Close()
+		listening.Close()
+	})
+	return dialed, listening
+}
+
+func TestTCPTransfer(t *testing.T) {
+	master, slave := connectedPeers(t)
+
+	// The slave is not waiting for a transfer yet
+	slave.Poll(0x22, false)
+	if in := master.Exchange(0x11); in != 0xFF {
+		t.Errorf("Master received %02X from a slave which was not ready, expected FF", in)
+	}
+	if _, ok := slave.Poll(0x22, false); ok {
+		t.Error("Slave received a byte while not ready")
+	}
+
+	slave.Poll(0x22, true)
+	if in := master.Exchange(0x33); in != 0x22 {
+		t.Errorf("Master received %02X, expected 22", in)
+	}
+	if in, ok := slave.Poll(0x22, true); !ok || in != 0x33 {
+		t.Errorf("Slave received %02X, %v, expected 33", in, ok)
+	}
+
+	// The transfer completed so the slave is not ready for another until it polls again
+	if in := master.Exchange(0x44); in != 0xFF {
+		t.Errorf("Master received %02X before the slave was ready again, expected FF", in)
+	}
+}
+
+func TestTCPBothMasters(t *testing.T) {
+	a, b := connectedPeers(t)
+	a.Poll(0x01, false)
+	b.Poll(0x02, false)
+
+	// Neither console waits on an external clock, so each gets nothing back rather than waiting on the other
+	results := make(chan uint8, 2)
+	go func() { results <- a.Exchange(0x01) }()
+	go func() { results <- b.Exchange(0x02) }()
+	for i := 0; i < 2; i++ {
+		select {
+		case in := <-results:
+			if in != 0xFF {
+				t.Errorf("Received %02X, expected FF", in)
+			}
+		case <-time.After(DefaultReplyTimeout / 2):
+			t.Fatal("Exchange waited for the other master")
+		}
+	}
+}
+
+func TestTCPDisconnect(t *testing.T) {
+	master, slave := connectedPeers(t)
+	slave.Close()
+
+	select {
+	case <-master.Closed():
+	case <-time.After(time.Second):
+		t.Fatal("Disconnect not noticed")
+	}
+	if master.Err() == nil {
+		t.Error("Expected an error describing the disconnect")
+	}
+	if in := master.Exchange(0x11); in != 0xFF {
+		t.Errorf("Received %02X after disconnecting, expected FF", in)
+	}
+}
+
+func TestTCPStalledPeer(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// The other end completes the handshake and then only answers when told to
+	answer := make(chan uint8)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if err := exchangeHandshake(conn); err != nil {
+			return
+		}
+		for value := range answer {
+			conn.Write([]byte{msgReply, value})
+		}
+	}()
+	defer close(answer)
+
+	master, err := Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	const timeout = 50 * time.Millisecond
+	master.SetReplyTimeout(timeout)
+
+	timeExchange := func() (uint8, time.Duration) {
+		start := time.Now()
+		in := master.Exchange(0x11)
+		return in, time.Since(start)
+	}
+	if in, took := timeExchange(); in != 0xFF || took < timeout {
+		t.Fatalf("Received %02X after %v, expected FF after the %v timeout", in, took, timeout)
+	}
+	// Once stalled, further transfers don't wait
+	for i := 0; i < 3; i++ {
+		if in, took := timeExchange(); in != 0xFF || took >= timeout {
+			t.Fatalf("Received %02X after %v from a stalled peer, expected FF without waiting", in, took)
+		}
+	}
+
+	// A late reply shows the other end is answering again, so the next transfer waits for its reply
+	answer <- 0x42
+	time.Sleep(timeout)
+	go func() {
+		time.Sleep(timeout / 5)
+		answer <- 0x24
+	}()
+	if in, _ := timeExchange(); in != 0x24 {
+		t.Fatalf("Received %02X, expected 24 once the peer answered again", in)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("GET / HTTP/1.1\r\n"))
+	}()
+
+	if _, err := Dial(listener.Addr().String()); err == nil {
+		t.Error("Expected an error connecting to something other than an emulator")
+	}
+}
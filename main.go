@@ -3,11 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log"
 	"os"
 	"time"
 
 	"github.com/cbott/GoEmulate/cartridges"
+	"github.com/cbott/GoEmulate/dap"
 	"github.com/cbott/GoEmulate/gameboy"
+	"github.com/cbott/GoEmulate/link"
+	"github.com/cbott/GoEmulate/printer"
+	"github.com/cbott/GoEmulate/sound"
+	"github.com/cbott/GoEmulate/sound/otosink"
 	"github.com/gopxl/pixel/v2"
 	"github.com/gopxl/pixel/v2/backends/opengl"
 )
@@ -18,11 +24,49 @@ const DefaultScale = 4
 // Maximum allowable speed multiplier for emulation
 const MaximumSpeed = 10
 
+// Default length of rewind history in seconds
+const DefaultRewindSeconds = 30
+
+// Length of each tone from the HuC3 speaker
+const HuC3ToneDuration = 150 * time.Millisecond
+
+// Pitch of a HuC3 speaker tone, the cartridge's real sounds aren't documented so each tone number gets its own note
+func huc3ToneFrequency(tone uint8) int {
+	return 440 + 110*int(tone&0xF)
+}
+
+// Play tones from a HuC3 cartridge's speaker through the audio output, if there is one
+func connectSpeaker(cartridge cartridges.Cartridge, tones *sound.ToneSink) {
+	huc3, ok := cartridge.(*cartridges.HuC3Cartridge)
+	if !ok || tones == nil {
+		return
+	}
+	huc3.SetToneCallback(func(tone uint8) { tones.Play(huc3ToneFrequency(tone), HuC3ToneDuration) })
+}
+
 func run() {
 	// Parse cmd line args
 	runBootROM := flag.Bool("bootrom", false, "run boot ROM prior to cartridge")
 	useDebugColors := flag.Bool("debug", false, "use debug colors (color sprites red, window green, background blue)")
 	scaleflag := flag.Int("scale", DefaultScale, "window scale factor")
+	loadState := flag.String("load-state", "", "save state file to load at startup")
+	rewindSeconds := flag.Int("rewind", DefaultRewindSeconds, "seconds of rewind history to keep (0 to disable)")
+	recordFile := flag.String("record", "", "record input to a movie file, starting from power-on or the -load-state state")
+	movieFile := flag.String("movie", "", "play back an input movie file")
+	debugConsoleFlag := flag.Bool("debug-console", false, "start paused with a debugger console on the terminal")
+	rewindInterval := flag.Int("rewind-interval", gameboy.DefaultRewindInterval, "frames between rewind snapshots")
+	traceFile := flag.String("trace", "", "file to write an instruction trace to, in gameboy-doctor format")
+	tracePC := flag.String("trace-pc", "", "only trace instructions in this hex address range, such as 0150-3FFF")
+	traceBank := flag.String("trace-bank", "", "only trace instructions running from this hex ROM bank")
+	traceLimit := flag.Int("trace-limit", 0, "stop tracing after this many instructions (0 for no limit)")
+	traceAfter := flag.String("trace-after", "", "start tracing once this [BB:]ADDR instruction is reached")
+	dapAddress := flag.String("dap", "", "serve the Debug Adapter Protocol on this TCP address, such as localhost:4711")
+	cheatsFlag := flag.String("cheats", "", "cheat file to load (default rom.gb.cht if it exists)")
+	linkListen := flag.String("link-listen", "", "wait for another emulator to connect a link cable on this TCP address, such as :5000")
+	linkConnect := flag.String("link-connect", "", "connect a link cable to another emulator listening on this TCP address")
+	linkTimeout := flag.Duration("link-timeout", link.DefaultReplyTimeout, "how long a link cable transfer waits for the other emulator's reply")
+	linkLocal := flag.String("link-local", "", "run a second ROM beside the first in the same window, connected by a link cable")
+	printerDir := flag.String("printer", "", "connect a Game Boy Printer to the link port, writing printed pages to this directory")
 	flag.Parse()
 
 	romFile := flag.Arg(0)
@@ -30,12 +74,53 @@ func run() {
 		fmt.Println("ROM file must be specified")
 		os.Exit(1)
 	}
+	if *debugConsoleFlag && *dapAddress != "" {
+		fmt.Println("Only one of -debug-console and -dap can be used")
+		os.Exit(1)
+	}
+	if *movieFile != "" && *recordFile != "" {
+		fmt.Println("Only one of -movie and -record can be used")
+		os.Exit(1)
+	}
+	linkFlags := 0
+	for _, value := range []string{*linkListen, *linkConnect, *linkLocal, *printerDir} {
+		if value != "" {
+			linkFlags++
+		}
+	}
+	if linkFlags > 1 {
+		fmt.Println("Only one of -link-listen, -link-connect, -link-local and -printer can be used")
+		os.Exit(1)
+	}
+
+	// Connect the link cable before opening the window, as listening waits for the other emulator
+	var linkPeer *link.TCPPeer
+	if *linkListen != "" || *linkConnect != "" {
+		var err error
+		if *linkListen != "" {
+			fmt.Printf("Waiting for a link cable connection on %s\n", *linkListen)
+			linkPeer, err = link.Listen(*linkListen)
+		} else {
+			linkPeer, err = link.Dial(*linkConnect)
+		}
+		if err != nil {
+			fmt.Printf("Unable to connect link cable: %v\n", err)
+			os.Exit(1)
+		}
+		defer linkPeer.Close()
+		linkPeer.SetReplyTimeout(*linkTimeout)
+		fmt.Printf("Link cable connected to %v\n", linkPeer.RemoteAddr())
+	}
 
 	// Construct Pixel window
 	var scale float64 = float64(*scaleflag)
+	screens := 1
+	if *linkLocal != "" {
+		screens = 2
+	}
 	cfg := opengl.WindowConfig{
 		Title:     "Game Boy Emulator",
-		Bounds:    pixel.R(0, 0, gameboy.ScreenWidth*scale, gameboy.ScreenHeight*scale),
+		Bounds:    pixel.R(0, 0, float64(screens)*gameboy.ScreenWidth*scale, gameboy.ScreenHeight*scale),
 		VSync:     true,
 		Resizable: true,
 	}
@@ -46,12 +131,153 @@ func run() {
 
 	// Construct Game Boy emulator
 	gb := gameboy.NewGameBoy(!*runBootROM, *useDebugColors)
-	gb.LoadCartridge(cartridges.Make(romFile))
+	cartridge, err := cartridges.Load(romFile)
+	if err != nil {
+		fmt.Printf("Unable to load ROM: %v\n", err)
+		os.Exit(1)
+	}
+	gb.LoadCartridge(cartridge)
+	if linkPeer != nil {
+		gb.SetLinkPeer(linkPeer)
+	}
+	var gbPrinter *printer.Printer
+	if *printerDir != "" {
+		gbPrinter, err = printer.New(*printerDir)
+		if err != nil {
+			fmt.Printf("Unable to connect printer: %v\n", err)
+			os.Exit(1)
+		}
+		gbPrinter.SetPageCallback(func(filename string) { fmt.Printf("Printed to %s\n", filename) })
+		gb.SetLinkPeer(gbPrinter)
+	}
+	if *loadState != "" {
+		if err := loadStateFile(gb, *loadState); err != nil {
+			fmt.Printf("Unable to load save state %s: %v\n", *loadState, err)
+			os.Exit(1)
+		}
+	}
+	cheatFile := *cheatsFlag
+	if cheatFile == "" {
+		cheatFile = cheatFileName(romFile)
+	}
+	if err := loadCheatFile(gb, cheatFile); err == nil {
+		fmt.Printf("Loaded %d cheats from %s\n", len(gb.Cheats()), cheatFile)
+	} else if *cheatsFlag != "" || !os.IsNotExist(err) {
+		fmt.Printf("Unable to load cheats %s: %v\n", cheatFile, err)
+		os.Exit(1)
+	}
+	if *movieFile != "" {
+		if err := playMovieFile(gb, *movieFile); err != nil {
+			fmt.Printf("Unable to play movie %s: %v\n", *movieFile, err)
+			os.Exit(1)
+		}
+	}
+	if *recordFile != "" {
+		if err := gb.StartRecording(*loadState != ""); err != nil {
+			fmt.Printf("Unable to start recording: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	// Rewind is off whenever anything is on the link cable, as it would not rewind along with the console
+	if *rewindSeconds > 0 && linkFlags == 0 {
+		gb.EnableRewind(*rewindSeconds)
+		gb.SetRewindInterval(*rewindInterval)
+	}
+	if *traceFile != "" {
+		options, err := gameboy.ParseTraceOptions(*tracePC, *traceBank, *traceLimit, *traceAfter)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		f, err := os.Create(*traceFile)
+		if err != nil {
+			fmt.Printf("Unable to create trace file %s: %v\n", *traceFile, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		gb.StartTrace(f, options)
+	}
+	// Tones from the cartridge's speaker are mixed into the console's sound
+	var tones *sound.ToneSink
+	audio, err := otosink.New()
+	if err != nil {
+		log.Printf("Audio initialization error, continuing without sound: %v", err)
+	} else {
+		tones = sound.NewToneSink(audio)
+		gb.SetAudioSink(tones)
+	}
+	connectSpeaker(cartridge, tones)
 
 	emulator := Emulator{
 		console: gb,
 		window:  win,
 		speed:   1,
+		romFile: romFile,
+
+		playingMovie: *movieFile != "",
+	}
+	if *linkLocal != "" {
+		// The second console is silent, so its sound doesn't mix with the first's
+		emulator.second = gameboy.NewGameBoy(!*runBootROM, *useDebugColors)
+		secondCartridge, err := cartridges.Load(*linkLocal)
+		if err != nil {
+			fmt.Printf("Unable to load ROM for -link-local: %v\n", err)
+			os.Exit(1)
+		}
+		emulator.second.LoadCartridge(secondCartridge)
+		// Cartridges with infrared ports face each other too
+		irA, okA := cartridge.(cartridges.InfraredCartridge)
+		irB, okB := secondCartridge.(cartridges.InfraredCartridge)
+		if okA && okB {
+			endA, endB := cartridges.NewInfraredPair()
+			irA.SetInfrared(endA)
+			irB.SetInfrared(endB)
+		}
+		emulator.secondRomFile = *linkLocal
+		emulator.linked = link.Connect(gb, emulator.second)
+		fmt.Println("Tab switches which console the keyboard controls")
+	}
+	if *debugConsoleFlag {
+		emulator.debugConsole = newDebugConsole(gb)
+		emulator.debugConsole.cheatFile = cheatFile
+	}
+	if *dapAddress != "" {
+		emulator.dapServer = dap.NewServer(gb)
+		// Launch requests restart the emulator with a new ROM, keeping the settings from the command line
+		emulator.dapServer.SetLauncher(func(program string) (*gameboy.Gameboy, error) {
+			// These stay attached to the console started from the command line, so can't follow a new ROM
+			if *recordFile != "" || *movieFile != "" || *traceFile != "" || *linkLocal != "" {
+				return nil, fmt.Errorf("launch can't be used with -record, -movie, -trace or -link-local, use attach")
+			}
+			launched, err := cartridges.Load(program)
+			if err != nil {
+				return nil, err
+			}
+			console := gameboy.NewGameBoy(!*runBootROM, *useDebugColors)
+			console.LoadCartridge(launched)
+			if tones != nil {
+				console.SetAudioSink(tones)
+			}
+			connectSpeaker(launched, tones)
+			if linkPeer != nil {
+				console.SetLinkPeer(linkPeer)
+			}
+			if gbPrinter != nil {
+				console.SetLinkPeer(gbPrinter)
+			}
+			if *rewindSeconds > 0 && linkFlags == 0 {
+				console.EnableRewind(*rewindSeconds)
+				console.SetRewindInterval(*rewindInterval)
+			}
+			emulator.console = console
+			emulator.romFile = program
+			return console, nil
+		})
+		if err := emulator.dapServer.Listen(*dapAddress); err != nil {
+			fmt.Printf("Unable to start DAP server: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Debug Adapter Protocol server listening on %v\n", emulator.dapServer.Addr())
 	}
 
 	// Ticker will execute once per Game Boy frame
@@ -65,6 +291,17 @@ func run() {
 		default:
 		}
 	}
+
+	if *recordFile != "" {
+		if err := writeMovieFile(gb.StopRecording(), *recordFile); err != nil {
+			fmt.Printf("Unable to write movie %s: %v\n", *recordFile, err)
+		} else {
+			fmt.Printf("Wrote movie to %s\n", *recordFile)
+		}
+	}
+	if err := gb.StopTrace(); err != nil {
+		fmt.Printf("Unable to write trace %s: %v\n", *traceFile, err)
+	}
 }
 
 func main() {
@@ -0,0 +1,372 @@
+// Package printer emulates the Game Boy Printer, a link cable device which prints images sent by the console.
+// Each printed page is written to a PNG file
+package printer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+/*
+Protocol
+
+The console is always the master, clocking each byte of a packet out to the printer which shifts back 0x00 until
+the last two bytes of the packet:
+
+	0x88 0x33   magic bytes
+	command     1 initialize, 2 print, 4 image data, F status
+	compression 1 if the data is run length encoded
+	length      2 bytes, least significant first
+	data        length bytes
+	checksum    2 bytes, least significant first, the sum of the command to the end of the data
+	0x00        the printer replies 0x81 to show it is connected
+	0x00        the printer replies with its status
+
+Image data is in the tile format used in VRAM, 20 tiles to a row across the 160 pixel wide paper and sent 2 rows of
+tiles at a time. A data packet with no data marks the end of the image. Compressed data is a series of runs, each
+starting with a byte N: if bit 7 is set the next byte is repeated (N&0x7F)+2 times, otherwise the next N+1 bytes are
+copied as they are.
+
+The print command's 4 data bytes are the number of sheets, the margins (feed before the image in the upper nibble,
+after it in the lower nibble), the palette (2 bits per color like BGP, 0 meaning the default E4) and the exposure,
+which is ignored here. Images printed without a margin after them continue on the same page, so a page is only
+finished once paper is fed out after an image. A print command with no sheets only feeds the paper by its margins,
+which also finishes the page if there is a margin after. The page's PNG file is rewritten after every print on it.
+*/
+
+const (
+	CommandInit   = 0x01
+	CommandPrint  = 0x02
+	CommandData   = 0x04
+	CommandStatus = 0x0F
+
+	// Status bits
+	StatusChecksumError = 1 << 0
+	StatusBusy          = 1 << 1
+	StatusImageFull     = 1 << 2
+	StatusUnprocessed   = 1 << 3
+	StatusPacketError   = 1 << 4
+
+	// Reply to the first byte after the checksum
+	aliveReply = 0x81
+
+	magic1 = 0x88
+	magic2 = 0x33
+
+	// Paper width in pixels and tiles
+	PaperWidth = 160
+	tilesWide  = PaperWidth / 8
+	tileBytes  = 16
+	// The printer holds up to 9 data packets of 2 tile rows each
+	BufferSize = 9 * 2 * tilesWide * tileBytes
+
+	// Pixel rows fed for each unit of margin
+	MarginHeight = 8
+	// Number of status polls the printer reports being busy for after printing
+	busyPolls = 4
+
+	defaultPalette = 0xE4
+)
+
+// Gray levels of the 4 shades, from white to black
+var shades = [4]uint8{255, 170, 85, 0}
+
+// Stages of receiving a packet
+type packetState int
+
+const (
+	stateMagic1 packetState = iota
+	stateMagic2
+	stateCommand
+	stateCompression
+	stateLengthLow
+	stateLengthHigh
+	stateData
+	stateChecksumLow
+	stateChecksumHigh
+	stateAlive
+	stateStatus
+)
+
+// Printer is a Game Boy Printer to be attached to a console's link port with SetLinkPeer
+type Printer struct {
+	// Directory the pages are written to, and the number of the page being printed
+	dir  string
+	page int
+	// Image printed so far on the current page, nil if nothing has been
+	paper *image.Gray
+
+	// Packet being received
+	state      packetState
+	command    uint8
+	compressed bool
+	length     int
+	data       []uint8
+	checksum   uint16
+	received   uint16
+
+	// Image data waiting to be printed, decompressed
+	buffer []uint8
+	// Status bits latched until the next packet, and polls left until the printer is no longer busy
+	errors uint8
+	busy   int
+	// Called with the file name each time a page is written, if set
+	onPage func(filename string)
+}
+
+// New returns a printer writing its pages to dir, which is created if needed. Pages are numbered on from any
+// already in the directory
+func New(dir string) (*Printer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	p := &Printer{dir: dir, page: 1}
+	for {
+		if _, err := os.Stat(p.PageFileName()); os.IsNotExist(err) {
+			break
+		}
+		p.page++
+	}
+	return p, nil
+}
+
+// PageFileName returns the name of the file the current page is written to
+func (p *Printer) PageFileName() string {
+	return filepath.Join(p.dir, fmt.Sprintf("print_%04d.png", p.page))
+}
+
+// SetPageCallback sets a function to be called with the file name each time a page is written
+func (p *Printer) SetPageCallback(callback func(filename string)) {
+	p.onPage = callback
+}
+
+// Exchange receives a byte of a packet from the console and returns the printer's reply
+func (p *Printer) Exchange(out uint8) uint8 {
+	var reply uint8
+	switch p.state {
+	case stateMagic1:
+		if out == magic1 {
+			p.state = stateMagic2
+		}
+	case stateMagic2:
+		if out == magic2 {
+			p.state = stateCommand
+		} else if out != magic1 {
+			p.state = stateMagic1
+		}
+	case stateCommand:
+		p.command = out
+		p.checksum = uint16(out)
+		p.state = stateCompression
+	case stateCompression:
+		p.compressed = out&1 != 0
+		p.checksum += uint16(out)
+		p.state = stateLengthLow
+	case stateLengthLow:
+		p.length = int(out)
+		p.checksum += uint16(out)
+		p.state = stateLengthHigh
+	case stateLengthHigh:
+		p.length |= int(out) << 8
+		p.checksum += uint16(out)
+		p.data = p.data[:0]
+		p.state = stateData
+		if p.length == 0 {
+			p.state = stateChecksumLow
+		}
+	case stateData:
+		p.data = append(p.data, out)
+		p.checksum += uint16(out)
+		if len(p.data) == p.length {
+			p.state = stateChecksumLow
+		}
+	case stateChecksumLow:
+		p.received = uint16(out)
+		p.state = stateChecksumHigh
+	case stateChecksumHigh:
+		p.received |= uint16(out) << 8
+		p.errors = 0
+		if p.received != p.checksum {
+			p.errors |= StatusChecksumError
+		} else {
+			p.runCommand()
+		}
+		p.state = stateAlive
+	case stateAlive:
+		reply = aliveReply
+		p.state = stateStatus
+	case stateStatus:
+		reply = p.Status()
+		if p.busy > 0 {
+			p.busy--
+		}
+		p.state = stateMagic1
+	}
+	return reply
+}
+
+// Poll does nothing, as the printer never clocks a transfer itself
+func (p *Printer) Poll(out uint8, ready bool) (uint8, bool) {
+	return 0, false
+}
+
+// Status returns the status byte the printer would currently reply with
+func (p *Printer) Status() uint8 {
+	status := p.errors
+	if p.busy > 0 {
+		status |= StatusBusy
+	}
+	if len(p.buffer) >= BufferSize {
+		status |= StatusImageFull
+	}
+	if len(p.buffer) > 0 {
+		status |= StatusUnprocessed
+	}
+	return status
+}
+
+// Carry out a packet which was received correctly
+func (p *Printer) runCommand() {
+	switch p.command {
+	case CommandInit:
+		p.buffer = p.buffer[:0]
+		p.busy = 0
+	case CommandData:
+		data := p.data
+		if p.compressed {
+			data = decompress(data)
+		}
+		if len(p.buffer)+len(data) > BufferSize {
+			p.errors |= StatusPacketError
+			data = data[:BufferSize-len(p.buffer)]
+		}
+		p.buffer = append(p.buffer, data...)
+	case CommandPrint:
+		if len(p.data) != 4 {
+			p.errors |= StatusPacketError
+			return
+		}
+		sheets, margins, palette := p.data[0], p.data[1], p.data[2]
+		if palette == 0 {
+			palette = defaultPalette
+		}
+		// No sheets only feeds the paper by the margins
+		var strip *image.Gray
+		if sheets > 0 {
+			strip = renderStrip(p.buffer, palette)
+		}
+		p.print(strip, int(margins>>4), int(margins&0x0F))
+		p.buffer = p.buffer[:0]
+		p.busy = busyPolls
+	case CommandStatus:
+	default:
+		p.errors |= StatusPacketError
+	}
+}
+
+// Expand run length encoded image data
+func decompress(data []uint8) []uint8 {
+	var out []uint8
+	for i := 0; i < len(data); {
+		control := data[i]
+		i++
+		if control&0x80 != 0 {
+			if i >= len(data) {
+				break
+			}
+			for n := 0; n < int(control&0x7F)+2; n++ {
+				out = append(out, data[i])
+			}
+			i++
+		} else {
+			end := i + int(control) + 1
+			if end > len(data) {
+				end = len(data)
+			}
+			out = append(out, data[i:end]...)
+			i = end
+		}
+	}
+	return out
+}
+
+// Add an image to the page with margins before and after it, and write the page out. With no image only the margins
+// are fed, which leaves nothing to write if no page has been started.
+// A margin after the image finishes the page, so the next image starts a new one
+func (p *Printer) print(strip *image.Gray, before, after int) {
+	if strip == nil && p.paper == nil {
+		return
+	}
+	stripHeight := 0
+	if strip != nil {
+		stripHeight = strip.Bounds().Dy()
+	}
+	height := before*MarginHeight + stripHeight + after*MarginHeight
+	top := 0
+	if p.paper != nil {
+		top = p.paper.Bounds().Dy()
+	}
+
+	// Grow the page to fit the new image, the margins are blank paper
+	page := image.NewGray(image.Rect(0, 0, PaperWidth, top+height))
+	for i := range page.Pix {
+		page.Pix[i] = shades[0]
+	}
+	if p.paper != nil {
+		copy(page.Pix, p.paper.Pix)
+	}
+	for y := 0; y < stripHeight; y++ {
+		copy(page.Pix[page.PixOffset(0, top+before*MarginHeight+y):], strip.Pix[strip.PixOffset(0, y):strip.PixOffset(PaperWidth, y)])
+	}
+	p.paper = page
+
+	if err := p.writePage(); err != nil {
+		log.Printf("Unable to write printed page: %v", err)
+	}
+	if after > 0 {
+		p.paper = nil
+		p.page++
+	}
+}
+
+// Write the current page to its PNG file
+func (p *Printer) writePage() error {
+	filename := p.PageFileName()
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, p.paper)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && p.onPage != nil {
+		p.onPage(filename)
+	}
+	return err
+}
+
+// Draw image data, which is in rows of 20 tiles, with a palette selecting the shade of each color
+func renderStrip(data []uint8, palette uint8) *image.Gray {
+	rows := len(data) / (tilesWide * tileBytes)
+	img := image.NewGray(image.Rect(0, 0, PaperWidth, rows*8))
+	for tile := 0; tile < rows*tilesWide; tile++ {
+		x0 := (tile % tilesWide) * 8
+		y0 := (tile / tilesWide) * 8
+		for row := 0; row < 8; row++ {
+			lineLSB := data[tile*tileBytes+row*2]
+			lineMSB := data[tile*tileBytes+row*2+1]
+			for column := 0; column < 8; column++ {
+				colorIndex := (lineLSB>>(7-column))&1 | ((lineMSB>>(7-column))&1)<<1
+				shade := (palette >> (colorIndex * 2)) & 0b11
+				img.SetGray(x0+column, y0+row, color.Gray{Y: shades[shade]})
+			}
+		}
+	}
+	return img
+}
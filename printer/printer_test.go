@@ -0,0 +1,198 @@
+package printer
+
+import (
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Send a packet to the printer, returning the alive and status replies
+func sendPacket(p *Printer, command uint8, compressed bool, data []uint8) (uint8, uint8) {
+	var compression uint8
+	if compressed {
+		compression = 1
+	}
+	header := []uint8{command, compression, uint8(len(data)), uint8(len(data) >> 8)}
+	checksum := uint16(0)
+	for _, b := range append(header, data...) {
+		checksum += uint16(b)
+	}
+
+	for _, b := range []uint8{magic1, magic2} {
+		p.Exchange(b)
+	}
+	for _, b := range header {
+		p.Exchange(b)
+	}
+	for _, b := range data {
+		p.Exchange(b)
+	}
+	p.Exchange(uint8(checksum))
+	p.Exchange(uint8(checksum >> 8))
+	return p.Exchange(0), p.Exchange(0)
+}
+
+// Two rows of tiles, the first all color 3 and the second all color 1
+func testImageData() []uint8 {
+	data := make([]uint8, 2*tilesWide*tileBytes)
+	for i := 0; i < tilesWide*tileBytes; i++ {
+		data[i] = 0xFF
+	}
+	for i := tilesWide * tileBytes; i < len(data); i += 2 {
+		data[i] = 0xFF
+	}
+	return data
+}
+
+func TestPrintPage(t *testing.T) {
+	dir := t.TempDir()
+	p, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []string
+	p.SetPageCallback(func(filename string) { written = append(written, filename) })
+
+	if alive, status := sendPacket(p, CommandInit, false, nil); alive != aliveReply || status != 0 {
+		t.Errorf("Init replied %02X %02X, expected 81 00", alive, status)
+	}
+	if _, status := sendPacket(p, CommandData, false, testImageData()); status != StatusUnprocessed {
+		t.Errorf("Status after data is %02X, expected %02X", status, StatusUnprocessed)
+	}
+	sendPacket(p, CommandData, false, nil)
+	// No margin after, so the next image goes on the same page
+	sendPacket(p, CommandPrint, false, []uint8{1, 0x10, 0xE4, 0x40})
+	if _, status := sendPacket(p, CommandStatus, false, nil); status&StatusBusy == 0 {
+		t.Errorf("Status after printing is %02X, expected busy", status)
+	}
+
+	// Second image with the colors reversed
+	sendPacket(p, CommandInit, false, nil)
+	sendPacket(p, CommandData, false, testImageData())
+	sendPacket(p, CommandPrint, false, []uint8{1, 0x02, 0x1B, 0x40})
+	for i := 0; i < busyPolls; i++ {
+		sendPacket(p, CommandStatus, false, nil)
+	}
+	if _, status := sendPacket(p, CommandStatus, false, nil); status != 0 {
+		t.Errorf("Status once printing finished is %02X, expected 00", status)
+	}
+
+	page := filepath.Join(dir, "print_0001.png")
+	if len(written) != 2 || written[0] != page || written[1] != page {
+		t.Fatalf("Pages written %v, expected %s twice", written, page)
+	}
+	if name := p.PageFileName(); name != filepath.Join(dir, "print_0002.png") {
+		t.Errorf("Next page is %s, expected print_0002.png", name)
+	}
+
+	f, err := os.Open(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1 margin, 2 rows, 2 rows, 2 margins
+	if size := img.Bounds().Size(); size.X != PaperWidth || size.Y != 7*MarginHeight {
+		t.Fatalf("Page is %dx%d, expected %dx%d", size.X, size.Y, PaperWidth, 7*MarginHeight)
+	}
+	expected := map[int]uint8{0: 255, 8: 0, 16: 170, 24: 255, 32: 85, 40: 255, 48: 255}
+	for y, shade := range expected {
+		r, _, _, _ := img.At(5, y).RGBA()
+		if uint8(r>>8) != shade {
+			t.Errorf("Shade at row %d is %d, expected %d", y, r>>8, shade)
+		}
+	}
+}
+
+func TestPrintFeed(t *testing.T) {
+	dir := t.TempDir()
+	p, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written []string
+	p.SetPageCallback(func(filename string) { written = append(written, filename) })
+
+	// Feeding paper before anything is printed leaves nothing to write
+	sendPacket(p, CommandPrint, false, []uint8{0, 0x03, 0xE4, 0x40})
+	if len(written) != 0 {
+		t.Fatalf("Pages written %v by a feed with no page", written)
+	}
+
+	// An image with no margin after it, then a feed with no sheets to finish the page
+	sendPacket(p, CommandInit, false, nil)
+	sendPacket(p, CommandData, false, testImageData())
+	sendPacket(p, CommandPrint, false, []uint8{1, 0x00, 0xE4, 0x40})
+	sendPacket(p, CommandPrint, false, []uint8{0, 0x13, 0xE4, 0x40})
+
+	page := filepath.Join(dir, "print_0001.png")
+	if len(written) != 2 || written[1] != page {
+		t.Fatalf("Pages written %v, expected %s twice", written, page)
+	}
+	if name := p.PageFileName(); name != filepath.Join(dir, "print_0002.png") {
+		t.Errorf("Next page is %s, expected the feed to finish the page", name)
+	}
+
+	f, err := os.Open(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2 rows of image, then 1 margin before and 3 after from the feed
+	if size := img.Bounds().Size(); size.Y != 6*MarginHeight {
+		t.Fatalf("Page is %d rows high, expected %d", size.Y, 6*MarginHeight)
+	}
+	if r, _, _, _ := img.At(5, 8).RGBA(); uint8(r>>8) != 170 {
+		t.Errorf("Shade at row 8 is %d, expected the image", r>>8)
+	}
+	if r, _, _, _ := img.At(5, 5*MarginHeight).RGBA(); uint8(r>>8) != 255 {
+		t.Errorf("Shade in the fed margin is %d, expected blank paper", r>>8)
+	}
+}
+
+func TestPrinterErrors(t *testing.T) {
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the checksum
+	for _, b := range []uint8{magic1, magic2, CommandStatus, 0, 0, 0, 0x00, 0x00} {
+		p.Exchange(b)
+	}
+	if alive, status := p.Exchange(0), p.Exchange(0); alive != aliveReply || status != StatusChecksumError {
+		t.Errorf("Bad checksum replied %02X %02X, expected 81 %02X", alive, status, StatusChecksumError)
+	}
+	if _, status := sendPacket(p, CommandStatus, false, nil); status != 0 {
+		t.Errorf("Checksum error not cleared by the next packet, status %02X", status)
+	}
+	if _, status := sendPacket(p, 0x55, false, nil); status != StatusPacketError {
+		t.Errorf("Unknown command status %02X, expected %02X", status, StatusPacketError)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	compressed := []uint8{0x81, 0xAA, 0x02, 1, 2, 3, 0x80, 0x55}
+	expected := []uint8{0xAA, 0xAA, 0xAA, 1, 2, 3, 0x55, 0x55}
+	out := decompress(compressed)
+	if string(out) != string(expected) {
+		t.Errorf("Decompressed %X, expected %X", out, expected)
+	}
+
+	p, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendPacket(p, CommandData, true, compressed)
+	if string(p.buffer) != string(expected) {
+		t.Errorf("Buffered %X from compressed data, expected %X", p.buffer, expected)
+	}
+}
@@ -0,0 +1,51 @@
+// Package snapshot defines how emulator components save and restore their internal state
+package snapshot
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Snapshotter is implemented by every emulator component whose state is part of a save state
+type Snapshotter interface {
+	// Snapshot writes the component's complete internal state to w
+	Snapshot(w io.Writer) error
+	// Restore replaces the component's internal state with one previously written by Snapshot
+	Restore(r io.Reader) error
+}
+
+// Write each value to w in order using little endian encoding
+// Values must be fixed size data for encoding/binary, or an int/*int which is stored as 64 bits
+func Write(w io.Writer, fields ...interface{}) error {
+	for _, field := range fields {
+		switch v := field.(type) {
+		case int:
+			field = int64(v)
+		case *int:
+			field = int64(*v)
+		}
+		if err := binary.Write(w, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read into each value from r in order, the counterpart to Write
+// Values must be pointers to fixed size data for encoding/binary, or an *int
+func Read(r io.Reader, fields ...interface{}) error {
+	for _, field := range fields {
+		if v, ok := field.(*int); ok {
+			var value int64
+			if err := binary.Read(r, binary.LittleEndian, &value); err != nil {
+				return err
+			}
+			*v = int(value)
+			continue
+		}
+		if err := binary.Read(r, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
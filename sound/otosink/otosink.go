@@ -0,0 +1,79 @@
+// Package otosink plays Game Boy audio through the system audio device using oto
+package otosink
+
+import (
+	"log"
+	"time"
+
+	"github.com/cbott/GoEmulate/sound"
+	"github.com/hajimehoshi/oto"
+)
+
+// Sink is a sound.AudioSink which plays samples on the system audio device.
+// oto only allows one context per process, so only one Sink can be created
+type Sink struct {
+	// Queue for audio samples to be passed from the main Game Boy process into the audio player
+	samples chan [2]uint8
+}
+
+// New opens the system audio device and starts the audio player
+func New() (*Sink, error) {
+	// Context Settings
+	// 44100 Hz Sample rate: Standard audio frequency
+	// 2 channels: This is stereo audio
+	// 1 Byte bit depth: Game Boy audio channels have 8-bit output
+	// Buffer size * 2 due to each sample being 2 bytes
+	context, err := oto.NewContext(sound.AudioSampleRate, 2, 1, sound.SamplesToBuffer*2)
+	if err != nil {
+		return nil, err
+	}
+
+	// Size is set to hold 2 frames of audio to give some margin to mis-timing
+	// but limit audio lag to a single frame
+	s := &Sink{samples: make(chan [2]uint8, sound.AudioSampleRate/30)} // 44100 samples/sec / 60 frames/sec * 2
+
+	// Start the go function which will continually pull samples from the queue and play them
+	go s.play(context)
+	return s, nil
+}
+
+// WriteSamples queues samples for playback
+func (s *Sink) WriteSamples(samples [][2]uint8) {
+	for _, sample := range samples {
+		// If the queue is full skip this sample
+		// This occurs during speed-up when samples are generated faster than they are played
+		select {
+		case s.samples <- sample:
+		default:
+		}
+	}
+}
+
+// Blocking function which creates an oto player from the provided context and continually feeds it
+// queued samples. Should be called as a goroutine.
+func (s *Sink) play(context *oto.Context) {
+	player := context.NewPlayer()
+	// We will run our ticker at double speed to prevent crackling that seems to happen
+	// sometimes when running at exactly the rate we expect samples to be generated
+	ticker := time.NewTicker(time.Second / time.Duration(2*sound.AudioSampleRate/sound.SamplesToBuffer)) // 1/300th second
+
+	var reading [2]byte
+	var buffer []byte
+	for range ticker.C {
+		fbLen := len(s.samples)
+		if fbLen >= sound.SamplesToBuffer {
+			// If we have collected a full buffer's worth of samples, write them to an array and send to Player
+			newBuffer := make([]byte, sound.SamplesToBuffer*2)
+			for i := 0; i < sound.SamplesToBuffer*2; i += 2 {
+				reading = <-s.samples
+				newBuffer[i], newBuffer[i+1] = reading[0], reading[1]
+			}
+			buffer = newBuffer
+		}
+
+		_, err := player.Write(buffer)
+		if err != nil {
+			log.Printf("error sampling: %v", err)
+		}
+	}
+}
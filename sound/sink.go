@@ -0,0 +1,27 @@
+package sound
+
+// AudioSink receives the stereo samples generated by the APU
+type AudioSink interface {
+	// WriteSamples is passed a batch of [left, right] samples at AudioSampleRate.
+	// The APU reuses the slice once WriteSamples returns, so sinks must copy any samples they keep
+	WriteSamples(samples [][2]uint8)
+}
+
+// NullSink discards all audio
+type NullSink struct{}
+
+func (NullSink) WriteSamples(samples [][2]uint8) {}
+
+// BufferSink keeps every sample it receives in memory
+type BufferSink struct {
+	Samples [][2]uint8
+}
+
+func (b *BufferSink) WriteSamples(samples [][2]uint8) {
+	b.Samples = append(b.Samples, samples...)
+}
+
+// Reset discards all samples collected so far
+func (b *BufferSink) Reset() {
+	b.Samples = b.Samples[:0]
+}
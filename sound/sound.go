@@ -1,10 +1,9 @@
 package sound
 
 import (
-	"log"
-	"time"
+	"io"
 
-	"github.com/hajimehoshi/oto"
+	"github.com/cbott/GoEmulate/snapshot"
 )
 
 // Control for the Audio Processing Unit (APU)
@@ -67,7 +66,8 @@ const (
 const (
 	// Actual sample rate for sound played out of your speakers
 	AudioSampleRate = 44100
-	// Size of the player's buffer, set to be 1/150th second of audio (reasonable number, divides sample rate nicely)
+	// Number of samples passed to the audio sink at once, set to be 1/150th second of audio
+	// (reasonable number, divides sample rate nicely)
 	SamplesToBuffer = 294
 	CyclesPerSample = 4194304 / float64(AudioSampleRate)
 )
@@ -85,7 +85,9 @@ type APU struct {
 	channel3 *SoundChannel
 	channel4 *SoundChannel
 
-	audioBuffer chan [2]uint8
+	// Samples are collected into batches before being passed to the sink
+	sink    AudioSink
+	samples [][2]uint8
 
 	// NR51 controls whether or not to mix each of the sound channels into the left and right audio outputs
 	nr51RegisterValue uint8
@@ -98,10 +100,9 @@ type APU struct {
 func NewAPU(waveRAM []uint8) *APU {
 	apu := &APU{}
 
-	// audioBuffer channel is the queue for audio samples to be passed from the main Game Boy process
-	// into the audio player. Size is set to hold 2 frames of audio to give some margin to mis-timing
-	// but limit audio lag to a single frame
-	apu.audioBuffer = make(chan [2]uint8, AudioSampleRate/30) // 44100 samples/sec / 60 frames/sec * 2
+	// Audio is discarded until a sink is attached
+	apu.sink = NullSink{}
+	apu.samples = make([][2]uint8, 0, SamplesToBuffer)
 
 	// Initialize our sound channels
 	apu.channel1 = &SoundChannel{channelNumber: 1}
@@ -112,49 +113,54 @@ func NewAPU(waveRAM []uint8) *APU {
 	// Set up channel 3 to point to the wave RAM slice that was passed in
 	apu.channel3.waveRAM = waveRAM
 
-	// Context Settings
-	// 44100 Hz Sample rate: Standard audio frequency
-	// 2 channels: This is stereo audio
-	// 1 Byte bit depth: Game Boy audio channels have 8-bit output
-	// Buffer size * 2 due to each sample being 2 bytes
-	context, err := oto.NewContext(AudioSampleRate, 2, 1, SamplesToBuffer*2)
-	if err != nil {
-		log.Fatalf("Audio initialization error: %v", err)
-	} else {
-		// Start the go function which will continually pull samples from the audio buffer and play them
-		go startAudioPlayer(context, apu.audioBuffer)
+	return apu
+}
+
+// SetSink sets the destination for samples generated by the APU, nil discards all audio
+func (apu *APU) SetSink(sink AudioSink) {
+	if sink == nil {
+		sink = NullSink{}
 	}
+	apu.sink = sink
+}
 
-	return apu
+// Flush passes any samples collected so far to the sink, even if a full batch is not ready
+func (apu *APU) Flush() {
+	if len(apu.samples) == 0 {
+		return
+	}
+	apu.sink.WriteSamples(apu.samples)
+	apu.samples = apu.samples[:0]
 }
 
-// Blocking function which creates an oto player from the provided context and continually feeds it
-// samples from the provided channel. Should be called as a goroutine.
-func startAudioPlayer(context *oto.Context, samplesChannel chan [2]uint8) {
-	player := context.NewPlayer()
-	// We will run our ticker at double speed to prevent crackling that seems to happen
-	// sometimes when running at exactly the rate we expect samples to be generated
-	ticker := time.NewTicker(time.Second / time.Duration(2*AudioSampleRate/SamplesToBuffer)) // 1/300th second
-
-	var reading [2]byte
-	var buffer []byte
-	for range ticker.C {
-		fbLen := len(samplesChannel)
-		if fbLen >= SamplesToBuffer {
-			// If we have collected a full buffer's worth of samples, write them to an array and send to Player
-			newBuffer := make([]byte, SamplesToBuffer*2)
-			for i := 0; i < SamplesToBuffer*2; i += 2 {
-				reading = <-samplesChannel
-				newBuffer[i], newBuffer[i+1] = reading[0], reading[1]
-			}
-			buffer = newBuffer
+// Snapshot writes the state of the APU and all of its sound channels
+// Samples which have not yet been passed to the sink are not included
+func (apu *APU) Snapshot(w io.Writer) error {
+	err := snapshot.Write(w, apu.on, apu.cycleCounter, apu.nr51RegisterValue, apu.leftVolume, apu.rightVolume)
+	if err != nil {
+		return err
+	}
+	for _, channel := range []*SoundChannel{apu.channel1, apu.channel2, apu.channel3, apu.channel4} {
+		if err := channel.Snapshot(w); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		_, err := player.Write(buffer)
-		if err != nil {
-			log.Printf("error sampling: %v", err)
+// Restore reads state written by Snapshot, discarding any samples not yet passed to the sink
+func (apu *APU) Restore(r io.Reader) error {
+	err := snapshot.Read(r, &apu.on, &apu.cycleCounter, &apu.nr51RegisterValue, &apu.leftVolume, &apu.rightVolume)
+	if err != nil {
+		return err
+	}
+	for _, channel := range []*SoundChannel{apu.channel1, apu.channel2, apu.channel3, apu.channel4} {
+		if err := channel.Restore(r); err != nil {
+			return err
 		}
 	}
+	apu.samples = apu.samples[:0]
+	return nil
 }
 
 // Set APU to the state it would be in after boot ROM runs
@@ -240,11 +246,9 @@ func (apu *APU) RunAudioProcess(cycles int) {
 	var left uint8 = uint8(leftUnscaled * float64(apu.leftVolume+1) / 8.0)
 	var right uint8 = uint8(rightUnscaled * float64(apu.rightVolume+1) / 8.0)
 
-	// If the audio buffer is full skip this sample
-	// This occurs during speed-up when samples are generated faster than they are played
-	select {
-	case apu.audioBuffer <- [2]uint8{left, right}:
-	default:
+	apu.samples = append(apu.samples, [2]uint8{left, right})
+	if len(apu.samples) >= SamplesToBuffer {
+		apu.Flush()
 	}
 }
 
@@ -1,7 +1,10 @@
 package sound
 
 import (
+	"io"
 	"math"
+
+	"github.com/cbott/GoEmulate/snapshot"
 )
 
 const (
@@ -283,3 +286,27 @@ func (c *SoundChannel) GetSample() uint8 {
 
 	return output
 }
+
+// Pointers to every field of the channel's state, in the order they are saved
+func (c *SoundChannel) stateFields() []interface{} {
+	return []interface{}{
+		&c.on,
+		&c.duty, &c.nr10RegisterValue, &c.sweepTime, &c.sweepTimeCounter, &c.sweepSlope, &c.sweepDirection,
+		&c.frequencyValue, &c.waveCounter, &c.nrX2RegisterValue,
+		&c.initialSoundLength, &c.soundLengthEnable, &c.lengthCounter,
+		&c.initialVolumeEnvelope, &c.volumeEnvelopeDirection, &c.volumeSweepPace, &c.currentVolume, &c.volumeEnvelopeCounter,
+		&c.outputLevel,
+		&c.shiftRegister, &c.shiftRegisterWidth, &c.shiftRegisterClockShift, &c.shiftRegisterClockRatio,
+	}
+}
+
+// Snapshot writes the channel state
+// Wave RAM is part of Game Boy memory so it is not included
+func (c *SoundChannel) Snapshot(w io.Writer) error {
+	return snapshot.Write(w, c.stateFields()...)
+}
+
+// Restore reads state written by Snapshot
+func (c *SoundChannel) Restore(r io.Reader) error {
+	return snapshot.Read(r, c.stateFields()...)
+}
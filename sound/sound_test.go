@@ -0,0 +1,132 @@
+package sound
+
+import (
+	"testing"
+	"time"
+)
+
+// Create an APU which records its output
+func newTestAPU() (*APU, *BufferSink) {
+	sink := &BufferSink{}
+	apu := NewAPU(make([]uint8, WaveRAMSize))
+	apu.SetSink(sink)
+	return apu, sink
+}
+
+// Turn on the APU and start a square wave on channel 2, mixed only into the given NR51 outputs
+func playChannel2(apu *APU, panning uint8) {
+	apu.WriteTo(NR52, NR52_apu_enable)
+	apu.WriteTo(NR50, 0x77)
+	apu.WriteTo(NR51, panning)
+	apu.WriteTo(NR21, 0b10000000) // 50% duty
+	apu.WriteTo(NR22, 0xF0)       // full volume, no envelope
+	apu.WriteTo(NR23, 0x00)
+	apu.WriteTo(NR24, 0x87) // trigger
+}
+
+// Run the APU for the given number of samples worth of CPU cycles, 4 cycles at a time
+func runSamples(apu *APU, samples int) {
+	cycles := int(float64(samples) * CyclesPerSample)
+	for i := 0; i < cycles; i += 4 {
+		apu.RunAudioProcess(4)
+	}
+}
+
+func TestAPUSinkReceivesBatches(t *testing.T) {
+	apu, sink := newTestAPU()
+	playChannel2(apu, NR51_mix_ch2_right|NR51_mix_ch2_left)
+
+	runSamples(apu, SamplesToBuffer+10)
+	if len(sink.Samples) != SamplesToBuffer {
+		t.Fatalf("Expected one full batch of %d samples before flush, got %d", SamplesToBuffer, len(sink.Samples))
+	}
+
+	apu.Flush()
+	if len(sink.Samples) < SamplesToBuffer+9 || len(sink.Samples) > SamplesToBuffer+10 {
+		t.Fatalf("Expected about %d samples after flush, got %d", SamplesToBuffer+10, len(sink.Samples))
+	}
+}
+
+func TestAPUNoOutputWhenOff(t *testing.T) {
+	apu, sink := newTestAPU()
+	runSamples(apu, 1000)
+	apu.Flush()
+	if len(sink.Samples) != 0 {
+		t.Fatalf("Expected no samples while APU is off, got %d", len(sink.Samples))
+	}
+}
+
+func TestAPUPanning(t *testing.T) {
+	apu, sink := newTestAPU()
+	playChannel2(apu, NR51_mix_ch2_right)
+	runSamples(apu, 1000)
+	apu.Flush()
+
+	var rightNonZero bool
+	for i, sample := range sink.Samples {
+		if sample[0] != 0 {
+			t.Fatalf("Sample %d: expected silent left output, got %d", i, sample[0])
+		}
+		if sample[1] != 0 {
+			rightNonZero = true
+		}
+	}
+	if !rightNonZero {
+		t.Fatalf("Expected channel 2 to be audible on the right output")
+	}
+}
+
+func TestAPUDeterministic(t *testing.T) {
+	apu1, sink1 := newTestAPU()
+	apu2, sink2 := newTestAPU()
+	for _, apu := range []*APU{apu1, apu2} {
+		playChannel2(apu, NR51_mix_ch2_right|NR51_mix_ch2_left)
+		runSamples(apu, 2000)
+		apu.Flush()
+	}
+
+	if len(sink1.Samples) != len(sink2.Samples) {
+		t.Fatalf("Sample counts differ: %d vs %d", len(sink1.Samples), len(sink2.Samples))
+	}
+	for i := range sink1.Samples {
+		if sink1.Samples[i] != sink2.Samples[i] {
+			t.Fatalf("Sample %d differs: %v vs %v", i, sink1.Samples[i], sink2.Samples[i])
+		}
+	}
+}
+
+func TestToneSinkMixesSquareWave(t *testing.T) {
+	buffer := &BufferSink{}
+	tones := NewToneSink(buffer)
+	// 4410Hz gives 5 samples high then 5 low, for 20 samples
+	tones.Play(4410, 20*time.Second/AudioSampleRate)
+
+	samples := make([][2]uint8, 30)
+	samples[0] = [2]uint8{0xF0, 0x10}
+	tones.WriteSamples(samples)
+	if samples[1][0] != 0 {
+		t.Fatalf("Expected the samples passed in to be left unchanged")
+	}
+	expected := [2]uint8{0xFF, 0x10 + ToneLevel}
+	if buffer.Samples[0] != expected {
+		t.Errorf("Expected first sample %v, got %v", expected, buffer.Samples[0])
+	}
+	for i, sample := range buffer.Samples[1:] {
+		var level uint8
+		if i+1 < 20 && (i+1)%10 < 5 {
+			level = ToneLevel
+		}
+		if sample != [2]uint8{level, level} {
+			t.Errorf("Sample %d is %v, expected %d", i+1, sample, level)
+		}
+	}
+
+	// Once the tone has finished samples pass straight through
+	buffer.Reset()
+	tones.WriteSamples(samples[1:])
+	for i, sample := range buffer.Samples {
+		if sample != [2]uint8{} {
+			t.Fatalf("Sample %d is %v after the tone finished", i, sample)
+		}
+	}
+}
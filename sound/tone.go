@@ -0,0 +1,62 @@
+package sound
+
+import "time"
+
+// Level added to samples during the high half of a tone's square wave
+const ToneLevel = 0x20
+
+// ToneSink passes samples on to another sink, mixing in square wave tones from hardware outside the APU such as
+// the speaker on HuC3 cartridges. Tones are only heard while the APU is producing samples.
+// Play and WriteSamples must be called from the same goroutine, which is the one running the console
+type ToneSink struct {
+	sink AudioSink
+	// Samples left to play of the current tone, samples in each half of its period and position within the period
+	remaining  int
+	halfPeriod int
+	position   int
+	// Reused buffer of mixed samples passed to the sink
+	mixed [][2]uint8
+}
+
+func NewToneSink(sink AudioSink) *ToneSink {
+	return &ToneSink{sink: sink}
+}
+
+// Play starts a square wave tone of the given frequency in Hz, replacing any tone still playing
+func (t *ToneSink) Play(frequency int, duration time.Duration) {
+	if frequency <= 0 || frequency > AudioSampleRate/2 {
+		return
+	}
+	t.remaining = int(duration * AudioSampleRate / time.Second)
+	t.halfPeriod = AudioSampleRate / frequency / 2
+	t.position = 0
+}
+
+func (t *ToneSink) WriteSamples(samples [][2]uint8) {
+	if t.remaining == 0 {
+		t.sink.WriteSamples(samples)
+		return
+	}
+	t.mixed = append(t.mixed[:0], samples...)
+	for i := range t.mixed {
+		if t.remaining == 0 {
+			break
+		}
+		if t.position < t.halfPeriod {
+			for side := range t.mixed[i] {
+				t.mixed[i][side] = addClipped(t.mixed[i][side], ToneLevel)
+			}
+		}
+		t.position = (t.position + 1) % (2 * t.halfPeriod)
+		t.remaining--
+	}
+	t.sink.WriteSamples(t.mixed)
+}
+
+// Add to a sample, limiting the result to the largest sample value
+func addClipped(sample uint8, level uint8) uint8 {
+	if sample > 0xFF-level {
+		return 0xFF
+	}
+	return sample + level
+}
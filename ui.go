@@ -6,8 +6,11 @@ import (
 	"fmt"
 	"image/color"
 	"math"
+	"os"
 
+	"github.com/cbott/GoEmulate/dap"
 	"github.com/cbott/GoEmulate/gameboy"
+	"github.com/cbott/GoEmulate/link"
 	"github.com/gopxl/pixel/v2"
 	"github.com/gopxl/pixel/v2/backends/opengl"
 )
@@ -30,6 +33,13 @@ const (
 	KEY_SAVESTATE1 = pixel.Key1 // SHIFT + SAVESTATE restores a previously saved state
 	KEY_SAVESTATE2 = pixel.Key2
 	KEY_SAVESTATE3 = pixel.Key3
+	KEY_REWIND     = pixel.KeyBackspace // Hold to run backwards
+	KEY_CHEATS     = pixel.KeyC         // Turn all cheats on or off
+	KEY_SWAP_INPUT = pixel.KeyTab       // Switch which locally linked console the keyboard controls
+	// Debug viewers
+	KEY_TILE_VIEWER     = pixel.KeyF1
+	KEY_TILE_MAP_VIEWER = pixel.KeyF2
+	KEY_OAM_VIEWER      = pixel.KeyF3
 )
 
 var saveStateKeys = [...]pixel.Button{KEY_SAVESTATE1, KEY_SAVESTATE2, KEY_SAVESTATE3}
@@ -39,16 +49,67 @@ type Emulator struct {
 	console *gameboy.Gameboy
 	window  *opengl.Window
 	speed   int
+	// ROM file name, used to name save state files
+	romFile string
+	// Whether a movie was playing as of the last update, so the result can be reported when it ends
+	playingMovie bool
+	// Terminal debugger, nil unless enabled
+	debugConsole *debugConsole
+	// Debug Adapter Protocol server, nil unless enabled
+	dapServer *dap.Server
+	// Second console connected by a local link cable and the scheduler running both, nil unless enabled
+	linked        *link.Lockstep
+	second        *gameboy.Gameboy
+	secondRomFile string
+	// Whether the keyboard controls the second console instead of the first
+	controlSecond bool
+	// VRAM viewer windows, nil while closed
+	tileViewer    *tileViewer
+	tileMapViewer *tileMapViewer
+	oamViewer     *oamViewer
 }
 
 // update runs 1 or more frames worth of CPU cycles on the emulator core (depending on specified speed),
 // processes inputs from the keyboard, and updates the display to match the new state of the emulator
 func update(emulator *Emulator) {
-	// Run the console for 1 frame, or multiple frames for "fast-forwarding"/speed-up
-	for i := 0; i < emulator.speed; i++ {
-		emulator.console.RunNextFrame()
+	if emulator.debugConsole != nil {
+		emulator.debugConsole.poll()
+	}
+	if emulator.dapServer != nil {
+		emulator.dapServer.Poll()
+	}
+	// While stopped in the debugger the console must not change state, including from joypad input
+	paused := emulator.console.DebuggerPaused()
+
+	if emulator.linked != nil {
+		// Linked consoles can't rewind, as the other console would not rewind with them
+		for i := 0; i < emulator.speed; i++ {
+			emulator.linked.RunNextFrame()
+		}
+	} else if emulator.window.Pressed(KEY_REWIND) && !paused {
+		// Step back through history at the current speed, the screen is restored along with everything else
+		emulator.console.Rewind(emulator.speed)
+	} else {
+		// Run the console for 1 frame, or multiple frames for "fast-forwarding"/speed-up
+		for i := 0; i < emulator.speed; i++ {
+			emulator.console.RunNextFrame()
+		}
+	}
+	if emulator.second != nil {
+		render(emulator.window, &emulator.console.ScreenData, &emulator.second.ScreenData)
+	} else {
+		render(emulator.window, &emulator.console.ScreenData)
+	}
+	updateViewers(emulator)
+
+	if emulator.playingMovie && !emulator.console.MoviePlaying() {
+		emulator.playingMovie = false
+		if frame, desynced := emulator.console.MovieDesyncFrame(); desynced {
+			fmt.Printf("Movie finished, desynced at frame %d\n", frame)
+		} else {
+			fmt.Println("Movie finished")
+		}
 	}
-	render(emulator.window, &emulator.console.ScreenData)
 
 	joypadstate := gameboy.ButtonState{
 		BtnA:      emulator.window.Pressed(KEY_A),
@@ -60,11 +121,24 @@ func update(emulator *Emulator) {
 		BtnUp:     emulator.window.Pressed(KEY_UP),
 		BtnDown:   emulator.window.Pressed(KEY_DOWN),
 	}
-	emulator.console.SetButtonStates(&joypadstate)
+	// The keyboard controls one console, and the other sees no buttons pressed
+	console, romFile := emulator.controlled()
+	if emulator.second != nil && emulator.window.JustPressed(KEY_SWAP_INPUT) {
+		console.SetButtonStates(&gameboy.ButtonState{})
+		emulator.controlSecond = !emulator.controlSecond
+		console, romFile = emulator.controlled()
+		fmt.Printf("Keyboard controls %s\n", romFile)
+	}
+	if !paused {
+		console.SetButtonStates(&joypadstate)
+	}
 
 	// Save to cartridge
 	if emulator.window.JustPressed(KEY_WRITE_RAM) {
 		emulator.console.SaveCartridgeRAM()
+		if emulator.second != nil {
+			emulator.second.SaveCartridgeRAM()
+		}
 	}
 
 	// Emulation speed
@@ -79,61 +153,229 @@ func update(emulator *Emulator) {
 		fmt.Printf("Decreased speed to %v\n", emulator.speed)
 	}
 
-	// CPU States
+	if emulator.window.JustPressed(KEY_CHEATS) {
+		enabled := !emulator.console.CheatsEnabled()
+		emulator.console.SetCheatsEnabled(enabled)
+		if enabled {
+			fmt.Println("Cheats on")
+		} else {
+			fmt.Println("Cheats off")
+		}
+	}
+
+	// Save States
 	for i := 0; i < 3; i++ {
 		if emulator.window.JustPressed(saveStateKeys[i]) {
-			var success bool
+			var err error
 			var action string
+			filename := saveStateFileName(romFile, i)
 
 			if emulator.window.Pressed(pixel.KeyLeftShift) || emulator.window.Pressed(pixel.KeyRightShift) {
 				// Recall
-				success = emulator.console.RecallState(i)
+				err = loadStateFile(console, filename)
 				action = "recall"
 			} else {
 				// Store
-				success = emulator.console.StoreState(i)
+				err = writeStateFile(console, filename)
 				action = "store"
 			}
 
-			if success {
-				fmt.Printf("action %v:%d succeeded\n", action, i+1)
+			if err == nil {
+				fmt.Printf("action %v:%d succeeded (%s)\n", action, i+1, filename)
 			} else {
-				fmt.Printf("action %v:%d failed\n", action, i+1)
+				fmt.Printf("action %v:%d failed: %v\n", action, i+1, err)
 			}
 		}
 	}
 }
 
-// render displays a 2D array of RGB triplets, data, to the window with appropriate scaling
-func render(window *opengl.Window, data *[gameboy.ScreenWidth][gameboy.ScreenHeight][3]uint8) {
-	// Convert RGB array to PictureData that can be consumed by pixel
-	picture := pixel.PictureData{
-		Pix:    make([]color.RGBA, gameboy.ScreenWidth*gameboy.ScreenHeight),
-		Stride: gameboy.ScreenWidth,
-		Rect:   pixel.R(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight),
+// Return the console controlled by the keyboard and its ROM file name
+func (emulator *Emulator) controlled() (*gameboy.Gameboy, string) {
+	if emulator.controlSecond {
+		return emulator.second, emulator.secondRomFile
+	}
+	return emulator.console, emulator.romFile
+}
+
+// Open or close viewers as requested and redraw those which are open
+func updateViewers(emulator *Emulator) {
+	if emulator.window.JustPressed(KEY_TILE_VIEWER) {
+		if emulator.tileViewer == nil {
+			viewer, err := newTileViewer()
+			if err != nil {
+				fmt.Printf("Unable to open tile viewer: %v\n", err)
+			}
+			emulator.tileViewer = viewer
+		} else {
+			emulator.tileViewer.close()
+			emulator.tileViewer = nil
+		}
+	}
+	if emulator.tileViewer != nil && !emulator.tileViewer.update(emulator) {
+		emulator.tileViewer = nil
 	}
 
-	for x := 0; x < gameboy.ScreenWidth; x++ {
-		column := data[x]
-		for y := 0; y < gameboy.ScreenHeight; y++ {
-			rgb := color.RGBA{R: column[y][0], G: column[y][1], B: column[y][2], A: 0xFF}
-			picture.Pix[(gameboy.ScreenHeight-1-y)*gameboy.ScreenWidth+x] = rgb
+	if emulator.window.JustPressed(KEY_TILE_MAP_VIEWER) {
+		if emulator.tileMapViewer == nil {
+			viewer, err := newTileMapViewer()
+			if err != nil {
+				fmt.Printf("Unable to open tile map viewer: %v\n", err)
+			}
+			emulator.tileMapViewer = viewer
+		} else {
+			emulator.tileMapViewer.close()
+			emulator.tileMapViewer = nil
 		}
 	}
+	if emulator.tileMapViewer != nil && !emulator.tileMapViewer.update(emulator) {
+		emulator.tileMapViewer = nil
+	}
+
+	if emulator.window.JustPressed(KEY_OAM_VIEWER) {
+		if emulator.oamViewer == nil {
+			viewer, err := newOAMViewer()
+			if err != nil {
+				fmt.Printf("Unable to open OAM viewer: %v\n", err)
+			}
+			emulator.oamViewer = viewer
+		} else {
+			emulator.oamViewer.close()
+			emulator.oamViewer = nil
+		}
+	}
+	if emulator.oamViewer != nil && !emulator.oamViewer.update(emulator) {
+		emulator.oamViewer = nil
+	}
+}
+
+// Generate a name for a save state file based on the ROM file name and slot index (filename.ss1)
+func saveStateFileName(romFile string, slot int) string {
+	return fmt.Sprintf("%s.ss%d", romFile, slot+1)
+}
+
+// Write the console state to a save state file
+func writeStateFile(console *gameboy.Gameboy, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = console.SaveStateTo(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Load the console state from a save state file
+func loadStateFile(console *gameboy.Gameboy, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return console.LoadStateFrom(f)
+}
+
+// Generate the name of the cheat file for a ROM (filename.cht)
+func cheatFileName(romFile string) string {
+	return romFile + ".cht"
+}
+
+// Load cheats from a cheat file
+func loadCheatFile(console *gameboy.Gameboy, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cheats, err := gameboy.LoadCheats(f)
+	if err != nil {
+		return err
+	}
+	console.SetCheats(cheats)
+	return nil
+}
+
+// Write the console's cheats to a cheat file
+func writeCheatFile(console *gameboy.Gameboy, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = gameboy.SaveCheats(f, console.Cheats())
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Load a movie file and start playing it back
+func playMovieFile(console *gameboy.Gameboy, filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	// Clear the screen, also sets color for areas of window not filled by Game Boy screen
+	movie, err := gameboy.LoadMovie(f)
+	if err != nil {
+		return err
+	}
+	return console.PlayMovie(movie)
+}
+
+// Write a recorded movie to a file
+func writeMovieFile(movie *gameboy.Movie, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	err = movie.Save(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// render displays one or more screens, each a 2D array of RGB triplets, side by side in the window with appropriate
+// scaling
+func render(window *opengl.Window, screens ...*[gameboy.ScreenWidth][gameboy.ScreenHeight][3]uint8) {
+	// Clear the screen, also sets color for areas of window not filled by Game Boy screens
 	bg := color.RGBA{R: 0x00, G: 0x00, B: 0x00, A: 0xFF}
 	window.Clear(bg)
 
-	// Scale the Game Boy screen to maximize the size within the window
-	// scale = min(windowX/gameboyX, windowY/gameboyY)
+	// Scale the Game Boy screens to maximize their size within the window
+	// scale = min(windowX/(screens*gameboyX), windowY/gameboyY)
 	windowSize := window.Bounds().Size()
-	divisor := pixel.V(1.0/gameboy.ScreenWidth, 1.0/gameboy.ScreenHeight)
+	divisor := pixel.V(1.0/float64(len(screens)*gameboy.ScreenWidth), 1.0/gameboy.ScreenHeight)
 	scale := math.Min(windowSize.ScaledXY(divisor).XY())
 
-	// Draw the Game Boy screen to the window
-	sprite := pixel.NewSprite(&picture, pixel.R(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight))
-	sprite.Draw(window, pixel.IM.Scaled(pixel.ZV, scale).Moved(window.Bounds().Center()))
+	for i, data := range screens {
+		// Convert RGB array to PictureData that can be consumed by pixel
+		picture := pixel.PictureData{
+			Pix:    make([]color.RGBA, gameboy.ScreenWidth*gameboy.ScreenHeight),
+			Stride: gameboy.ScreenWidth,
+			Rect:   pixel.R(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight),
+		}
+
+		for x := 0; x < gameboy.ScreenWidth; x++ {
+			column := data[x]
+			for y := 0; y < gameboy.ScreenHeight; y++ {
+				rgb := color.RGBA{R: column[y][0], G: column[y][1], B: column[y][2], A: 0xFF}
+				picture.Pix[(gameboy.ScreenHeight-1-y)*gameboy.ScreenWidth+x] = rgb
+			}
+		}
+
+		// Draw the Game Boy screen to the window, screens are centered as a group
+		offset := (float64(i) - float64(len(screens)-1)/2) * gameboy.ScreenWidth * scale
+		sprite := pixel.NewSprite(&picture, pixel.R(0, 0, gameboy.ScreenWidth, gameboy.ScreenHeight))
+		sprite.Draw(window, pixel.IM.Scaled(pixel.ZV, scale).Moved(window.Bounds().Center().Add(pixel.V(offset, 0))))
+	}
 
 	window.Update()
 }
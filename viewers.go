@@ -0,0 +1,259 @@
+// Debug Viewers
+// this file handles the extra windows which show the contents of VRAM and OAM while the emulator runs
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"os"
+
+	"github.com/cbott/GoEmulate/gameboy"
+	"github.com/gopxl/pixel/v2"
+	"github.com/gopxl/pixel/v2/backends/opengl"
+)
+
+// Keys handled by the viewer windows while they have focus
+const (
+	KEY_VIEWER_PALETTE = pixel.KeyTab // Cycle through BGP, OBP0 and OBP1
+	KEY_VIEWER_EXPORT  = pixel.KeyE   // Write what the viewer shows to a PNG file next to the ROM
+	KEY_VIEWER_MAP     = pixel.KeyM   // Switch between the 9800 and 9C00 tile maps
+	KEY_VIEWER_OVERLAY = pixel.KeyO   // Show or hide the outlines of the visible background and window
+	KEY_VIEWER_UP      = pixel.KeyUp  // Select the previous screen line
+	KEY_VIEWER_DOWN    = pixel.KeyDown
+)
+
+// debugWindow is an extra window displaying an image built from the console state
+type debugWindow struct {
+	window *opengl.Window
+	// Transformation used to draw the last image, to map the mouse position back onto it
+	matrix pixel.Matrix
+	bounds pixel.Rect
+	// Title last set, so the window is only retitled when it changes
+	title string
+}
+
+// Open a window sized to show an image of the given size at the given scale
+func newDebugWindow(title string, width int, height int, scale float64) (*debugWindow, error) {
+	win, err := opengl.NewWindow(opengl.WindowConfig{
+		Title:     title,
+		Bounds:    pixel.R(0, 0, float64(width)*scale, float64(height)*scale),
+		VSync:     false,
+		Resizable: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &debugWindow{window: win, title: title}, nil
+}
+
+// Draw an image scaled to fill as much of the window as possible
+func (w *debugWindow) draw(img image.Image) {
+	picture := pixel.PictureDataFromImage(img)
+	w.bounds = picture.Bounds()
+
+	w.window.Clear(color.RGBA{R: 0x00, G: 0x00, B: 0x00, A: 0xFF})
+	windowSize := w.window.Bounds().Size()
+	scale := math.Min(windowSize.X/w.bounds.W(), windowSize.Y/w.bounds.H())
+	w.matrix = pixel.IM.Scaled(pixel.ZV, scale).Moved(w.window.Bounds().Center())
+	pixel.NewSprite(picture, w.bounds).Draw(w.window, w.matrix)
+}
+
+// Return the pixel of the last image drawn which is under the mouse, false if the mouse is not over it
+func (w *debugWindow) mousePixel() (int, int, bool) {
+	if !w.window.MouseInsideWindow() {
+		return 0, 0, false
+	}
+	// Sprites are drawn centered on the origin, with the top row of the image at the top
+	position := w.matrix.Unproject(w.window.MousePosition())
+	x := math.Floor(position.X + w.bounds.W()/2)
+	y := math.Floor(w.bounds.H()/2 - position.Y)
+	if x < 0 || y < 0 || x >= w.bounds.W() || y >= w.bounds.H() {
+		return 0, 0, false
+	}
+	return int(x), int(y), true
+}
+
+func (w *debugWindow) setTitle(title string) {
+	if title != w.title {
+		w.window.SetTitle(title)
+		w.title = title
+	}
+}
+
+func (w *debugWindow) close() {
+	w.window.Destroy()
+}
+
+// Write an image or dump from a viewer to a file
+func writeViewerFile(filename string, write func(f *os.File) error) {
+	f, err := os.Create(filename)
+	if err == nil {
+		err = write(f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Printf("Unable to write %s: %v\n", filename, err)
+	} else {
+		fmt.Printf("Wrote %s\n", filename)
+	}
+}
+
+// Tile viewer window scale, the tile sheet is much smaller than the screen
+const tileViewerScale = 3
+
+// tileViewer shows every tile in VRAM, with details of the tile under the mouse in the title
+type tileViewer struct {
+	*debugWindow
+	palette gameboy.TilePalette
+}
+
+func newTileViewer() (*tileViewer, error) {
+	w, err := newDebugWindow("Tiles", gameboy.TileSheetWidth, gameboy.TileSheetHeight, tileViewerScale)
+	if err != nil {
+		return nil, err
+	}
+	return &tileViewer{debugWindow: w, palette: gameboy.TilePaletteBGP}, nil
+}
+
+// Redraw the tile sheet and handle input, returns false once the window has been closed
+func (v *tileViewer) update(emulator *Emulator) bool {
+	if v.window.Closed() {
+		v.close()
+		return false
+	}
+
+	if v.window.JustPressed(KEY_VIEWER_PALETTE) {
+		v.palette = v.palette.Next()
+	}
+	if v.window.JustPressed(KEY_VIEWER_EXPORT) {
+		writeViewerFile(emulator.romFile+".tiles.png", func(f *os.File) error {
+			return emulator.console.WriteTileSheetPNG(f, v.palette)
+		})
+	}
+
+	v.draw(emulator.console.TileSheet(v.palette))
+	title := fmt.Sprintf("Tiles (%v)", v.palette)
+	if x, y, ok := v.mousePixel(); ok {
+		if tile, ok := gameboy.TileSheetTileAt(x, y); ok {
+			title += " - " + tile.String()
+		}
+	}
+	v.setTitle(title)
+	v.window.Update()
+	return true
+}
+
+// Tile map viewer window scale
+const tileMapViewerScale = 2
+
+// tileMapViewer shows one of the two background tile maps with the visible areas outlined,
+// with details of the map entry under the mouse in the title
+type tileMapViewer struct {
+	*debugWindow
+	address uint16
+	overlay bool
+}
+
+func newTileMapViewer() (*tileMapViewer, error) {
+	w, err := newDebugWindow("Tile Map", gameboy.TileMapSize, gameboy.TileMapSize, tileMapViewerScale)
+	if err != nil {
+		return nil, err
+	}
+	return &tileMapViewer{debugWindow: w, address: gameboy.TileMapAddressLow, overlay: true}, nil
+}
+
+// Redraw the tile map and handle input, returns false once the window has been closed
+func (v *tileMapViewer) update(emulator *Emulator) bool {
+	if v.window.Closed() {
+		v.close()
+		return false
+	}
+
+	if v.window.JustPressed(KEY_VIEWER_MAP) {
+		if v.address == gameboy.TileMapAddressLow {
+			v.address = gameboy.TileMapAddressHigh
+		} else {
+			v.address = gameboy.TileMapAddressLow
+		}
+	}
+	if v.window.JustPressed(KEY_VIEWER_OVERLAY) {
+		v.overlay = !v.overlay
+	}
+	if v.window.JustPressed(KEY_VIEWER_EXPORT) {
+		writeViewerFile(fmt.Sprintf("%s.%04X.png", emulator.romFile, v.address), func(f *os.File) error {
+			return emulator.console.WriteTileMapPNG(f, v.address, v.overlay)
+		})
+	}
+
+	v.draw(emulator.console.TileMap(v.address, v.overlay))
+	view := emulator.console.TileMapView(v.address)
+	title := fmt.Sprintf("Tile Map %04X", v.address)
+	if view.Background {
+		title += " (BG)"
+	}
+	if view.Window {
+		title += " (Window)"
+	}
+	if x, y, ok := v.mousePixel(); ok {
+		if entry, ok := emulator.console.TileMapEntryAt(v.address, x, y); ok {
+			title += " - " + entry.String()
+		}
+	}
+	v.setTitle(title)
+	v.window.Update()
+	return true
+}
+
+// OAM viewer window scale
+const oamViewerScale = 4
+
+// oamViewer shows every sprite in OAM, outlining those on a selected screen line in green or in red if dropped
+// by the sprites per line limit, with details of the sprite under the mouse in the title
+type oamViewer struct {
+	*debugWindow
+	line int
+}
+
+func newOAMViewer() (*oamViewer, error) {
+	w, err := newDebugWindow("OAM", gameboy.OAMSheetWidth, gameboy.OAMSheetHeight, oamViewerScale)
+	if err != nil {
+		return nil, err
+	}
+	return &oamViewer{debugWindow: w}, nil
+}
+
+// Redraw the sprites and handle input, returns false once the window has been closed
+func (v *oamViewer) update(emulator *Emulator) bool {
+	if v.window.Closed() {
+		v.close()
+		return false
+	}
+
+	if v.window.JustPressed(KEY_VIEWER_UP) || v.window.Repeated(KEY_VIEWER_UP) {
+		v.line = (v.line + gameboy.ScreenHeight - 1) % gameboy.ScreenHeight
+	}
+	if v.window.JustPressed(KEY_VIEWER_DOWN) || v.window.Repeated(KEY_VIEWER_DOWN) {
+		v.line = (v.line + 1) % gameboy.ScreenHeight
+	}
+	if v.window.JustPressed(KEY_VIEWER_EXPORT) {
+		writeViewerFile(emulator.romFile+".oam.json", func(f *os.File) error {
+			return emulator.console.WriteOAMJSON(f)
+		})
+	}
+
+	v.draw(emulator.console.OAMSheet(v.line))
+	onLine := emulator.console.SpritesOnLine(v.line)
+	title := fmt.Sprintf("OAM line %d: %d drawn, %d dropped", v.line, len(onLine.Drawn), len(onLine.Dropped))
+	if x, y, ok := v.mousePixel(); ok {
+		if index, ok := gameboy.OAMSheetSpriteAt(x, y); ok {
+			title += " - " + emulator.console.Sprites()[index].String()
+		}
+	}
+	v.setTitle(title)
+	v.window.Update()
+	return true
+}